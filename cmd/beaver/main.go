@@ -2,20 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
 	"go.uber.org/fx"
 	"google.golang.org/grpc"
 
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/blob"
 	"github.com/KirillMironov/beaver/internal/jwt"
 	"github.com/KirillMironov/beaver/internal/log"
+	"github.com/KirillMironov/beaver/internal/metrics"
 	"github.com/KirillMironov/beaver/internal/server"
 	"github.com/KirillMironov/beaver/internal/server/config"
 	"github.com/KirillMironov/beaver/internal/server/transport"
 	"github.com/KirillMironov/beaver/internal/server/transport/proto"
+	"github.com/KirillMironov/beaver/internal/session"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+const (
+	auditLogFilename = ".audit.log"
+	auditKeyFilename = ".audit.key"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	fx.New(options()).Run()
 }
 
@@ -29,33 +49,72 @@ func options() fx.Option {
 				},
 				fx.As(new(jwt.TokenManager[server.User])),
 			),
+			fx.Annotate(
+				func(cfg config.Config) *session.MemoryStore {
+					return session.NewMemoryStore(cfg.JWT.TokenTTL, cfg.Sessions.Limit)
+				},
+				fx.As(new(session.Store)),
+			),
+			fx.Annotate(
+				func(cfg config.Config) *audit.FileLog {
+					return newAuditLog(cfg.DataDir)
+				},
+				fx.As(new(audit.Logger)),
+			),
 			fx.Annotate(log.New, fx.As(new(log.Logger))),
-			fx.Annotate(server.NewStorage, fx.As(new(transport.Storage))),
+			newBlobStore,
+			server.NewKeyLocks,
 			fx.Annotate(
-				func(cfg config.Config, logger log.Logger, tokenManager jwt.TokenManager[server.User]) (*server.Authenticator, error) {
-					return server.NewAuthenticator(cfg.DataDir, logger, tokenManager)
+				func(cfg config.Config, store blob.Store, keyring server.Keyring, keyLocks *server.KeyLocks) *server.Storage {
+					defaultQuota := server.Quota{MaxBytes: cfg.Quota.MaxBytes, MaxFiles: cfg.Quota.MaxFiles}
+					linkPolicy := server.ShareLinkPolicy{
+						MaxTTL:    cfg.ShareLinks.MaxTTL,
+						KDFParams: server.Argon2Params{Time: cfg.KDF.Time, Memory: cfg.KDF.Memory, Threads: cfg.KDF.Threads},
+					}
+					return server.NewStorage(store, keyring, keyLocks, cfg.Uploads.Timeout, defaultQuota, linkPolicy)
 				},
-				fx.As(new(transport.Authenticator)),
+				fx.As(new(transport.Storage)),
 			),
+			func(cfg config.Config, store blob.Store, logger log.Logger, tokenManager jwt.TokenManager[server.User], sessions session.Store, auditLog audit.Logger, keyLocks *server.KeyLocks) (*server.Authenticator, error) {
+				kdfParams := server.Argon2Params{Time: cfg.KDF.Time, Memory: cfg.KDF.Memory, Threads: cfg.KDF.Threads}
+				return server.NewAuthenticator(cfg.DataDir, store, logger, tokenManager, sessions, auditLog, keyLocks, kdfParams)
+			},
+			func(authenticator *server.Authenticator) transport.Authenticator { return authenticator },
+			func(authenticator *server.Authenticator) transport.Admin { return authenticator },
+			func(authenticator *server.Authenticator) server.Keyring { return authenticator },
+			metrics.NewRegistry,
+			transport.NewMetrics,
+			newTracer,
+			transport.NewTracing,
+			transport.NewAuthentication,
+			transport.NewAuditing,
 			fx.Annotate(transport.NewStorageService, fx.As(new(proto.StorageServer))),
 			fx.Annotate(transport.NewAuthenticatorService, fx.As(new(proto.AuthenticatorServer))),
+			fx.Annotate(transport.NewAdminService, fx.As(new(proto.AdminServer))),
 		),
 		fx.Invoke(
 			startServer,
+			startCollectors,
 		),
 	)
 }
 
-func startServer(lifecycle fx.Lifecycle, cfg config.Config, logger log.Logger, storage proto.StorageServer, authenticator proto.AuthenticatorServer) error {
+func startServer(lifecycle fx.Lifecycle, cfg config.Config, logger log.Logger, storage proto.StorageServer, authenticator proto.AuthenticatorServer, admin proto.AdminServer, registry *metrics.Registry, rpcMetrics *transport.Metrics, tracing *transport.Tracing, authentication *transport.Authentication, auditing *transport.Auditing, store blob.Store) error {
 	listener, err := net.Listen("tcp", cfg.ServerAddress)
 	if err != nil {
 		return err
 	}
 
-	grpcServer := grpc.NewServer()
+	// Requests are authenticated after they are traced and counted, so the rejected ones are traced and counted as well,
+	// and audited once authenticated, so the entries name the user.
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(tracing.UnaryInterceptor(), rpcMetrics.UnaryInterceptor(), authentication.UnaryInterceptor(), auditing.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(tracing.StreamInterceptor(), rpcMetrics.StreamInterceptor(), authentication.StreamInterceptor(), auditing.StreamInterceptor()),
+	)
 
 	proto.RegisterStorageServer(grpcServer, storage)
 	proto.RegisterAuthenticatorServer(grpcServer, authenticator)
+	proto.RegisterAdminServer(grpcServer, admin)
 
 	lifecycle.Append(fx.Hook{
 		OnStart: func(context.Context) error {
@@ -72,5 +131,172 @@ func startServer(lifecycle fx.Lifecycle, cfg config.Config, logger log.Logger, s
 		},
 	})
 
+	if cfg.Metrics.Address == "" {
+		return nil
+	}
+
+	return startMetricsServer(lifecycle, cfg, logger, registry, store)
+}
+
+// startMetricsServer serves the metrics over HTTP and periodically refreshes the storage gauges.
+func startMetricsServer(lifecycle fx.Lifecycle, cfg config.Config, logger log.Logger, registry *metrics.Registry, store blob.Store) error {
+	listener, err := net.Listen("tcp", cfg.Metrics.Address)
+	if err != nil {
+		return err
+	}
+
+	var (
+		users = registry.NewGauge("beaver_users", "Users with a record in the data directory.")
+		files = registry.NewGauge("beaver_files", "Current files of all users.")
+		bytes = registry.NewGauge("beaver_stored_bytes", "Stored size of the files, versions and pending uploads of all users.")
+	)
+
+	refreshStats := func() {
+		stats, err := server.CollectStats(store, cfg.DataDir)
+		if err != nil {
+			logger.Errorf("failed to collect storage stats: %v", err)
+			return
+		}
+
+		users.Set(float64(stats.Users))
+		files.Set(float64(stats.Files))
+		bytes.Set(float64(stats.Bytes))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+
+	var (
+		httpServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		done       = make(chan struct{})
+	)
+
+	lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			go func() {
+				if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Errorf("failed to serve metrics: %v", err)
+				}
+			}()
+			ticker := time.NewTicker(cfg.Metrics.StatsInterval)
+
+			go func() {
+				defer ticker.Stop()
+
+				refreshStats()
+				for {
+					select {
+					case <-ticker.C:
+						refreshStats()
+					case <-done:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			close(done)
+			return httpServer.Shutdown(ctx)
+		},
+	})
+
 	return nil
 }
+
+// newTracer returns the tracer of the exporter selected by the configuration.
+// Without an exporter the tracer records nothing.
+func newTracer(lifecycle fx.Lifecycle, cfg config.Config, logger log.Logger) (*trace.Tracer, error) {
+	var exporter *trace.WriterExporter
+
+	switch cfg.Tracing.Exporter {
+	case "none":
+		return trace.NewTracer(nil, logger), nil
+	case "stdout":
+		exporter = trace.NewWriterExporter(os.Stdout)
+	case "file":
+		if cfg.Tracing.File == "" {
+			return nil, errors.New("file tracing exporter requires a file")
+		}
+
+		var err error
+		if exporter, err = trace.NewFileExporter(cfg.Tracing.File); err != nil {
+			return nil, err
+		}
+
+		lifecycle.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				return exporter.Close()
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Tracing.Exporter)
+	}
+
+	return trace.NewTracer(exporter, logger), nil
+}
+
+// newBlobStore returns the store of the encrypted files selected by the configuration.
+func newBlobStore(cfg config.Config) (blob.Store, error) {
+	switch cfg.Blob.Backend {
+	case "local":
+		return blob.NewLocalStore(cfg.DataDir), nil
+	case "memory":
+		return blob.NewMemoryStore(), nil
+	case "s3":
+		s3 := cfg.Blob.S3
+		if s3.Endpoint == "" || s3.Bucket == "" {
+			return nil, errors.New("s3 blob backend requires an endpoint and a bucket")
+		}
+		return blob.NewS3Store(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKeyID, s3.SecretAccessKey, s3.Timeout), nil
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Blob.Backend)
+	}
+}
+
+// newAuditLog returns the audit log in the data dir along with the key its entries are chained with.
+func newAuditLog(dataDir string) *audit.FileLog {
+	return audit.NewFileLog(filepath.Join(dataDir, auditLogFilename), filepath.Join(dataDir, auditKeyFilename))
+}
+
+// startCollectors periodically removes abandoned upload sessions and ended share links.
+func startCollectors(lifecycle fx.Lifecycle, cfg config.Config, store blob.Store, logger log.Logger) {
+	done := make(chan struct{})
+
+	lifecycle.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			ticker := time.NewTicker(cfg.Uploads.CollectInterval)
+
+			go func() {
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C:
+						removed, err := server.CollectUploads(store, cfg.DataDir, cfg.Uploads.Timeout)
+						if err != nil {
+							logger.Errorf("failed to collect upload sessions: %v", err)
+						}
+						if removed > 0 {
+							logger.Infof("removed %d abandoned upload sessions", removed)
+						}
+						removed, err = server.CollectShareLinks(store)
+						if err != nil {
+							logger.Errorf("failed to collect share links: %v", err)
+						}
+						if removed > 0 {
+							logger.Infof("removed %d ended share links", removed)
+						}
+					case <-done:
+						return
+					}
+				}
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			close(done)
+			return nil
+		},
+	})
+}
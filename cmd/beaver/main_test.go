@@ -1,9 +1,21 @@
 package main
 
 import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"go.uber.org/fx"
+
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/jwt"
+	"github.com/KirillMironov/beaver/internal/log/observer"
+	"github.com/KirillMironov/beaver/internal/server"
+	"github.com/KirillMironov/beaver/internal/session"
 )
 
 func TestOptions(t *testing.T) {
@@ -13,3 +25,102 @@ func TestOptions(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRunCommand_RotateMasterKey(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	logger := observer.New()
+
+	_, err := server.NewAuthenticator(dataDir, blob.NewLocalStore(dataDir), logger, jwt.NewManager[server.User]("secret", time.Hour),
+		session.NewMemoryStore(time.Hour, 10), newAuditLog(dataDir), server.NewKeyLocks(), server.Argon2Params{Time: 1, Memory: 1024, Threads: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logger.First()
+	masterKey := strings.Trim(log[strings.LastIndex(log, " ")+1:], `"`)
+
+	var (
+		args   = []string{"rotate-master-key", "-data-dir", dataDir}
+		stdout = &strings.Builder{}
+		stderr = &strings.Builder{}
+	)
+
+	if code := runCommand(args, strings.NewReader("invalid\n"), stdout, stderr); code != 1 {
+		t.Fatalf("got exit code %d for invalid master key, want 1", code)
+	}
+
+	if code := runCommand(args, strings.NewReader(masterKey+"\n"), stdout, stderr); code != 0 {
+		t.Fatalf("got exit code %d, want 0: %s", code, stderr)
+	}
+
+	newMasterKey := strings.TrimSpace(stdout.String())
+
+	if len(newMasterKey) != len(masterKey) || newMasterKey == masterKey {
+		t.Fatalf("got new master key %q, want a different key of length %d", newMasterKey, len(masterKey))
+	}
+
+	if _, err = server.RotateMasterKey(dataDir, masterKey); err == nil {
+		t.Fatal("old master key is still valid")
+	}
+
+	if _, err = server.RotateMasterKey(dataDir, newMasterKey); err != nil {
+		t.Fatalf("new master key is invalid: %v", err)
+	}
+}
+
+func TestRunCommand_AuditVerify(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	auditLog := newAuditLog(dataDir)
+
+	for _, action := range []string{"add_user", "upload"} {
+		if err := auditLog.Record(audit.Entry{Action: action, Username: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	args := []string{"audit", "verify", "-data-dir", dataDir}
+
+	stdout := &strings.Builder{}
+
+	if code := runCommand(args, nil, stdout, io.Discard); code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+
+	// The chain starts with a marker.
+	if !strings.HasPrefix(stdout.String(), "3 entries verified") {
+		t.Fatalf("got output %q, want 3 entries verified", stdout)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dataDir, auditLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = []byte(strings.Replace(string(data), `"action":"upload"`, `"action":"download"`, 1))
+
+	if err = os.WriteFile(filepath.Join(dataDir, auditLogFilename), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runCommand(args, nil, io.Discard, io.Discard); code != 1 {
+		t.Fatalf("got exit code %d for a tampered log, want 1", code)
+	}
+
+	if code := runCommand([]string{"audit"}, nil, io.Discard, io.Discard); code != 2 {
+		t.Fatalf("got exit code %d without a subcommand, want 2", code)
+	}
+}
+
+func TestRunCommand_Unknown(t *testing.T) {
+	t.Parallel()
+
+	if code := runCommand([]string{"unknown"}, nil, io.Discard, io.Discard); code != 2 {
+		t.Fatalf("got exit code %d, want 2", code)
+	}
+}
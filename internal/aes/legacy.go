@@ -0,0 +1,34 @@
+package aes
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+)
+
+// decryptLegacy decrypts a stream in the format used before the segmented one:
+// a random IV followed by the AES-CFB ciphertext. The format is not authenticated,
+// so it is only read to keep previously uploaded files accessible.
+func decryptLegacy(src io.Reader, dst io.Writer, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	iv := make([]byte, block.BlockSize())
+
+	if _, err = io.ReadFull(src, iv); err != nil {
+		return err
+	}
+
+	stream := cipher.NewCFBDecrypter(block, iv)
+
+	reader := cipher.StreamReader{
+		S: stream,
+		R: src,
+	}
+
+	_, err = io.Copy(dst, reader)
+
+	return err
+}
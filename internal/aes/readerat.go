@@ -0,0 +1,154 @@
+package aes
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// ReaderAt decrypts arbitrary ranges of a stream, opening only the segments that cover them.
+// Segment i starts at headerSize + i*(segmentSize+16) in the stream.
+// The last segment is authenticated when the reader is created, so a truncated stream is detected
+// even if it is never read to the end. The most recently opened segment is cached,
+// which makes sequential reads through io.SectionReader open every segment once.
+type ReaderAt struct {
+	src      io.ReaderAt
+	aead     cipher.AEAD
+	header   []byte
+	body     int64
+	segments int64
+	size     int64
+
+	mu        sync.Mutex
+	cached    int64
+	plaintext []byte
+	buffer    []byte
+}
+
+// NewReaderAt returns a reader that decrypts the stream of the given size read from src.
+// Legacy streams can't be read at random positions, NewReaderAt returns ErrUnknownFormat for them.
+func NewReaderAt(src io.ReaderAt, size int64, key []byte) (*ReaderAt, error) {
+	header := make([]byte, headerSize)
+
+	if _, err := src.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, ErrUnknownFormat
+		}
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:magicSize], streamMagic) || header[magicSize] != streamVersion {
+		return nil, ErrUnknownFormat
+	}
+
+	aead, err := newSegmentAEAD(key, header[magicSize+1:magicSize+1+saltSize])
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		body          = size - headerSize
+		sealedSegment = int64(segmentSize + aead.Overhead())
+		segments      = (body + sealedSegment - 1) / sealedSegment
+	)
+
+	if body < int64(aead.Overhead()) || segments > math.MaxUint32+1 {
+		return nil, fmt.Errorf("%w: invalid stream size %d", ErrCorrupted, size)
+	}
+
+	r := &ReaderAt{
+		src:      src,
+		aead:     aead,
+		header:   header,
+		body:     body,
+		segments: segments,
+		cached:   -1,
+		buffer:   make([]byte, sealedSegment),
+	}
+
+	last, err := r.segment(segments - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	r.size = (segments-1)*segmentSize + int64(len(last))
+
+	return r, nil
+}
+
+// Size returns the plaintext size of the stream.
+func (r *ReaderAt) Size() int64 {
+	return r.size
+}
+
+func (r *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("aes: negative offset")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int
+
+	for n < len(p) && off < r.size {
+		plaintext, err := r.segment(off / segmentSize)
+		if err != nil {
+			return n, err
+		}
+
+		copied := copy(p[n:], plaintext[off%segmentSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// segment returns the plaintext of the segment with the given index. The result is valid until the next call.
+func (r *ReaderAt) segment(i int64) ([]byte, error) {
+	if i == r.cached {
+		return r.plaintext, nil
+	}
+
+	var (
+		sealedSegment = int64(len(r.buffer))
+		offset        = i * sealedSegment
+		sealed        = r.buffer[:min64(sealedSegment, r.body-offset)]
+	)
+
+	if _, err := r.src.ReadAt(sealed, headerSize+offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: missing segment %d", ErrCorrupted, i)
+		}
+		return nil, err
+	}
+
+	nonce := segmentNonce(r.header, uint32(i), i == r.segments-1)
+
+	plaintext, err := r.aead.Open(r.plaintext[:0], nonce, sealed, r.header)
+	if err != nil {
+		r.cached = -1
+		return nil, fmt.Errorf("%w: segment %d", ErrCorrupted, i)
+	}
+
+	r.cached = i
+	r.plaintext = plaintext
+
+	return plaintext, nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -1,13 +1,53 @@
 package aes
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+
+	"golang.org/x/crypto/hkdf"
 
 	"github.com/KirillMironov/beaver/internal/rand"
 )
 
+// The stream format splits the plaintext into segments of segmentSize bytes
+// and seals each of them with AES-GCM:
+//
+//	header:  magic (4) | version (1) | salt (16) | nonce prefix (7)
+//	segment: ciphertext (up to segmentSize) | tag (16)
+//
+// The segment key is derived from the key and the random salt, so the key can be reused across streams.
+// The nonce of a segment is the nonce prefix followed by the segment counter and a flag marking
+// the last segment, which makes reordering, truncation and extension of the stream detectable.
+// The header is authenticated as additional data of every segment.
+const (
+	streamVersion   = 1
+	segmentSize     = 64 << 10
+	magicSize       = 4
+	saltSize        = 16
+	noncePrefixSize = 7
+	headerSize      = magicSize + 1 + saltSize + noncePrefixSize
+	segmentKeyInfo  = "beaver segment key"
+)
+
+// streamMagic starts every stream. The first byte is outside the charset of rand.Key,
+// so a stream can't be confused with a legacy one, which starts with a printable IV.
+var streamMagic = []byte{0x89, 'B', 'V', 'R'}
+
+var (
+	// ErrCorrupted is returned when the ciphertext was modified, reordered or truncated.
+	ErrCorrupted = errors.New("aes: ciphertext is corrupted")
+	// ErrUnknownFormat is returned when the stream header is not recognized.
+	ErrUnknownFormat = errors.New("aes: unknown stream format")
+)
+
 type Encrypter struct {
 	src io.Reader
 	dst io.Writer
@@ -22,31 +62,16 @@ func NewEncrypter(src io.Reader, dst io.Writer) *Encrypter {
 
 // Encrypt encrypts data from src and writes it to dst.
 func (e Encrypter) Encrypt(key []byte) error {
-	block, err := aes.NewCipher(key)
+	writer, err := NewWriter(e.dst, key)
 	if err != nil {
 		return err
 	}
 
-	iv, err := rand.Key(block.BlockSize())
-	if err != nil {
+	if _, err = io.Copy(writer, e.src); err != nil {
 		return err
 	}
 
-	_, err = e.dst.Write(iv)
-	if err != nil {
-		return err
-	}
-
-	stream := cipher.NewCFBEncrypter(block, iv)
-
-	writer := cipher.StreamWriter{
-		S: stream,
-		W: e.dst,
-	}
-
-	_, err = io.Copy(writer, e.src)
-
-	return err
+	return writer.Close()
 }
 
 type Decrypter struct {
@@ -62,27 +87,252 @@ func NewDecrypter(src io.Reader, dst io.Writer) *Decrypter {
 }
 
 // Decrypt decrypts data from src and writes it to dst.
+// Streams written by older versions in the unauthenticated CFB format are decrypted as well.
 func (d Decrypter) Decrypt(key []byte) error {
-	block, err := aes.NewCipher(key)
+	src := bufio.NewReader(d.src)
+
+	magic, err := src.Peek(magicSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	if !bytes.Equal(magic, streamMagic) {
+		return decryptLegacy(src, d.dst, key)
+	}
+
+	reader, err := NewReader(src, key)
 	if err != nil {
 		return err
 	}
 
-	iv := make([]byte, block.BlockSize())
+	_, err = io.Copy(d.dst, reader)
+
+	return err
+}
+
+type writer struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buffer  []byte
+	counter uint32
+	closed  bool
+}
+
+// NewWriter writes the stream header to dst and returns a writer that encrypts data written to it.
+// Close must be called to seal the last segment, it doesn't close dst.
+func NewWriter(dst io.Writer, key []byte) (io.WriteCloser, error) {
+	salt, err := rand.Bytes(saltSize)
+	if err != nil {
+		return nil, err
+	}
+
+	noncePrefix, err := rand.Bytes(noncePrefixSize)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, streamMagic...)
+	header = append(header, streamVersion)
+	header = append(header, salt...)
+	header = append(header, noncePrefix...)
 
-	_, err = d.src.Read(iv)
+	aead, err := newSegmentAEAD(key, salt)
 	if err != nil {
+		return nil, err
+	}
+
+	if _, err = dst.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &writer{
+		dst:    dst,
+		aead:   aead,
+		header: header,
+		buffer: make([]byte, 0, segmentSize),
+	}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("aes: write to closed writer")
+	}
+
+	var written int
+
+	for len(p) > 0 {
+		// The segment is sealed only when more data arrives,
+		// because the last segment must be sealed with the last flag set.
+		if len(w.buffer) == segmentSize {
+			if err := w.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buffer[len(w.buffer):cap(w.buffer)], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals the last segment.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	return w.seal(true)
+}
+
+func (w *writer) seal(last bool) error {
+	if w.counter == math.MaxUint32 && !last {
+		return errors.New("aes: stream is too long")
+	}
+
+	nonce := segmentNonce(w.header, w.counter, last)
+
+	if _, err := w.dst.Write(w.aead.Seal(nil, nonce, w.buffer, w.header)); err != nil {
 		return err
 	}
 
-	stream := cipher.NewCFBDecrypter(block, iv)
+	w.counter++
+	w.buffer = w.buffer[:0]
+
+	return nil
+}
 
-	reader := cipher.StreamReader{
-		S: stream,
-		R: d.src,
+type reader struct {
+	src       *bufio.Reader
+	aead      cipher.AEAD
+	header    []byte
+	segment   []byte
+	plaintext []byte
+	counter   uint32
+	done      bool
+}
+
+// NewReader reads the stream header from src and returns a reader that decrypts the stream.
+// The reader returns ErrCorrupted if the stream was modified, reordered or truncated.
+// Data is returned only after its segment has been authenticated.
+func NewReader(src io.Reader, key []byte) (io.Reader, error) {
+	header := make([]byte, headerSize)
+
+	if _, err := io.ReadFull(src, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrUnknownFormat
+		}
+		return nil, err
 	}
 
-	_, err = io.Copy(d.dst, reader)
+	if !bytes.Equal(header[:magicSize], streamMagic) || header[magicSize] != streamVersion {
+		return nil, ErrUnknownFormat
+	}
 
-	return err
+	salt := header[magicSize+1 : magicSize+1+saltSize]
+
+	aead, err := newSegmentAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	buffered, ok := src.(*bufio.Reader)
+	if !ok {
+		buffered = bufio.NewReader(src)
+	}
+
+	return &reader{
+		src:     buffered,
+		aead:    aead,
+		header:  header,
+		segment: make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plaintext) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.plaintext)
+	r.plaintext = r.plaintext[n:]
+
+	return n, nil
+}
+
+func (r *reader) open() error {
+	n, err := io.ReadFull(r.src, r.segment)
+
+	var last bool
+
+	switch {
+	case err == nil:
+		// A full segment is the last one only if nothing follows it.
+		if _, err = r.src.Peek(1); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+			last = true
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("%w: missing segment %d", ErrCorrupted, r.counter)
+	default:
+		return err
+	}
+
+	nonce := segmentNonce(r.header, r.counter, last)
+
+	plaintext, err := r.aead.Open(r.segment[:0], nonce, r.segment[:n], r.header)
+	if err != nil {
+		return fmt.Errorf("%w: segment %d", ErrCorrupted, r.counter)
+	}
+
+	r.plaintext = plaintext
+	r.counter++
+	r.done = last
+
+	return nil
+}
+
+func newSegmentAEAD(key, salt []byte) (cipher.AEAD, error) {
+	segmentKey := make([]byte, KeyLength)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte(segmentKeyInfo)), segmentKey); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(segmentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce returns the nonce prefix from the header followed by the counter and the last segment flag.
+func segmentNonce(header []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, noncePrefixSize, noncePrefixSize+5)
+	copy(nonce, header[headerSize-noncePrefixSize:])
+
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+
+	if last {
+		return append(nonce, 1)
+	}
+
+	return append(nonce, 0)
 }
@@ -1,8 +1,15 @@
 package aes
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"io"
 	"strings"
 	"testing"
+
+	"github.com/KirillMironov/beaver/internal/rand"
 )
 
 func TestEncrypterDecrypter(t *testing.T) {
@@ -32,3 +39,275 @@ func TestEncrypterDecrypter(t *testing.T) {
 		t.Fatalf("got %q, want %q", got, message)
 	}
 }
+
+func TestEncrypterDecrypter_Sizes(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("super secret key")
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3 * segmentSize} {
+		message, err := rand.Bytes(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := encrypt(t, message, key)
+
+		if got, want := len(ciphertext), encryptedSize(size); got != want {
+			t.Fatalf("size %d: got ciphertext length %d, want %d", size, got, want)
+		}
+
+		plaintext, err := decrypt(ciphertext, key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		if !bytes.Equal(plaintext, message) {
+			t.Fatalf("size %d: plaintext does not match the message", size)
+		}
+	}
+}
+
+func TestDecrypter_Tampering(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("super secret key")
+
+	message, err := rand.Bytes(2*segmentSize + 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext := encrypt(t, message, key)
+
+	const fullSegment = segmentSize + 16
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{
+			name: "bit flip",
+			modify: func(b []byte) []byte {
+				b[headerSize+10] ^= 1
+				return b
+			},
+		},
+		{
+			name: "header modified",
+			modify: func(b []byte) []byte {
+				b[magicSize+1] ^= 1
+				return b
+			},
+		},
+		{
+			name: "truncated at segment boundary",
+			modify: func(b []byte) []byte {
+				return b[:headerSize+2*fullSegment]
+			},
+		},
+		{
+			name: "truncated inside segment",
+			modify: func(b []byte) []byte {
+				return b[:len(b)-1]
+			},
+		},
+		{
+			name: "segments reordered",
+			modify: func(b []byte) []byte {
+				first := append([]byte(nil), b[headerSize:headerSize+fullSegment]...)
+				copy(b[headerSize:], b[headerSize+fullSegment:headerSize+2*fullSegment])
+				copy(b[headerSize+fullSegment:], first)
+				return b
+			},
+		},
+		{
+			name: "extended",
+			modify: func(b []byte) []byte {
+				return append(b, b[headerSize:headerSize+fullSegment]...)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			modified := tc.modify(append([]byte(nil), ciphertext...))
+
+			if _, err := decrypt(modified, key); !errors.Is(err, ErrCorrupted) {
+				t.Fatalf("got %v, want %v", err, ErrCorrupted)
+			}
+		})
+	}
+}
+
+func TestDecrypter_WrongKey(t *testing.T) {
+	t.Parallel()
+
+	ciphertext := encrypt(t, []byte("message to encrypt"), []byte("super secret key"))
+
+	if _, err := decrypt(ciphertext, []byte("another secret key")); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v, want %v", err, ErrCorrupted)
+	}
+}
+
+func TestDecrypter_Legacy(t *testing.T) {
+	t.Parallel()
+
+	const message = "message to encrypt"
+
+	key := []byte("super secret key")
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv, err := rand.Key(block.BlockSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext := make([]byte, len(message))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(ciphertext, []byte(message))
+
+	plaintext, err := decrypt(append(iv, ciphertext...), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(plaintext); got != message {
+		t.Fatalf("got %q, want %q", got, message)
+	}
+}
+
+func encrypt(t *testing.T, message, key []byte) []byte {
+	t.Helper()
+
+	ciphertext := &bytes.Buffer{}
+
+	if err := NewEncrypter(bytes.NewReader(message), ciphertext).Encrypt(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return ciphertext.Bytes()
+}
+
+func decrypt(ciphertext, key []byte) ([]byte, error) {
+	plaintext := &bytes.Buffer{}
+
+	err := NewDecrypter(bytes.NewReader(ciphertext), plaintext).Decrypt(key)
+
+	return plaintext.Bytes(), err
+}
+
+func encryptedSize(size int) int {
+	segments := size/segmentSize + 1
+	if size > 0 && size%segmentSize == 0 {
+		segments--
+	}
+
+	return headerSize + size + segments*16
+}
+
+func TestReaderAt(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("super secret key")
+
+	for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 7} {
+		message, err := rand.Bytes(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ciphertext := encrypt(t, message, key)
+
+		reader, err := NewReaderAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), key)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+
+		if got, want := reader.Size(), int64(size); got != want {
+			t.Fatalf("got size %d, want %d", got, want)
+		}
+
+		for _, r := range [][2]int{{0, size}, {size / 2, size - size/2}, {size, 0}, {segmentSize - 1, 2}, {1, segmentSize}} {
+			offset, length := r[0], r[1]
+			if offset > size {
+				continue
+			}
+			if offset+length > size {
+				length = size - offset
+			}
+
+			got := make([]byte, length)
+
+			if _, err = io.ReadFull(io.NewSectionReader(reader, int64(offset), int64(length)), got); err != nil {
+				t.Fatalf("size %d, range %d+%d: %v", size, offset, length, err)
+			}
+
+			if want := message[offset : offset+length]; !bytes.Equal(got, want) {
+				t.Fatalf("size %d, range %d+%d: got different plaintext", size, offset, length)
+			}
+		}
+
+		if n, err := reader.ReadAt(make([]byte, 1), int64(size)); n != 0 || err != io.EOF {
+			t.Fatalf("got %d, %v at the end of the stream, want 0, %v", n, err, io.EOF)
+		}
+	}
+}
+
+func TestReaderAt_Corrupted(t *testing.T) {
+	t.Parallel()
+
+	key := []byte("super secret key")
+
+	message, err := rand.Bytes(3 * segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext := encrypt(t, message, key)
+
+	sealedSegment := segmentSize + 16
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[headerSize+sealedSegment+1] ^= 1
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{name: "truncated at segment boundary", ciphertext: ciphertext[:headerSize+2*sealedSegment]},
+		{name: "truncated", ciphertext: ciphertext[:len(ciphertext)-1]},
+		{name: "header only", ciphertext: ciphertext[:headerSize]},
+	}
+
+	for _, tc := range tests {
+		_, err := NewReaderAt(bytes.NewReader(tc.ciphertext), int64(len(tc.ciphertext)), key)
+		if !errors.Is(err, ErrCorrupted) {
+			t.Fatalf("%s: got %v, want %v", tc.name, err, ErrCorrupted)
+		}
+	}
+
+	reader, err := NewReaderAt(bytes.NewReader(tampered), int64(len(tampered)), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = reader.ReadAt(make([]byte, 10), 0); err != nil {
+		t.Fatalf("got %v reading an intact segment", err)
+	}
+
+	if _, err = reader.ReadAt(make([]byte, 10), segmentSize); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v, want %v", err, ErrCorrupted)
+	}
+
+	if _, err = NewReaderAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), []byte("another secret key")); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("got %v for a wrong key, want %v", err, ErrCorrupted)
+	}
+}
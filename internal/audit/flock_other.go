@@ -0,0 +1,11 @@
+//go:build !unix
+
+package audit
+
+import "os"
+
+// lockFile doesn't lock the file, so on these platforms only the mutex of the log serializes appends
+// and the log must not be appended to by several processes at once.
+func lockFile(*os.File, bool) (unlock func(), err error) {
+	return func() {}, nil
+}
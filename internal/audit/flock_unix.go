@@ -0,0 +1,29 @@
+//go:build unix
+
+package audit
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks the file until the returned function is called, waiting for other processes to unlock it.
+// An exclusive lock excludes every other lock, a shared one only exclusive ones.
+func lockFile(file *os.File, exclusive bool) (unlock func(), err error) {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err = syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "flock", Path: file.Name(), Err: err}
+	}
+
+	return func() { _ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN) }, nil
+}
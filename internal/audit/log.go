@@ -0,0 +1,509 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+// Entries are chained: every entry carries the hash of the previous one and its own hash,
+// an HMAC-SHA256 keyed with a secret kept by the server, so an entry that is edited, removed or inserted
+// breaks the chain from that point on, and the chain can't be recomputed without the key.
+// The chain starts with a marker entry chained to the SHA-256 of the lines before it,
+// which were written before the log was chained. Only those lines may be unchained,
+// and a log that has lines but no marker doesn't verify.
+// Removing entries from the end of the log can't be detected from the log alone,
+// the head hash reported by Verify has to be kept elsewhere to detect it.
+const (
+	maxLineSize = 1 << 20
+	keySize     = 32
+
+	// chainAction is the action of the marker entry the chain starts with.
+	chainAction = "start_chain"
+)
+
+var (
+	// ErrTampered is returned by Verify when the hash chain of the log is broken.
+	ErrTampered = errors.New("audit log was tampered with")
+
+	errInvalidKey = errors.New("invalid audit log key")
+)
+
+type (
+	// Entry describes an action of the actor on the user data. Path is the file the action applies to,
+	// Target is the destination of a rename or copy, the recipient of a share, the owner of a shared file,
+	// the id of an upload session or of a version. Result is the gRPC code the action ended with.
+	Entry struct {
+		Time     time.Time `json:"time"`
+		Action   string    `json:"action"`
+		Username string    `json:"username"`
+		Actor    string    `json:"actor"`
+		Path     string    `json:"path,omitempty"`
+		Target   string    `json:"target,omitempty"`
+		Peer     string    `json:"peer,omitempty"`
+		Result   string    `json:"result,omitempty"`
+		Prev     string    `json:"prev,omitempty"`
+		Hash     string    `json:"hash,omitempty"`
+	}
+
+	// Filter selects entries. Empty fields match every entry, Until is exclusive.
+	// A positive Limit keeps only the latest Limit matching entries.
+	Filter struct {
+		Username string
+		Action   string
+		Since    time.Time
+		Until    time.Time
+		Limit    int
+	}
+
+	// Summary describes a verified log. Head is the hash of the last entry.
+	Summary struct {
+		Entries int
+		Head    string
+	}
+
+	Logger interface {
+		Record(entry Entry) error
+		Query(filter Filter) ([]Entry, error)
+	}
+
+	// FileLog appends entries to a file as JSON lines.
+	FileLog struct {
+		path    string
+		keyPath string
+		key     []byte
+		now     func() time.Time
+		mu      sync.Mutex
+	}
+)
+
+// NewFileLog returns a log whose entries are chained with the key kept in the file at keyPath.
+// The key is generated when the first entry is recorded, so a new log leaves no files behind until then.
+func NewFileLog(path, keyPath string) *FileLog {
+	return &FileLog{
+		path:    path,
+		keyPath: keyPath,
+		now:     time.Now,
+	}
+}
+
+// loadKey reads the key of the log, generating it first if create is set and it doesn't exist.
+// The mutex must be held.
+func (l *FileLog) loadKey(create bool) error {
+	if l.key != nil {
+		return nil
+	}
+
+	key, err := readKey(l.keyPath)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key, err = createKey(l.keyPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	l.key = key
+
+	return nil
+}
+
+// createKey stores a random key in the file unless another process has created it first.
+// The key is written to a temporary file first and linked into place, so it is never read half written.
+func createKey(path string) ([]byte, error) {
+	key, err := rand.Bytes(keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if _, err = file.Write(key); err != nil {
+		return nil, err
+	}
+
+	if err = file.Chmod(0400); err != nil {
+		return nil, err
+	}
+
+	if err = file.Sync(); err != nil {
+		return nil, err
+	}
+
+	if err = os.Link(file.Name(), path); errors.Is(err, os.ErrExist) {
+		return readKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func readKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) != keySize {
+		return nil, fmt.Errorf("%w: %s holds %d bytes, want %d", errInvalidKey, path, len(key), keySize)
+	}
+
+	return key, nil
+}
+
+// Record chains the entry to the last entry of the log, appends it and flushes it to disk.
+// The entry time is set to the current time if it is zero. If the last line isn't a chained entry,
+// because the log is new, was written before it was chained or ends with a line cut short by a crash,
+// a marker starting the chain is appended first.
+// The previous hash is read from the file on every call under an exclusive lock of the file,
+// so several processes, such as the server and the commands, can append to the log.
+func (l *FileLog) Record(entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = l.now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.loadKey(true); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(l.path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// The file lock keeps other processes from chaining an entry to the same last line.
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	last, complete, err := lastLine(file)
+	if err != nil {
+		return err
+	}
+
+	var (
+		data []byte
+		prev = l.chainedHash(last)
+	)
+
+	// A line cut short by a crash is terminated, so it is reported by Verify instead of corrupting the entry.
+	if !complete {
+		data = append(data, '\n')
+	}
+
+	if prev == "" {
+		marker := Entry{Time: entry.Time, Action: chainAction, Actor: "audit"}
+
+		if marker.Prev, err = prefixHash(file, complete); err != nil {
+			return err
+		}
+
+		if data, err = l.appendEntry(data, &marker); err != nil {
+			return err
+		}
+
+		prev = marker.Hash
+	}
+
+	entry.Prev = prev
+
+	if data, err = l.appendEntry(data, &entry); err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// Query returns the entries matching the filter in the order they were recorded, without the chain markers.
+func (l *FileLog) Query(filter Filter) ([]Entry, error) {
+	var entries []Entry
+
+	err := l.scan(func(_ []byte, entry Entry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.Action != chainAction && filter.match(entry) {
+			entries = append(entries, entry)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[len(entries)-filter.Limit:]
+	}
+
+	return entries, nil
+}
+
+// Verify checks the hash chain of the whole log.
+// Entries counts every line, the marker and the lines written before the log was chained included.
+func (l *FileLog) Verify() (Summary, error) {
+	// Without a key no line is chained, so only an empty log verifies.
+	l.mu.Lock()
+	err := l.loadKey(false)
+	l.mu.Unlock()
+
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Summary{}, err
+	}
+
+	var (
+		summary Summary
+		started bool
+		prefix  = sha256.New()
+	)
+
+	err = l.scan(func(line []byte, entry Entry, err error) error {
+		summary.Entries++
+
+		number := summary.Entries
+
+		if !started {
+			if err != nil || entry.Action != chainAction || l.chainedHash(line) == "" {
+				prefix.Write(line)
+				prefix.Write([]byte{'\n'})
+				return nil
+			}
+
+			if entry.Prev != prefixSum(prefix, number > 1) {
+				return fmt.Errorf("%w: lines before line %d were modified", ErrTampered, number)
+			}
+
+			started = true
+			summary.Head = entry.Hash
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: line %d is not an entry: %v", ErrTampered, number, err)
+		}
+
+		if entry.Prev != summary.Head {
+			return fmt.Errorf("%w: line %d doesn't follow the previous line", ErrTampered, number)
+		}
+
+		if l.chainedHash(line) == "" {
+			return fmt.Errorf("%w: line %d was modified", ErrTampered, number)
+		}
+
+		summary.Head = entry.Hash
+
+		return nil
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+
+	if !started && summary.Entries > 0 {
+		return Summary{}, fmt.Errorf("%w: no line starts the chain", ErrTampered)
+	}
+
+	return summary, nil
+}
+
+// appendEntry sets the hash of the entry and appends its line to data.
+func (l *FileLog) appendEntry(data []byte, entry *Entry) ([]byte, error) {
+	var err error
+
+	if entry.Hash, err = l.hashEntry(*entry); err != nil {
+		return nil, err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append(data, line...), '\n'), nil
+}
+
+// hashEntry returns the HMAC of the entry without its own hash.
+func (l *FileLog) hashEntry(entry Entry) (string, error) {
+	entry.Hash = ""
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, l.key)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// chainedHash returns the hash of the entry on the line if it was chained with the key of the log, otherwise "".
+func (l *FileLog) chainedHash(line []byte) string {
+	if l.key == nil {
+		return ""
+	}
+
+	var entry Entry
+
+	if err := json.Unmarshal(line, &entry); err != nil || entry.Hash == "" {
+		return ""
+	}
+
+	hash, err := l.hashEntry(entry)
+	if err != nil || !hmac.Equal([]byte(hash), []byte(entry.Hash)) {
+		return ""
+	}
+
+	return hash
+}
+
+// scan calls fn for every line of the log along with the entry it holds or the error parsing it.
+// A missing log is empty.
+func (l *FileLog) scan(fn func(line []byte, entry Entry, err error) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	// Entries being appended by other processes are read once they are complete.
+	unlock, err := lockFile(file, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, maxLineSize)
+
+	for scanner.Scan() {
+		var entry Entry
+
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+
+		if err = fn(scanner.Bytes(), entry, err); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (f Filter) match(entry Entry) bool {
+	switch {
+	case f.Username != "" && entry.Username != f.Username:
+		return false
+	case f.Action != "" && entry.Action != f.Action:
+		return false
+	case !f.Since.IsZero() && entry.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !entry.Time.Before(f.Until):
+		return false
+	default:
+		return true
+	}
+}
+
+// prefixHash returns the SHA-256 of the lines of the file a marker starting the chain is appended to,
+// with the last line terminated if it was cut short. The hash of an empty file is empty.
+func prefixHash(file *os.File, complete bool) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	prefix := sha256.New()
+
+	if _, err = io.Copy(prefix, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return "", err
+	}
+
+	if !complete {
+		prefix.Write([]byte{'\n'})
+	}
+
+	return prefixSum(prefix, info.Size() > 0), nil
+}
+
+func prefixSum(prefix hash.Hash, written bool) string {
+	if !written {
+		return ""
+	}
+
+	return hex.EncodeToString(prefix.Sum(nil))
+}
+
+// lastLine reads the last line of the file without its line break, reading the file backwards in blocks.
+// It reports whether the file is empty or ends with a line break.
+func lastLine(file *os.File) (line []byte, complete bool, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	const blockSize = 4096
+
+	var data []byte
+
+	for offset := info.Size(); offset > 0; {
+		size := int64(blockSize)
+		if offset < size {
+			size = offset
+		}
+
+		offset -= size
+
+		block := make([]byte, size)
+
+		if _, err = file.ReadAt(block, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, false, err
+		}
+
+		data = append(block, data...)
+
+		if i := bytes.LastIndexByte(bytes.TrimRight(data, "\n"), '\n'); i >= 0 {
+			data = data[i+1:]
+			break
+		}
+	}
+
+	complete = len(data) == 0 || data[len(data)-1] == '\n'
+
+	return bytes.TrimRight(data, "\n"), complete, nil
+}
@@ -0,0 +1,387 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileLog_Record(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	log := NewFileLog(path, path+".key")
+
+	for _, action := range []string{"first", "second"} {
+		if err := log.Record(Entry{Action: action, Username: "user", Actor: "user"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		var entry Entry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// The chain of a new log starts with a marker.
+	if got, want := len(entries), 3; got != want {
+		t.Fatalf("got %d entries, want %d", got, want)
+	}
+
+	if got, want := entries[0].Action, chainAction; got != want {
+		t.Fatalf("got action %q, want %q", got, want)
+	}
+
+	if got, want := entries[2].Action, "second"; got != want {
+		t.Fatalf("got action %q, want %q", got, want)
+	}
+
+	if entries[0].Time.IsZero() {
+		t.Fatal("got zero entry time")
+	}
+}
+
+func TestFileLog_Verify(t *testing.T) {
+	t.Parallel()
+
+	const legacy = `{"time":"2023-01-01T00:00:00Z","action":"delete_user","username":"user","actor":"user"}`
+
+	tests := []struct {
+		name    string
+		legacy  bool
+		tamper  func(t *testing.T, lines []string) []string
+		wantErr error
+	}{
+		{
+			name:    "intact",
+			tamper:  func(_ *testing.T, lines []string) []string { return lines },
+			wantErr: nil,
+		},
+		{
+			name: "edited entry",
+			tamper: func(_ *testing.T, lines []string) []string {
+				lines[2] = strings.Replace(lines[2], `"username":"user"`, `"username":"other"`, 1)
+				return lines
+			},
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "removed entry",
+			tamper:  func(_ *testing.T, lines []string) []string { return append(lines[:2], lines[3:]...) },
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "removed marker",
+			tamper:  func(_ *testing.T, lines []string) []string { return lines[1:] },
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "swapped entries",
+			tamper:  func(_ *testing.T, lines []string) []string { return []string{lines[0], lines[2], lines[1], lines[3]} },
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "unchained entry",
+			tamper:  func(_ *testing.T, lines []string) []string { return append(lines, legacy) },
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "stripped hashes",
+			tamper:  func(_ *testing.T, lines []string) []string { return stripHashes(lines) },
+			wantErr: ErrTampered,
+		},
+		{
+			name: "chained with another key",
+			tamper: func(t *testing.T, lines []string) []string {
+				path := filepath.Join(t.TempDir(), "audit.log")
+
+				forged := NewFileLog(path, path+".key")
+
+				for _, entry := range parseLines(t, stripHashes(lines)[1:]) {
+					if err := forged.Record(entry); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			},
+			wantErr: ErrTampered,
+		},
+		{
+			name:    "legacy entries",
+			legacy:  true,
+			tamper:  func(_ *testing.T, lines []string) []string { return lines },
+			wantErr: nil,
+		},
+		{
+			name:   "edited legacy entry",
+			legacy: true,
+			tamper: func(_ *testing.T, lines []string) []string {
+				lines[0] = strings.Replace(lines[0], `"username":"user"`, `"username":"other"`, 1)
+				return lines
+			},
+			wantErr: ErrTampered,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "audit.log")
+
+			if tc.legacy {
+				if err := os.WriteFile(path, []byte(legacy+"\n"+legacy+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			log := NewFileLog(path, path+".key")
+
+			for _, action := range []string{"first", "second", "third"} {
+				if err := log.Record(Entry{Action: action, Username: "user", Actor: "user"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+			lines = tc.tamper(t, lines)
+
+			if err = os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			summary, err := log.Verify()
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tc.wantErr)
+			}
+
+			if tc.wantErr != nil {
+				return
+			}
+
+			var last Entry
+			if err = json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+				t.Fatal(err)
+			}
+
+			if summary.Entries != len(lines) || summary.Head != last.Hash {
+				t.Fatalf("got summary %+v, want %d entries ending with %s", summary, len(lines), last.Hash)
+			}
+		})
+	}
+}
+
+func TestFileLog_Query(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	log := NewFileLog(path, path+".key")
+
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, entry := range []Entry{
+		{Action: "upload", Username: "alice"},
+		{Action: "download", Username: "alice"},
+		{Action: "upload", Username: "bob"},
+		{Action: "upload", Username: "alice"},
+	} {
+		entry.Time = start.Add(time.Duration(i) * time.Hour)
+		if err := log.Record(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{name: "all", filter: Filter{}, want: []int{0, 1, 2, 3}},
+		{name: "username", filter: Filter{Username: "alice"}, want: []int{0, 1, 3}},
+		{name: "action", filter: Filter{Username: "alice", Action: "upload"}, want: []int{0, 3}},
+		{name: "time range", filter: Filter{Since: start.Add(time.Hour), Until: start.Add(3 * time.Hour)}, want: []int{1, 2}},
+		{name: "limit", filter: Filter{Limit: 2}, want: []int{2, 3}},
+	}
+
+	for _, tc := range tests {
+		entries, err := log.Query(tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []int
+		for _, entry := range entries {
+			got = append(got, int(entry.Time.Sub(start)/time.Hour))
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Fatalf("%s: got entries %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestFileLog_Key(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	log := NewFileLog(path, path+".key")
+
+	if _, err := os.Stat(path + ".key"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v before the first entry, want no key", err)
+	}
+
+	if err := log.Record(Entry{Action: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := os.ReadFile(path + ".key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(key) != keySize {
+		t.Fatalf("got a %d-byte key, want %d bytes", len(key), keySize)
+	}
+
+	// Another process finds the key the log was chained with.
+	if _, err = NewFileLog(path, path+".key").Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.Remove(path + ".key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewFileLog(path, path+".key").Verify(); !errors.Is(err, ErrTampered) {
+		t.Fatalf("got %v without the key, want %v", err, ErrTampered)
+	}
+
+	if err = os.WriteFile(path+".key", []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = NewFileLog(path, path+".key").Record(Entry{Action: "second"}); !errors.Is(err, errInvalidKey) {
+		t.Fatalf("got %v, want %v", err, errInvalidKey)
+	}
+}
+
+// stripHashes removes the hashes from the entries on the lines, as if they were written before the log was chained.
+func stripHashes(lines []string) []string {
+	stripped := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		var entry Entry
+
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			panic(err)
+		}
+
+		entry.Prev, entry.Hash = "", ""
+
+		data, err := json.Marshal(entry)
+		if err != nil {
+			panic(err)
+		}
+
+		stripped = append(stripped, string(data))
+	}
+
+	return stripped
+}
+
+func parseLines(t *testing.T, lines []string) []Entry {
+	t.Helper()
+
+	entries := make([]Entry, 0, len(lines))
+
+	for _, line := range lines {
+		var entry Entry
+
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestFileLog_Record_Processes(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	// Every log stands for a process, they only share the file.
+	var (
+		logs = []*FileLog{NewFileLog(path, path+".key"), NewFileLog(path, path+".key"), NewFileLog(path, path+".key")}
+		wg   sync.WaitGroup
+		errs = make(chan error, len(logs)*20)
+	)
+
+	for _, log := range logs {
+		wg.Add(1)
+
+		go func(log *FileLog) {
+			defer wg.Done()
+
+			for i := 0; i < 20; i++ {
+				errs <- log.Record(Entry{Action: "upload", Username: "user"})
+			}
+		}(log)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := NewFileLog(path, path+".key").Verify()
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	if got, want := summary.Entries, len(logs)*20+1; got != want {
+		t.Fatalf("got %d entries, want %d", got, want)
+	}
+}
@@ -0,0 +1,318 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type (
+	// LocalStore keeps blobs as files under the root directory, the key of a blob is its slash-separated path.
+	// Symbolic links are never followed, and directories left empty by deletions are removed.
+	LocalStore struct {
+		root string
+	}
+
+	localBlob struct {
+		*os.File
+		size int64
+	}
+)
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+// Put writes the blob to a temporary file and renames it into place,
+// so readers observe either the previous content or the new one, even if the process crashes.
+func (s *LocalStore) Put(key string, src io.Reader) (err error) {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
+
+	if _, err = io.Copy(file, src); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func (s *LocalStore) Get(key string) (Blob, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		_ = file.Close()
+		return nil, &fs.PathError{Op: "open", Path: key, Err: fs.ErrNotExist}
+	}
+
+	return localBlob{File: file, size: info.Size()}, nil
+}
+
+func (s *LocalStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if info, err := os.Lstat(path); err != nil || info.IsDir() {
+		return nil
+	}
+
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	s.removeEmptyParents(path)
+
+	return nil
+}
+
+func (s *LocalStore) List(prefix string) ([]Info, error) {
+	if err := validatePrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var dir string
+
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = prefix[:i]
+	}
+
+	walkRoot := s.root
+
+	if dir != "" {
+		var err error
+		if walkRoot, err = s.path(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	var infos []Info
+
+	err := filepath.WalkDir(walkRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			if path != walkRoot && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !entry.Type().IsRegular() || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		infos = append(infos, Info{Key: key, Size: info.Size(), Modified: info.ModTime().UTC()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Walking visits "a" before "a.txt" and its entries, keys are compared as a whole.
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos, nil
+}
+
+func (s *LocalStore) Stat(key string) (Info, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return Info{}, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return Info{}, &fs.PathError{Op: "stat", Path: key, Err: fs.ErrNotExist}
+	}
+
+	return Info{Key: key, Size: info.Size(), Modified: info.ModTime().UTC()}, nil
+}
+
+// Move renames the file of the blob.
+func (s *LocalStore) Move(src, dst string) error {
+	srcPath, err := s.path(src)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := s.path(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.Stat(src); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(dstPath), 0700); err != nil {
+		return err
+	}
+
+	if err = os.Rename(srcPath, dstPath); err != nil {
+		return err
+	}
+
+	s.removeEmptyParents(srcPath)
+
+	return syncDir(filepath.Dir(dstPath))
+}
+
+// Patch overwrites the data in place. The write isn't atomic, a crash may leave only a part of the data written.
+func (s *LocalStore) Patch(key string, offset int64, data []byte) error {
+	info, err := s.Stat(key)
+	if err != nil {
+		return err
+	}
+
+	if offset < 0 || offset+int64(len(data)) > info.Size {
+		return fmt.Errorf("blob: patch %d+%d is out of %q of size %d", offset, len(data), key, info.Size)
+	}
+
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.WriteAt(data, offset); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// path maps the key onto the filesystem, failing with ErrInvalidKey if it traverses a symbolic link.
+func (s *LocalStore) path(key string) (string, error) {
+	if err := validateKey(key); err != nil {
+		return "", err
+	}
+
+	current := s.root
+
+	for _, element := range strings.Split(key, "/") {
+		current = filepath.Join(current, element)
+
+		info, err := os.Lstat(current)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Nothing below a missing element can be a symbolic link.
+				return filepath.Join(s.root, filepath.FromSlash(key)), nil
+			}
+			return "", err
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: %q traverses a symbolic link", ErrInvalidKey, key)
+		}
+	}
+
+	return current, nil
+}
+
+// removeEmptyParents removes the directories above the path up to the root, stopping at the first non-empty one.
+func (s *LocalStore) removeEmptyParents(path string) {
+	for dir := filepath.Dir(path); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			return
+		}
+	}
+}
+
+func (b localBlob) Size() int64 {
+	return b.size
+}
+
+// syncDir flushes the directory entries, making renames and removals in it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
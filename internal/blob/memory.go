@@ -0,0 +1,176 @@
+package blob
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// MemoryStore keeps blobs in memory, so they are lost on restart.
+	MemoryStore struct {
+		blobs map[string]memoryBlob
+		now   func() time.Time
+		mu    sync.RWMutex
+	}
+
+	memoryBlob struct {
+		data     []byte
+		modified time.Time
+	}
+
+	memoryReader struct {
+		*bytes.Reader
+	}
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blobs: make(map[string]memoryBlob),
+		now:   time.Now,
+	}
+}
+
+func (s *MemoryStore) Put(key string, src io.Reader) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blobs[key] = memoryBlob{data: data, modified: s.now().UTC()}
+
+	return nil
+}
+
+// Get returns a reader of the content at the time of the call, later changes of the blob don't affect it.
+func (s *MemoryStore) Get(key string) (Blob, error) {
+	blob, err := s.get(key, "open")
+	if err != nil {
+		return nil, err
+	}
+
+	return memoryReader{Reader: bytes.NewReader(blob.data)}, nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blobs, key)
+
+	return nil
+}
+
+func (s *MemoryStore) List(prefix string) ([]Info, error) {
+	if err := validatePrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var infos []Info
+
+	for key, blob := range s.blobs {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, Info{Key: key, Size: int64(len(blob.data)), Modified: blob.modified})
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos, nil
+}
+
+func (s *MemoryStore) Stat(key string) (Info, error) {
+	blob, err := s.get(key, "stat")
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Key: key, Size: int64(len(blob.data)), Modified: blob.modified}, nil
+}
+
+func (s *MemoryStore) Move(src, dst string) error {
+	if err := validateKey(dst); err != nil {
+		return err
+	}
+
+	blob, err := s.get(src, "move")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.blobs, src)
+	s.blobs[dst] = blob
+
+	return nil
+}
+
+// Patch replaces the content with a patched copy, so readers of the previous content are not affected.
+func (s *MemoryStore) Patch(key string, offset int64, data []byte) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blob, ok := s.blobs[key]
+	if !ok {
+		return &fs.PathError{Op: "patch", Path: key, Err: fs.ErrNotExist}
+	}
+
+	if offset < 0 || offset+int64(len(data)) > int64(len(blob.data)) {
+		return fmt.Errorf("blob: patch %d+%d is out of %q of size %d", offset, len(data), key, len(blob.data))
+	}
+
+	patched := make([]byte, len(blob.data))
+	copy(patched, blob.data)
+	copy(patched[offset:], data)
+
+	s.blobs[key] = memoryBlob{data: patched, modified: s.now().UTC()}
+
+	return nil
+}
+
+func (s *MemoryStore) get(key, op string) (memoryBlob, error) {
+	if err := validateKey(key); err != nil {
+		return memoryBlob{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blob, ok := s.blobs[key]
+	if !ok {
+		return memoryBlob{}, &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+	}
+
+	return blob, nil
+}
+
+func (memoryReader) Close() error {
+	return nil
+}
@@ -0,0 +1,542 @@
+package blob
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is signed instead of the payload hash, so uploads can be streamed from disk.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+type (
+	// S3Store keeps blobs as objects of a bucket in an S3-compatible service.
+	// Requests are signed with AWS Signature Version 4 and use path-style addressing.
+	S3Store struct {
+		endpoint        string
+		region          string
+		bucket          string
+		accessKeyID     string
+		secretAccessKey string
+		client          *http.Client
+		timeout         time.Duration
+		now             func() time.Time
+	}
+
+	s3Blob struct {
+		store  *S3Store
+		key    string
+		size   int64
+		body   io.ReadCloser
+		cancel context.CancelFunc
+	}
+
+	listBucketResult struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			Size         int64     `xml:"Size"`
+			LastModified time.Time `xml:"LastModified"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+
+	s3Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+)
+
+// NewS3Store returns a store that fails the requests the service doesn't respond to within the timeout.
+// Requests that only transfer metadata must complete within the timeout,
+// uploads and sequential downloads are bounded only while waiting for the response.
+func NewS3Store(endpoint, region, bucket, accessKeyID, secretAccessKey string, timeout time.Duration) *S3Store {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = timeout
+
+	return &S3Store{
+		endpoint:        strings.TrimRight(endpoint, "/"),
+		region:          region,
+		bucket:          bucket,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		client:          &http.Client{Transport: transport},
+		timeout:         timeout,
+		now:             time.Now,
+	}
+}
+
+// Put buffers src in a temporary file first, as objects are uploaded with a known length.
+func (s *S3Store) Put(key string, src io.Reader) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "beaver-s3-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	request, err := s.newRequest(context.Background(), http.MethodPut, key, nil, io.NopCloser(tmp))
+	if err != nil {
+		return err
+	}
+
+	request.ContentLength = size
+
+	response, err := s.do(request, key)
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+// Get returns a blob that downloads the object when it is read sequentially
+// and downloads only the requested ranges when it is read at random positions.
+func (s *S3Store) Get(key string) (Blob, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Blob{store: s, key: key, size: info.Size}, nil
+}
+
+func (s *S3Store) Delete(key string) error {
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	request, err := s.newRequest(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := s.do(request, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return response.Body.Close()
+}
+
+// List pages through the objects with ListObjectsV2.
+func (s *S3Store) List(prefix string) ([]Info, error) {
+	if err := validatePrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var (
+		infos []Info
+		token string
+	)
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		result, err := s.listPage(query, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			infos = append(infos, Info{Key: object.Key, Size: object.Size, Modified: object.LastModified.UTC()})
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+
+		token = result.NextContinuationToken
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+
+	return infos, nil
+}
+
+func (s *S3Store) Stat(key string) (Info, error) {
+	if err := validateKey(key); err != nil {
+		return Info{}, err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	request, err := s.newRequest(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return Info{}, err
+	}
+
+	response, err := s.do(request, key)
+	if err != nil {
+		return Info{}, err
+	}
+	_ = response.Body.Close()
+
+	modified, err := http.ParseTime(response.Header.Get("Last-Modified"))
+	if err != nil {
+		return Info{}, fmt.Errorf("s3: stat %q: %w", key, err)
+	}
+
+	return Info{Key: key, Size: response.ContentLength, Modified: modified.UTC()}, nil
+}
+
+func (s *S3Store) listPage(query url.Values, prefix string) (listBucketResult, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+
+	request, err := s.newRequest(ctx, http.MethodGet, "", query, nil)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+
+	response, err := s.do(request, prefix)
+	if err != nil {
+		return listBucketResult{}, err
+	}
+	defer response.Body.Close()
+
+	var result listBucketResult
+
+	if err = xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return listBucketResult{}, fmt.Errorf("s3: list %q: %w", prefix, err)
+	}
+
+	return result, nil
+}
+
+// Move copies the object on the server side and deletes the source. Objects up to 5 GiB can be moved.
+func (s *S3Store) Move(src, dst string) error {
+	if err := validateKey(src); err != nil {
+		return err
+	}
+
+	if err := validateKey(dst); err != nil {
+		return err
+	}
+
+	ctx, cancel := s.context()
+	defer cancel()
+
+	request, err := s.newRequest(ctx, http.MethodPut, dst, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("X-Amz-Copy-Source", "/"+s.bucket+"/"+escapePath(src))
+
+	response, err := s.do(request, src)
+	if err != nil {
+		return err
+	}
+
+	// A copy may fail after the response status has been sent, the error is reported in the body.
+	var copyError s3Error
+
+	err = xml.NewDecoder(response.Body).Decode(&copyError)
+	_ = response.Body.Close()
+	if err == nil && copyError.Code != "" {
+		return fmt.Errorf("s3: copy %q: %s: %s", src, copyError.Code, copyError.Message)
+	}
+
+	return s.Delete(src)
+}
+
+// context returns the context of a request whose response is read before the call returns, bounded by the timeout.
+func (s *S3Store) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+func (s *S3Store) newRequest(ctx context.Context, method, key string, query url.Values, body io.ReadCloser) (*http.Request, error) {
+	rawURL := s.endpoint + "/" + s.bucket
+	if key != "" {
+		rawURL += "/" + escapePath(key)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	request.URL.RawQuery = canonicalQuery(query)
+	request.Body = body
+
+	return request, nil
+}
+
+// do signs and sends the request. Responses other than 2xx are returned as errors,
+// and 404 is reported as fs.ErrNotExist.
+func (s *S3Store) do(request *http.Request, key string) (*http.Response, error) {
+	s.sign(request, s.now().UTC())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+
+	op := strings.ToLower(request.Method)
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: op, Path: key, Err: fs.ErrNotExist}
+	}
+
+	var s3err s3Error
+
+	if err = xml.NewDecoder(io.LimitReader(response.Body, 64<<10)).Decode(&s3err); err != nil || s3err.Code == "" {
+		return nil, fmt.Errorf("s3: %s %q: %s", op, key, response.Status)
+	}
+
+	return nil, fmt.Errorf("s3: %s %q: %s: %s", op, key, s3err.Code, s3err.Message)
+}
+
+// sign adds the AWS Signature Version 4 authorization to the request.
+// The host, the range and the x-amz-* headers are signed.
+func (s *S3Store) sign(request *http.Request, now time.Time) {
+	var (
+		amzDate = now.Format("20060102T150405Z")
+		date    = now.Format("20060102")
+		scope   = date + "/" + s.region + "/s3/aws4_request"
+	)
+
+	if request.Header.Get("X-Amz-Content-Sha256") == "" {
+		request.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": request.URL.Host}
+
+	for name, values := range request.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		request.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKeyID, scope, signedHeaders, signature))
+}
+
+func (b *s3Blob) Read(p []byte) (int, error) {
+	if b.body == nil {
+		// The download lasts as long as the blob is read, so it is only canceled when the blob is closed.
+		ctx, cancel := context.WithCancel(context.Background())
+
+		request, err := b.store.newRequest(ctx, http.MethodGet, b.key, nil, nil)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+
+		response, err := b.store.do(request, b.key)
+		if err != nil {
+			cancel()
+			return 0, err
+		}
+
+		b.body = response.Body
+		b.cancel = cancel
+	}
+
+	return b.body.Read(p)
+}
+
+// ReadAt downloads the range with a ranged GET request.
+func (b *s3Blob) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("blob: negative offset")
+	}
+
+	if off >= b.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > b.size {
+		end = b.size
+	}
+
+	if end == off {
+		return 0, nil
+	}
+
+	ctx, cancel := b.store.context()
+	defer cancel()
+
+	request, err := b.store.newRequest(ctx, http.MethodGet, b.key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	request.Header.Set("Range", "bytes="+strconv.FormatInt(off, 10)+"-"+strconv.FormatInt(end-1, 10))
+
+	response, err := b.store.do(request, b.key)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	n, err := io.ReadFull(response.Body, p[:end-off])
+	if err != nil {
+		return n, err
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (b *s3Blob) Size() int64 {
+	return b.size
+}
+
+func (b *s3Blob) Close() error {
+	if b.body == nil {
+		return nil
+	}
+
+	defer b.cancel()
+
+	return b.body.Close()
+}
+
+// escapePath escapes every element of the slash-separated path as SigV4 canonical URIs require.
+func escapePath(path string) string {
+	elements := strings.Split(path, "/")
+
+	for i, element := range elements {
+		elements[i] = uriEncode(element)
+	}
+
+	return strings.Join(elements, "/")
+}
+
+// canonicalQuery encodes the query sorted by name, as SigV4 canonical query strings require.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters of RFC 3986.
+func uriEncode(s string) string {
+	var builder strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			builder.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&builder, "%%%02X", c)
+	}
+
+	return builder.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,127 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrInvalidKey is returned for keys that are empty, absolute, have empty, "." or ".." elements
+// or can't be addressed by the store.
+var ErrInvalidKey = errors.New("invalid key")
+
+type (
+	// Info describes a stored blob.
+	Info struct {
+		Key      string
+		Size     int64
+		Modified time.Time
+	}
+
+	// Blob is the content of a stored blob. It can be read both sequentially and at random positions
+	// and must be closed after use.
+	Blob interface {
+		io.ReadCloser
+		io.ReaderAt
+		Size() int64
+	}
+
+	// Store holds blobs under slash-separated keys.
+	// Missing blobs are reported with errors wrapping fs.ErrNotExist, deleting a missing blob is not an error.
+	Store interface {
+		// Put stores the content of src under the key, replacing an existing blob atomically.
+		// If reading src fails, nothing is stored.
+		Put(key string, src io.Reader) error
+		Get(key string) (Blob, error)
+		Delete(key string) error
+		// List describes the blobs whose keys start with the prefix in ascending order of their keys.
+		List(prefix string) ([]Info, error)
+		Stat(key string) (Info, error)
+	}
+
+	// Mover is implemented by stores that can move a blob without copying its content.
+	Mover interface {
+		Move(src, dst string) error
+	}
+
+	// Patcher is implemented by stores that can overwrite a part of a blob in place.
+	Patcher interface {
+		Patch(key string, offset int64, data []byte) error
+	}
+)
+
+// Move moves the blob to dst, replacing an existing blob.
+// Stores that don't implement Mover have the blob copied and then deleted.
+func Move(store Store, src, dst string) error {
+	if mover, ok := store.(Mover); ok {
+		return mover.Move(src, dst)
+	}
+
+	blob, err := store.Get(src)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if err = store.Put(dst, blob); err != nil {
+		return err
+	}
+
+	return store.Delete(src)
+}
+
+// Patch overwrites the part of the blob starting at the offset with data, the blob doesn't grow.
+// Stores that don't implement Patcher have the whole blob rewritten.
+func Patch(store Store, key string, offset int64, data []byte) error {
+	if patcher, ok := store.(Patcher); ok {
+		return patcher.Patch(key, offset, data)
+	}
+
+	blob, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	end := offset + int64(len(data))
+
+	if offset < 0 || end > blob.Size() {
+		return fmt.Errorf("blob: patch %d+%d is out of %q of size %d", offset, len(data), key, blob.Size())
+	}
+
+	return store.Put(key, io.MultiReader(
+		io.NewSectionReader(blob, 0, offset),
+		bytes.NewReader(data),
+		io.NewSectionReader(blob, end, blob.Size()-end),
+	))
+}
+
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	for _, element := range strings.Split(key, "/") {
+		if element == "" || element == "." || element == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+
+	return nil
+}
+
+// validatePrefix accepts the prefixes of valid keys.
+func validatePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		return validateKey(prefix[:i])
+	}
+
+	return nil
+}
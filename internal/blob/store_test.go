@@ -0,0 +1,262 @@
+package blob
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStore(t *testing.T) {
+	t.Parallel()
+
+	testStore(t, NewLocalStore(t.TempDir()))
+}
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	testStore(t, NewMemoryStore())
+}
+
+func TestLocalStore_SymbolicLinks(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	other := t.TempDir()
+
+	if err := os.Symlink(other, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewLocalStore(root)
+
+	if err := store.Put("link/a", strings.NewReader("data")); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidKey)
+	}
+
+	if _, err := store.List("link/"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidKey)
+	}
+
+	infos, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 0 {
+		t.Fatalf("got %d blobs through a symbolic link, want 0", len(infos))
+	}
+}
+
+func TestLocalStore_RemovesEmptyDirectories(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	store := NewLocalStore(root)
+
+	for _, key := range []string{"a/b/c", "a/d"} {
+		if err := store.Put(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Move("a/b/c", "e"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a", "b")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v for an emptied directory, want %v", err, fs.ErrNotExist)
+	}
+
+	if err := store.Delete("a/d"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "a")); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v for an emptied directory, want %v", err, fs.ErrNotExist)
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("got %v for the root, want it to be kept", err)
+	}
+}
+
+// testStore checks the behavior every Store implementation must have.
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	if _, err := store.Get("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Get() of a missing blob error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if _, err := store.Stat("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat() of a missing blob error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if err := store.Delete("missing"); err != nil {
+		t.Fatalf("Delete() of a missing blob error = %v", err)
+	}
+
+	for _, key := range []string{"", "/a", "a//b", "a/../b", "./a", "a/", "a\\b"} {
+		if err := store.Put(key, strings.NewReader("data")); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("Put(%q) error = %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+
+	for key, content := range map[string]string{"a/b": "ab", "a/c/d": "acd", "a.txt": "a.txt", "b": ""} {
+		if err := store.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatalf("Put(%q) error = %v", key, err)
+		}
+	}
+
+	if got, want := readBlob(t, store, "a/c/d"), "acd"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// A failing source leaves the previous content in place.
+	failing := io.MultiReader(strings.NewReader("partial"), &errorReader{err: io.ErrUnexpectedEOF})
+
+	if err := store.Put("a/b", failing); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Put() with a failing source error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	if got, want := readBlob(t, store, "a/b"), "ab"; got != want {
+		t.Fatalf("got %q after a failed Put(), want %q", got, want)
+	}
+
+	info, err := store.Stat("a/c/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Key != "a/c/d" || info.Size != 3 || info.Modified.IsZero() {
+		t.Fatalf("got %+v, want a/c/d of size 3", info)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{prefix: "", want: []string{"a.txt", "a/b", "a/c/d", "b"}},
+		{prefix: "a", want: []string{"a.txt", "a/b", "a/c/d"}},
+		{prefix: "a/", want: []string{"a/b", "a/c/d"}},
+		{prefix: "a/c", want: []string{"a/c/d"}},
+		{prefix: "a/c/d", want: []string{"a/c/d"}},
+		{prefix: "c/", want: nil},
+	}
+
+	for _, tc := range tests {
+		infos, err := store.List(tc.prefix)
+		if err != nil {
+			t.Fatalf("List(%q) error = %v", tc.prefix, err)
+		}
+
+		var got []string
+		for _, info := range infos {
+			got = append(got, info.Key)
+		}
+
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("List(%q) = %q, want %q", tc.prefix, got, tc.want)
+		}
+	}
+
+	blob, err := store.Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := make([]byte, 3)
+
+	if n, err := blob.ReadAt(p, 2); err != nil || string(p[:n]) != "txt" {
+		t.Fatalf("ReadAt() = %q, %v, want %q", p[:n], err, "txt")
+	}
+
+	if n, err := blob.ReadAt(p, 3); !errors.Is(err, io.EOF) || string(p[:n]) != "xt" {
+		t.Fatalf("ReadAt() at the end = %q, %v, want %q, %v", p[:n], err, "xt", io.EOF)
+	}
+
+	if got, want := blob.Size(), int64(5); got != want {
+		t.Fatalf("got size %d, want %d", got, want)
+	}
+
+	if err = blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Move(store, "a/c/d", "e/f"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = store.Stat("a/c/d"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Stat() of a moved blob error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	if got, want := readBlob(t, store, "e/f"), "acd"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if err = Move(store, "e/f", "a/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readBlob(t, store, "a/b"), "acd"; got != want {
+		t.Fatalf("got %q after moving over a blob, want %q", got, want)
+	}
+
+	if err = Patch(store, "a/b", 1, []byte("X")); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readBlob(t, store, "a/b"), "aXd"; got != want {
+		t.Fatalf("got %q after a patch, want %q", got, want)
+	}
+
+	if err = Patch(store, "a/b", 2, []byte("XX")); err == nil {
+		t.Fatal("Patch() past the end succeeded, want error")
+	}
+
+	for _, key := range []string{"a/b", "a.txt", "b"} {
+		if err = store.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := store.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 0 {
+		t.Fatalf("got %d blobs after deleting all of them, want 0", len(infos))
+	}
+}
+
+func readBlob(t *testing.T, store Store, key string) string {
+	t.Helper()
+
+	blob, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
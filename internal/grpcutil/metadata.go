@@ -3,6 +3,7 @@ package grpcutil
 import (
 	"context"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -19,3 +20,9 @@ func HeaderFromContext(ctx context.Context, key string) string {
 
 	return value[0]
 }
+
+// SetTrailer sets the trailer sent to the client when the stream ends.
+// Keys ending with "-bin" carry binary values.
+func SetTrailer(stream grpc.ServerStream, key string, value []byte) {
+	stream.SetTrailer(metadata.Pairs(key, string(value)))
+}
@@ -2,87 +2,81 @@ package grpcutil
 
 import (
 	"context"
-	"errors"
 	"io"
 
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/proto"
 )
 
-func StreamToReader[T proto.Message](ctx context.Context, stream grpc.ServerStream) io.Reader {
-	r, w := io.Pipe()
+// message is a pointer to a protobuf message struct.
+type message[M any] interface {
+	*M
+	proto.Message
+}
 
-	var processMessage = func() error {
-		if err := ctx.Err(); err != nil {
-			return err
-		}
+// StreamToReader returns a reader that yields the chunks extracted
+// from the messages received on the stream. Messages without a chunk are skipped.
+// The reader returns io.EOF when the client closes its side of the stream.
+func StreamToReader[M any, PM message[M]](ctx context.Context, stream grpc.ServerStream, chunk func(PM) []byte) io.Reader {
+	return &streamReader[M, PM]{
+		ctx:    ctx,
+		stream: stream,
+		chunk:  chunk,
+	}
+}
 
-		var message T
+type streamReader[M any, PM message[M]] struct {
+	ctx    context.Context
+	stream grpc.ServerStream
+	chunk  func(PM) []byte
+	buffer []byte
+}
 
-		if err := stream.RecvMsg(&message); err != nil {
-			return err
+func (r *streamReader[M, PM]) Read(p []byte) (int, error) {
+	for len(r.buffer) == 0 {
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
 		}
 
-		data, err := proto.Marshal(message)
-		if err != nil {
-			return err
-		}
+		message := PM(new(M))
 
-		_, err = w.Write(data)
+		if err := r.stream.RecvMsg(message); err != nil {
+			return 0, err
+		}
 
-		return err
+		r.buffer = r.chunk(message)
 	}
 
-	go func() {
-		for {
-			if err := processMessage(); err != nil {
-				if !errors.Is(err, io.EOF) {
-					w.CloseWithError(err)
-				}
-				w.Close()
-				return
-			}
-		}
-	}()
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
 
-	return r
+	return n, nil
 }
 
-func StreamToWriter[T proto.Message](ctx context.Context, stream grpc.ServerStream) io.Writer {
-	r, w := io.Pipe()
-
-	var processMessage = func() error {
-		if err := ctx.Err(); err != nil {
-			return err
-		}
-
-		buffer := make([]byte, 1024)
-
-		n, err := r.Read(buffer)
-		if err != nil {
-			return err
-		}
-
-		var message T
+// StreamToWriter returns a writer that sends every written slice
+// to the stream as a message built by the given function.
+func StreamToWriter[M any, PM message[M]](ctx context.Context, stream grpc.ServerStream, message func(chunk []byte) PM) io.Writer {
+	return &streamWriter[M, PM]{
+		ctx:     ctx,
+		stream:  stream,
+		message: message,
+	}
+}
 
-		if err = proto.Unmarshal(buffer[:n], message); err != nil {
-			return err
-		}
+type streamWriter[M any, PM message[M]] struct {
+	ctx     context.Context
+	stream  grpc.ServerStream
+	message func(chunk []byte) PM
+}
 
-		return stream.SendMsg(&message)
+func (w *streamWriter[M, PM]) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
 	}
 
-	go func() {
-		for {
-			if err := processMessage(); err != nil {
-				if !errors.Is(err, io.EOF) {
-					w.CloseWithError(err)
-				}
-				w.Close()
-				return
-			}
-		}
-	}()
+	if err := w.stream.SendMsg(w.message(p)); err != nil {
+		return 0, err
+	}
 
-	return w
+	return len(p), nil
 }
@@ -0,0 +1,93 @@
+package grpcutil
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type fakeStream struct {
+	grpc.ServerStream
+	received []proto.Message
+	sent     []proto.Message
+}
+
+func (s *fakeStream) RecvMsg(m any) error {
+	if len(s.received) == 0 {
+		return io.EOF
+	}
+
+	proto.Merge(m.(proto.Message), s.received[0])
+	s.received = s.received[1:]
+
+	return nil
+}
+
+func (s *fakeStream) SendMsg(m any) error {
+	s.sent = append(s.sent, proto.Clone(m.(proto.Message)))
+	return nil
+}
+
+func TestStreamToReader(t *testing.T) {
+	t.Parallel()
+
+	stream := &fakeStream{
+		received: []proto.Message{
+			wrapperspb.Bytes([]byte("hello")),
+			wrapperspb.Bytes(nil),
+			wrapperspb.Bytes([]byte(" world")),
+		},
+	}
+
+	reader := StreamToReader(context.Background(), stream, (*wrapperspb.BytesValue).GetValue)
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := string(data), "hello world"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestStreamToReader_ContextCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stream := &fakeStream{received: []proto.Message{wrapperspb.Bytes([]byte("hello"))}}
+
+	reader := StreamToReader(ctx, stream, (*wrapperspb.BytesValue).GetValue)
+
+	if _, err := io.ReadAll(reader); err != context.Canceled {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestStreamToWriter(t *testing.T) {
+	t.Parallel()
+
+	stream := &fakeStream{}
+
+	writer := StreamToWriter(context.Background(), stream, wrapperspb.Bytes)
+
+	for _, chunk := range []string{"hello", " world"} {
+		if _, err := io.WriteString(writer, chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(stream.sent), 2; got != want {
+		t.Fatalf("got %d messages, want %d", got, want)
+	}
+
+	if got, want := string(stream.sent[1].(*wrapperspb.BytesValue).GetValue()), " world"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
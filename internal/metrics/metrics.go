@@ -0,0 +1,297 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// contentType is the content type of the Prometheus text exposition format.
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type (
+	// Registry holds metrics and writes them in the Prometheus text exposition format.
+	Registry struct {
+		mu      sync.Mutex
+		metrics []metric
+	}
+
+	metric interface {
+		write(w *bufio.Writer)
+	}
+
+	// CounterVec is a family of counters partitioned by the values of its labels.
+	CounterVec struct {
+		desc
+		mu     sync.Mutex
+		values map[string]float64
+	}
+
+	// HistogramVec is a family of histograms partitioned by the values of its labels.
+	HistogramVec struct {
+		desc
+		buckets []float64
+		mu      sync.Mutex
+		values  map[string]*histogram
+	}
+
+	// Gauge is a single value that can go up and down.
+	Gauge struct {
+		desc
+		mu    sync.Mutex
+		value float64
+	}
+
+	desc struct {
+		name   string
+		help   string
+		labels []string
+	}
+
+	histogram struct {
+		counts []uint64
+		sum    float64
+		count  uint64
+	}
+)
+
+// DefaultBuckets are the upper bounds of histogram buckets in seconds, suitable for request latencies.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	counter := &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		values: make(map[string]float64),
+	}
+
+	r.register(counter)
+
+	return counter
+}
+
+// NewHistogramVec returns a histogram family with the sorted upper bounds of its buckets, the +Inf bucket is implicit.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	histogram := &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+
+	r.register(histogram)
+
+	return histogram
+}
+
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	gauge := &Gauge{desc: desc{name: name, help: help}}
+
+	r.register(gauge)
+
+	return gauge
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.metrics = append(r.metrics, m)
+}
+
+// Write writes every metric in the order they were registered.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	metrics := r.metrics
+	r.mu.Unlock()
+
+	buffered := bufio.NewWriter(w)
+
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+
+	return buffered.Flush()
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_ = r.Write(w)
+}
+
+// Add adds the value to the counter with the label values, which are given in the order of the labels.
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key] += value
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeHeader(w, "counter")
+
+	for _, key := range sortedKeys(c.values) {
+		c.writeSample(w, c.name, splitLabelKey(key), "", "", c.values[key])
+	}
+}
+
+// Observe adds the value to the histogram with the label values, which are given in the order of the labels.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	values, ok := h.values[key]
+	if !ok {
+		values = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = values
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			values.counts[i]++
+		}
+	}
+
+	values.sum += value
+	values.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.writeHeader(w, "histogram")
+
+	for _, key := range sortedKeys(h.values) {
+		var (
+			labelValues = splitLabelKey(key)
+			values      = h.values[key]
+		)
+
+		for i, bound := range h.buckets {
+			h.writeSample(w, h.name+"_bucket", labelValues, "le", formatFloat(bound), float64(values.counts[i]))
+		}
+
+		h.writeSample(w, h.name+"_bucket", labelValues, "le", "+Inf", float64(values.count))
+		h.writeSample(w, h.name+"_sum", labelValues, "", "", values.sum)
+		h.writeSample(w, h.name+"_count", labelValues, "", "", float64(values.count))
+	}
+}
+
+func (g *Gauge) Set(value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.value = value
+}
+
+func (g *Gauge) Add(value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.value += value
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.writeHeader(w, "gauge")
+	g.writeSample(w, g.name, nil, "", "", g.value)
+}
+
+func (d desc) writeHeader(w *bufio.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, kind)
+}
+
+// writeSample writes a sample line. The extra label, if any, follows the labels of the metric.
+func (d desc) writeSample(w *bufio.Writer, name string, labelValues []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+
+	var pairs []string
+
+	for i, label := range d.labels {
+		if i < len(labelValues) {
+			pairs = append(pairs, label+`="`+escapeLabelValue(labelValues[i])+`"`)
+		}
+	}
+
+	if extraLabel != "" {
+		pairs = append(pairs, extraLabel+`="`+extraValue+`"`)
+	}
+
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+// labelKey joins the label values with a byte that can't appear in valid UTF-8.
+func labelKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func splitLabelKey(key string) []string {
+	return strings.Split(key, "\xff")
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
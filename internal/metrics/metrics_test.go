@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	t.Parallel()
+
+	registry := NewRegistry()
+
+	requests := registry.NewCounterVec("requests_total", "Requests handled.", "method", "code")
+	latency := registry.NewHistogramVec("request_duration_seconds", "Request latency.", []float64{0.1, 1}, "method")
+	streams := registry.NewGauge("active_streams", "Streams in flight.")
+
+	requests.Inc("/a", "OK")
+	requests.Inc("/a", "OK")
+	requests.Inc(`/b"\`, "NotFound")
+
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(2, "/a")
+
+	streams.Inc()
+	streams.Inc()
+	streams.Dec()
+
+	want := `# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{method="/a",code="OK"} 2
+requests_total{method="/b\"\\",code="NotFound"} 1
+# HELP request_duration_seconds Request latency.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{method="/a",le="0.1"} 1
+request_duration_seconds_bucket{method="/a",le="1"} 2
+request_duration_seconds_bucket{method="/a",le="+Inf"} 3
+request_duration_seconds_sum{method="/a"} 2.55
+request_duration_seconds_count{method="/a"} 3
+# HELP active_streams Streams in flight.
+# TYPE active_streams gauge
+active_streams 1
+`
+
+	recorder := httptest.NewRecorder()
+
+	registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if got := recorder.Body.String(); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	if got := recorder.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Fatalf("got content type %q", got)
+	}
+}
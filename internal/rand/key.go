@@ -18,3 +18,15 @@ func Key(length int) ([]byte, error) {
 
 	return key, nil
 }
+
+// Bytes generates a slice of uniformly distributed random bytes of the given length.
+// Unlike Key, the result is not restricted to printable characters.
+func Bytes(length int) ([]byte, error) {
+	b := make([]byte, length)
+
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
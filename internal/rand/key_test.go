@@ -32,3 +32,27 @@ func TestKey(t *testing.T) {
 		t.Fatalf("expected key to be different from key2, got %q, %q", key, key2)
 	}
 }
+
+func TestBytes(t *testing.T) {
+	t.Parallel()
+
+	const length = 32
+
+	b, err := Bytes(length)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) != length {
+		t.Fatalf("expected length to be %d, got %d", length, len(b))
+	}
+
+	b2, err := Bytes(length)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(b, b2) {
+		t.Fatalf("expected b to be different from b2, got %x, %x", b, b2)
+	}
+}
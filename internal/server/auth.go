@@ -1,68 +1,114 @@
 package server
 
 import (
-	"bytes"
-	"crypto/sha256"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 
-	"golang.org/x/crypto/pbkdf2"
-
-	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/blob"
 	"github.com/KirillMironov/beaver/internal/jwt"
 	"github.com/KirillMironov/beaver/internal/log"
-	"github.com/KirillMironov/beaver/internal/rand"
+	"github.com/KirillMironov/beaver/internal/session"
+	"github.com/KirillMironov/beaver/internal/trace"
 )
 
 const (
 	beaverFilename = "beaver.key"
 	authMessage    = "beaver"
+	journalSuffix  = ".rekey"
 )
 
+// The errors the Authenticator reports for requests it rejects.
 var (
-	errInvalidMasterKey  = errors.New("invalid master key")
-	errInvalidPassphrase = errors.New("invalid passphrase")
-	errUserAlreadyExists = errors.New("user already exists")
-	errUserNotFound      = errors.New("user not found")
-	errNotEnoughParams   = errors.New("not enough parameters")
+	ErrInvalidMasterKey  = errors.New("invalid master key")
+	ErrInvalidPassphrase = errors.New("invalid passphrase")
+	ErrUserAlreadyExists = errors.New("user already exists")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidUsername   = errors.New("invalid username")
+	ErrNotEnoughParams   = errors.New("not enough parameters")
+	errSessionMismatch   = errors.New("session belongs to another user")
 )
 
 type Authenticator struct {
 	dataDir      string
+	store        blob.Store
 	logger       log.Logger
 	tokenManager jwt.TokenManager[User]
+	sessions     session.Store
+	auditLog     audit.Logger
+	keyLocks     *KeyLocks
+	kdfParams    Argon2Params
+	locks        *userLocks
+	masterKeyMu  *sync.Mutex
 }
 
-func NewAuthenticator(dataDir string, logger log.Logger, tokenManager jwt.TokenManager[User]) (*Authenticator, error) {
+// NewAuthenticator returns an authenticator that keeps the user records and the master key in dataDir.
+// The files of the users are kept in the store, it re-keys and shreds them along with the user records.
+// The key locks must be shared with the storage writing to the store.
+func NewAuthenticator(dataDir string, store blob.Store, logger log.Logger, tokenManager jwt.TokenManager[User], sessions session.Store, auditLog audit.Logger, keyLocks *KeyLocks, kdfParams Argon2Params) (*Authenticator, error) {
+	if err := kdfParams.Validate(); err != nil {
+		return nil, err
+	}
+
 	authenticator := &Authenticator{
 		dataDir:      dataDir,
+		store:        store,
+		kdfParams:    kdfParams,
 		logger:       logger,
 		tokenManager: tokenManager,
+		sessions:     sessions,
+		auditLog:     auditLog,
+		keyLocks:     keyLocks,
+		locks:        newUserLocks(),
+		masterKeyMu:  &sync.Mutex{},
 	}
 
 	return authenticator, authenticator.generateMasterKeyIfNotExists()
 }
 
-func (a Authenticator) AddUser(username, passphrase, masterKey string) (string, error) {
+func (a Authenticator) AddUser(ctx context.Context, username, passphrase, masterKey string) (string, error) {
+	ctx, span := trace.Start(ctx, "auth.AddUser")
+	defer span.End()
+
 	if username == "" || passphrase == "" || masterKey == "" {
-		return "", errNotEnoughParams
+		return "", ErrNotEnoughParams
+	}
+
+	if !validUsername(username) {
+		return "", ErrInvalidUsername
+	}
+
+	// The master key is verified first, so only administrators learn which users exist.
+	if err := verifyMasterKey(a.dataDir, masterKey); err != nil {
+		return "", err
 	}
 
 	userDataDir := filepath.Join(a.dataDir, username)
 
 	if _, err := os.Stat(userDataDir); err == nil {
-		return "", errUserAlreadyExists
+		return "", ErrUserAlreadyExists
+	}
+
+	record, key, err := newUserRecord(ctx, passphrase, a.kdfParams)
+	if err != nil {
+		return "", err
 	}
 
-	if err := a.verifyMasterKey(masterKey); err != nil {
+	publicKey, privateKey, err := generateKeyPair()
+	if err != nil {
 		return "", err
 	}
 
-	key := deriveKey(passphrase, username)
+	if err = record.setKeyPair(publicKey, privateKey, key); err != nil {
+		return "", err
+	}
 
-	ciphertext, err := aes.Encrypt([]byte(authMessage), key)
+	data, err := json.Marshal(record)
 	if err != nil {
 		return "", err
 	}
@@ -71,81 +117,364 @@ func (a Authenticator) AddUser(username, passphrase, masterKey string) (string,
 		return "", err
 	}
 
-	if err = os.WriteFile(filepath.Join(userDataDir, "."+username), ciphertext, 0400); err != nil {
+	if err = os.WriteFile(filepath.Join(userDataDir, "."+username), data, 0400); err != nil {
 		_ = os.Remove(userDataDir)
 		return "", err
 	}
 
-	user := User{
-		Username: username,
-		DataDir:  userDataDir,
-		key:      key,
-	}
-
-	return a.tokenManager.GenerateToken(user)
+	return a.generateToken(username, userDataDir, key)
 }
 
-func (a Authenticator) Authenticate(username, passphrase string) (string, error) {
+func (a Authenticator) Authenticate(ctx context.Context, username, passphrase string) (string, error) {
+	ctx, span := trace.Start(ctx, "auth.Authenticate")
+	defer span.End()
+
+	a = a.traced(ctx)
+
 	if username == "" || passphrase == "" {
-		return "", errNotEnoughParams
+		return "", ErrNotEnoughParams
 	}
 
-	userDataDir := filepath.Join(a.dataDir, username)
+	if !validUsername(username) {
+		return "", ErrInvalidUsername
+	}
 
-	fileCiphertext, err := os.ReadFile(filepath.Join(userDataDir, "."+username))
+	defer a.locks.lock(username)()
+
+	userDataDir, record, key, err := a.verifyPassphrase(ctx, username, passphrase)
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return "", errUserNotFound
-		}
 		return "", err
 	}
 
-	key := deriveKey(passphrase, username)
+	// Keys derived with outdated parameters are upgraded while the passphrase is at hand.
+	if record.KDF.outdated(a.kdfParams) {
+		a.logger.Infof("upgrading key derivation of user %q", username)
 
-	plaintext, err := aes.Decrypt(fileCiphertext, key)
+		if key, err = a.changeKey(ctx, username, userDataDir, record, key, passphrase); err != nil {
+			return "", err
+		}
+	} else if record.PublicKey == nil {
+		a.logger.Infof("generating key pair of user %q", username)
+
+		if err = a.addKeyPair(filepath.Join(userDataDir, "."+username), record, key); err != nil {
+			return "", err
+		}
+	}
+
+	return a.generateToken(username, userDataDir, key)
+}
+
+// ChangePassphrase re-encrypts the user data under the key derived from the new passphrase
+// and invalidates the existing sessions of the user. It returns a token for the new passphrase.
+func (a Authenticator) ChangePassphrase(ctx context.Context, username, oldPassphrase, newPassphrase string) (string, error) {
+	ctx, span := trace.Start(ctx, "auth.ChangePassphrase")
+	defer span.End()
+
+	a = a.traced(ctx)
+
+	if username == "" || oldPassphrase == "" || newPassphrase == "" {
+		return "", ErrNotEnoughParams
+	}
+
+	if !validUsername(username) {
+		return "", ErrInvalidUsername
+	}
+
+	defer a.locks.lock(username)()
+
+	userDataDir, record, oldKey, err := a.verifyPassphrase(ctx, username, oldPassphrase)
 	if err != nil {
 		return "", err
 	}
 
-	if !bytes.Equal(plaintext, []byte(authMessage)) {
-		return "", errInvalidPassphrase
+	newKey, err := a.changeKey(ctx, username, userDataDir, record, oldKey, newPassphrase)
+	if err != nil {
+		return "", err
 	}
 
-	user := User{
-		Username: username,
-		DataDir:  userDataDir,
-		key:      key,
+	return a.generateToken(username, userDataDir, newKey)
+}
+
+// changeKey derives a new key from the passphrase with the current parameters,
+// re-encrypts the user data under it and invalidates the existing sessions of the user.
+// The key pair of the user is kept, so the files shared with the user remain readable.
+func (a Authenticator) changeKey(ctx context.Context, username, userDataDir string, oldRecord userRecord, oldKey []byte, passphrase string) ([]byte, error) {
+	record, newKey, err := newUserRecord(ctx, passphrase, a.kdfParams)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := oldRecord.keyPair(oldKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = record.setKeyPair(publicKey, privateKey, newKey); err != nil {
+		return nil, err
 	}
 
-	return a.tokenManager.GenerateToken(user)
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	journal, err := newRekeyJournal(data, oldKey, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = json.Marshal(journal); err != nil {
+		return nil, err
+	}
+
+	// Writes started with the old key are waited for, the ones that haven't started yet are rejected.
+	defer a.keyLocks.lockChange(username)()
+
+	a.keyLocks.retire(username, oldKey)
+	a.sessions.RevokeUser(username)
+
+	recordPath := filepath.Join(userDataDir, "."+username)
+
+	if err = writeFileAtomic(recordPath+journalSuffix, data, 0600); err != nil {
+		return nil, err
+	}
+
+	if err = rekey(ctx, a.store, username, userDataDir, recordPath, recordPath+journalSuffix, journal, oldKey, newKey); err != nil {
+		return nil, err
+	}
+
+	return newKey, nil
 }
 
-func (a Authenticator) ValidateToken(token string) (User, error) {
-	return a.tokenManager.ValidateToken(token)
+// ValidateToken validates the token and restores the user key from the session referenced by the token.
+func (a Authenticator) ValidateToken(ctx context.Context, token string) (User, error) {
+	_, span := trace.Start(ctx, "auth.ValidateToken")
+	defer span.End()
+
+	user, err := a.tokenManager.ValidateToken(token)
+	if err != nil {
+		return User{}, err
+	}
+
+	userSession, err := a.sessions.Get(user.SessionID)
+	if err != nil {
+		return User{}, err
+	}
+
+	if userSession.Username != user.Username {
+		return User{}, errSessionMismatch
+	}
+
+	user.key = userSession.Key
+
+	return user, nil
 }
 
-func (a Authenticator) verifyMasterKey(masterKey string) error {
-	if len(masterKey) != aes.KeyLength {
-		return errInvalidMasterKey
+// generateToken registers the key in a new session and returns a token referencing it.
+// The key itself never leaves the server.
+func (a Authenticator) generateToken(username, userDataDir string, key []byte) (string, error) {
+	sessionID, err := a.sessions.Create(username, key)
+	if err != nil {
+		return "", err
 	}
 
-	path := filepath.Join(a.dataDir, beaverFilename)
+	user := User{
+		Username:  username,
+		DataDir:   userDataDir,
+		SessionID: sessionID,
+	}
 
-	ciphertext, err := os.ReadFile(path)
+	token, err := a.tokenManager.GenerateToken(user)
 	if err != nil {
+		a.sessions.Revoke(sessionID)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// DeleteUser removes the user and all of its data. Either the user passphrase or the master key is required.
+// The data keys of the files are destroyed before anything is removed,
+// so the content can't be recovered from leftovers even with the passphrase.
+func (a Authenticator) DeleteUser(ctx context.Context, username, passphrase, masterKey string) error {
+	ctx, span := trace.Start(ctx, "auth.DeleteUser")
+	defer span.End()
+
+	a = a.traced(ctx)
+
+	if username == "" || (passphrase == "" && masterKey == "") {
+		return ErrNotEnoughParams
+	}
+
+	if !validUsername(username) {
+		return ErrInvalidUsername
+	}
+
+	defer a.locks.lock(username)()
+
+	userDataDir := filepath.Join(a.dataDir, username)
+
+	if masterKey != "" {
+		if err := verifyMasterKey(a.dataDir, masterKey); err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(userDataDir); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+	} else if _, _, _, err := a.verifyPassphrase(ctx, username, passphrase); err != nil {
 		return err
 	}
 
-	plaintext, err := aes.Decrypt(ciphertext, []byte(masterKey))
+	// Writes in flight finish before the files are shredded.
+	defer a.keyLocks.lockChange(username)()
+
+	a.sessions.RevokeUser(username)
+
+	err := walkUserFiles(a.store, username, func(info blob.Info) error {
+		return shredFile(a.store, info.Key)
+	})
 	if err != nil {
 		return err
 	}
 
-	if !bytes.Equal(plaintext, []byte(authMessage)) {
-		return errInvalidMasterKey
+	// The shares and the links of the files are removed before the entries recording them are.
+	if err = removeUserShares(a.store, username); err != nil {
+		return err
 	}
 
-	return nil
+	if err = removeUserLinks(a.store, username); err != nil {
+		return err
+	}
+
+	if err = deletePrefix(a.store, username+"/"); err != nil {
+		return err
+	}
+
+	recordPath := filepath.Join(userDataDir, "."+username)
+
+	for _, path := range []string{recordPath, recordPath + journalSuffix} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return os.RemoveAll(userDataDir)
+}
+
+// RotateMasterKey replaces the master key after verifying the current one and returns the new key.
+func (a Authenticator) RotateMasterKey(ctx context.Context, masterKey string) (string, error) {
+	_, span := trace.Start(ctx, "auth.RotateMasterKey")
+	defer span.End()
+
+	if masterKey == "" {
+		return "", ErrNotEnoughParams
+	}
+
+	a.masterKeyMu.Lock()
+	defer a.masterKeyMu.Unlock()
+
+	return RotateMasterKey(a.dataDir, masterKey)
+}
+
+// SetQuota sets the quota override of the user after verifying the master key.
+// A nil quota removes the override, so the default quota applies to the user again.
+func (a Authenticator) SetQuota(ctx context.Context, masterKey, username string, quota *Quota) error {
+	_, span := trace.Start(ctx, "auth.SetQuota")
+	defer span.End()
+
+	if masterKey == "" || username == "" {
+		return ErrNotEnoughParams
+	}
+
+	if !validUsername(username) {
+		return ErrInvalidUsername
+	}
+
+	if err := verifyMasterKey(a.dataDir, masterKey); err != nil {
+		return err
+	}
+
+	defer a.locks.lock(username)()
+
+	userDataDir := filepath.Join(a.dataDir, username)
+
+	if _, err := os.Stat(filepath.Join(userDataDir, "."+username)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	return writeQuota(userDataDir, quota)
+}
+
+// QueryAudit returns the audit log entries matching the filter after verifying the master key.
+func (a Authenticator) QueryAudit(ctx context.Context, masterKey string, filter audit.Filter) ([]audit.Entry, error) {
+	_, span := trace.Start(ctx, "auth.QueryAudit")
+	defer span.End()
+
+	if masterKey == "" {
+		return nil, ErrNotEnoughParams
+	}
+
+	if err := verifyMasterKey(a.dataDir, masterKey); err != nil {
+		return nil, err
+	}
+
+	return a.auditLog.Query(filter)
+}
+
+// verifyPassphrase returns the user data dir, the user record and the key derived from the passphrase
+// if the passphrase is valid. An interrupted key change is completed first.
+func (a Authenticator) verifyPassphrase(ctx context.Context, username, passphrase string) (userDataDir string, record userRecord, key []byte, err error) {
+	userDataDir = filepath.Join(a.dataDir, username)
+	recordPath := filepath.Join(userDataDir, "."+username)
+
+	record, err = readUserRecord(recordPath, username)
+	if err != nil {
+		return "", userRecord{}, nil, err
+	}
+
+	journal, ok, err := readRekeyJournal(recordPath + journalSuffix)
+	if err != nil {
+		return "", userRecord{}, nil, err
+	}
+
+	if ok {
+		newRecord, err := parseUserRecord(journal.Record, username)
+		if err != nil {
+			return "", userRecord{}, nil, err
+		}
+
+		oldKey, newKey, err := journal.keys(ctx, passphrase, record, newRecord)
+		if err != nil {
+			return "", userRecord{}, nil, err
+		}
+
+		a.logger.Infof("resuming interrupted key change of user %q", username)
+
+		unlock := a.keyLocks.lockChange(username)
+		a.keyLocks.retire(username, oldKey)
+
+		err = rekey(ctx, a.store, username, userDataDir, recordPath, recordPath+journalSuffix, journal, oldKey, newKey)
+		unlock()
+
+		if err != nil {
+			return "", userRecord{}, nil, err
+		}
+
+		record = newRecord
+	}
+
+	if key, err = record.verify(ctx, passphrase); err != nil {
+		return "", userRecord{}, nil, err
+	}
+
+	return userDataDir, record, key, nil
 }
 
 func (a Authenticator) generateMasterKeyIfNotExists() error {
@@ -168,17 +497,8 @@ func (a Authenticator) generateMasterKeyIfNotExists() error {
 		return err
 	}
 
-	masterKey, err := rand.Key(aes.KeyLength)
+	masterKey, err := writeNewMasterKey(a.dataDir)
 	if err != nil {
-		return err
-	}
-
-	ciphertext, err := aes.Encrypt([]byte(authMessage), masterKey)
-	if err != nil {
-		return err
-	}
-
-	if err = os.WriteFile(filepath.Join(a.dataDir, beaverFilename), ciphertext, 0400); err != nil {
 		_ = os.Remove(a.dataDir)
 		return err
 	}
@@ -188,10 +508,31 @@ func (a Authenticator) generateMasterKeyIfNotExists() error {
 	return err
 }
 
+// maxUsernameLength is the longest username, it keeps the paths of the user data within file name limits.
+const maxUsernameLength = 64
+
+// validUsername reports whether the username only holds ASCII letters, digits, '_' and '-'.
+// Every entry point taking a username checks it, as the username is joined into the paths of the user data,
+// and the record file named after it must not look like a file, a version or any other entry of the user data.
+func validUsername(username string) bool {
+	if username == "" || len(username) > maxUsernameLength {
+		return false
+	}
+
+	for _, r := range username {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
 type User struct {
-	Username string
-	DataDir  string
-	key      []byte
+	Username  string
+	DataDir   string
+	SessionID string
+	key       []byte
 }
 
 func (u User) Key() []byte {
@@ -200,6 +541,66 @@ func (u User) Key() []byte {
 	return key
 }
 
-func deriveKey(passphrase, salt string) []byte {
-	return pbkdf2.Key([]byte(passphrase), []byte(salt), 10000, aes.KeyLength, sha256.New)
+// userLocks serializes operations that read or change the key material of the same user.
+// Locks are released from the map once nobody holds or waits for them.
+type userLocks struct {
+	locks map[string]*userLock
+	mu    sync.Mutex
+}
+
+type userLock struct {
+	mu   sync.RWMutex
+	refs int
+}
+
+func newUserLocks() *userLocks {
+	return &userLocks{locks: make(map[string]*userLock)}
+}
+
+// lock locks the mutex of the key and returns a function that unlocks it.
+func (l *userLocks) lock(key string) (unlock func()) {
+	entry := l.acquire(key)
+
+	entry.mu.Lock()
+
+	return func() {
+		entry.mu.Unlock()
+		l.release(key, entry)
+	}
+}
+
+// rlock locks the mutex of the key for reading, so it is shared with other readers but excludes lock.
+func (l *userLocks) rlock(key string) (unlock func()) {
+	entry := l.acquire(key)
+
+	entry.mu.RLock()
+
+	return func() {
+		entry.mu.RUnlock()
+		l.release(key, entry)
+	}
+}
+
+func (l *userLocks) acquire(key string) *userLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.locks[key]
+	if !ok {
+		entry = &userLock{}
+		l.locks[key] = entry
+	}
+
+	entry.refs++
+
+	return entry
+}
+
+func (l *userLocks) release(key string, entry *userLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if entry.refs--; entry.refs == 0 {
+		delete(l.locks, key)
+	}
 }
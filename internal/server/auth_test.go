@@ -1,6 +1,11 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -8,23 +13,35 @@ import (
 	"time"
 
 	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/blob"
 	"github.com/KirillMironov/beaver/internal/jwt"
 	"github.com/KirillMironov/beaver/internal/log/observer"
+	"github.com/KirillMironov/beaver/internal/session"
 )
 
+var testKDFParams = Argon2Params{Time: 1, Memory: 1024, Threads: 1}
+
 func TestNewAuthenticator(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		name    string
-		dataDir string
-		wantErr bool
+		name       string
+		dataDir    string
+		invalidKDF bool
+		wantErr    bool
 	}{
 		{
 			name:    "valid data dir",
 			dataDir: t.TempDir(),
 			wantErr: false,
 		},
+		{
+			name:       "invalid kdf parameters",
+			dataDir:    t.TempDir(),
+			invalidKDF: true,
+			wantErr:    true,
+		},
 		{
 			name:    "invalid data dir",
 			dataDir: "",
@@ -76,7 +93,18 @@ func TestNewAuthenticator(t *testing.T) {
 
 			logger := observer.New()
 
-			_, err := NewAuthenticator(tc.dataDir, logger, jwt.NewManager[User]("secret", time.Hour))
+			var (
+				tokenManager = jwt.NewManager[User]("secret", time.Hour)
+				sessions     = session.NewMemoryStore(time.Hour, 10)
+				auditLog     = audit.NewFileLog(filepath.Join(t.TempDir(), "audit.log"), filepath.Join(t.TempDir(), "audit.key"))
+			)
+
+			kdfParams := testKDFParams
+			if tc.invalidKDF {
+				kdfParams = Argon2Params{}
+			}
+
+			_, err := NewAuthenticator(tc.dataDir, blob.NewMemoryStore(), logger, tokenManager, sessions, auditLog, NewKeyLocks(), kdfParams)
 			if err != nil != tc.wantErr {
 				t.Fatalf("NewAuthenticator() error = %v, wantErr %v", err, tc.wantErr)
 			}
@@ -121,7 +149,7 @@ func TestAuthenticator_AddUser(t *testing.T) {
 		{
 			name:     "invalid master key",
 			username: "user", passphrase: "passphrase", masterKey: "invalid",
-			wantErr: errInvalidMasterKey,
+			wantErr: ErrInvalidMasterKey,
 		},
 		{
 			name:     "valid user",
@@ -131,23 +159,28 @@ func TestAuthenticator_AddUser(t *testing.T) {
 		{
 			name:     "user already exists",
 			username: "user", passphrase: "passphrase", masterKey: masterKey,
-			wantErr: errUserAlreadyExists,
+			wantErr: ErrUserAlreadyExists,
 		},
 		{
 			name:     "empty username",
 			username: "", passphrase: "passphrase", masterKey: masterKey,
-			wantErr: errNotEnoughParams,
+			wantErr: ErrNotEnoughParams,
 		},
 		{
 			name:     "empty passphrase",
 			username: "user-2", passphrase: "", masterKey: masterKey,
-			wantErr: errNotEnoughParams,
+			wantErr: ErrNotEnoughParams,
+		},
+		{
+			name:     "reserved username",
+			username: ".links", passphrase: "passphrase", masterKey: masterKey,
+			wantErr: ErrInvalidUsername,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			token, err := authenticator.AddUser(tc.username, tc.passphrase, tc.masterKey)
+			token, err := authenticator.AddUser(context.Background(), tc.username, tc.passphrase, tc.masterKey)
 			if err != tc.wantErr {
 				t.Fatalf("AddUser() error = %v, wantErr %v", err, tc.wantErr)
 			}
@@ -156,7 +189,7 @@ func TestAuthenticator_AddUser(t *testing.T) {
 				return
 			}
 
-			user, err := authenticator.ValidateToken(token)
+			user, err := authenticator.ValidateToken(context.Background(), token)
 			if err != nil {
 				t.Fatalf("ValidateToken() error = %v", err)
 			}
@@ -165,6 +198,10 @@ func TestAuthenticator_AddUser(t *testing.T) {
 				t.Fatalf("AddUser() username = %v, want %v", user.Username, tc.username)
 			}
 
+			if !bytes.Equal(user.Key(), userKey(t, user, tc.passphrase)) {
+				t.Fatal("ValidateToken() user key does not match the derived key")
+			}
+
 			if _, err = os.Stat(user.DataDir); err != nil {
 				t.Fatalf("AddUser() user data dir does not exist: %v", err)
 			}
@@ -177,7 +214,7 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 
 	authenticator, masterKey := newAuthenticator(t)
 
-	_, err := authenticator.AddUser("user", "passphrase", masterKey)
+	_, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
 	if err != nil {
 		t.Fatalf("AddUser() error = %v", err)
 	}
@@ -217,7 +254,7 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			token, err := authenticator.Authenticate(tc.username, tc.passphrase)
+			token, err := authenticator.Authenticate(context.Background(), tc.username, tc.passphrase)
 			if err != nil != tc.wantErr {
 				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tc.wantErr)
 			}
@@ -226,7 +263,7 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 				return
 			}
 
-			user, err := authenticator.ValidateToken(token)
+			user, err := authenticator.ValidateToken(context.Background(), token)
 			if err != nil {
 				t.Fatalf("ValidateToken() error = %v", err)
 			}
@@ -238,6 +275,537 @@ func TestAuthenticator_Authenticate(t *testing.T) {
 	}
 }
 
+func TestAuthenticator_Authenticate_UpgradeKDF(t *testing.T) {
+	t.Parallel()
+
+	authenticator, _ := newAuthenticator(t)
+
+	// Create a user the way it was done before the user record was versioned.
+	legacyKey, err := legacyKDFParams("user").deriveKey(context.Background(), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier, err := aes.Encrypt([]byte(authMessage), legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Username: "user", DataDir: filepath.Join(authenticator.dataDir, "user"), key: legacyKey}
+
+	if err = os.Mkdir(user.DataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(user.DataDir, ".user"), verifier, 0400); err != nil {
+		t.Fatal(err)
+	}
+
+	uploadTestFiles(t, user)
+
+	token, err := authenticator.Authenticate(context.Background(), "user", "passphrase")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	record, err := readUserRecord(filepath.Join(user.DataDir, ".user"), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Version != recordVersion || record.KDF.Algorithm != kdfArgon2id || len(record.KDF.Salt) != kdfSaltSize || record.PublicKey == nil {
+		t.Fatalf("got record %+v, want upgraded record", record)
+	}
+
+	if user, err = authenticator.ValidateToken(context.Background(), token); err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	if bytes.Equal(user.Key(), legacyKey) {
+		t.Fatal("got the legacy key after the upgrade")
+	}
+
+	downloadTestFiles(t, user)
+
+	if _, err = authenticator.Authenticate(context.Background(), "user", "passphrase"); err != nil {
+		t.Fatalf("Authenticate() after the upgrade error = %v", err)
+	}
+}
+
+func TestAuthenticator_AddUser_RandomSalt(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	var keys [][]byte
+
+	for _, username := range []string{"user", "user-2"} {
+		token, err := authenticator.AddUser(context.Background(), username, "passphrase", masterKey)
+		if err != nil {
+			t.Fatalf("AddUser() error = %v", err)
+		}
+
+		user, err := authenticator.ValidateToken(context.Background(), token)
+		if err != nil {
+			t.Fatalf("ValidateToken() error = %v", err)
+		}
+
+		keys = append(keys, user.Key())
+	}
+
+	if bytes.Equal(keys[0], keys[1]) {
+		t.Fatal("got the same key for the same passphrase of different users")
+	}
+}
+
+func TestAuthenticator_ValidateToken_RevokedSession(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+	if err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+
+	authenticator.sessions.RevokeUser("user")
+
+	if _, err = authenticator.ValidateToken(context.Background(), token); !errors.Is(err, session.ErrNotFound) {
+		t.Fatalf("ValidateToken() error = %v, want %v", err, session.ErrNotFound)
+	}
+}
+
+func TestAuthenticator_ChangePassphrase(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+	if err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+
+	user, err := authenticator.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	uploadTestFiles(t, user)
+
+	if _, err = authenticator.ChangePassphrase(context.Background(), "user", "invalid", "new passphrase"); err != ErrInvalidPassphrase {
+		t.Fatalf("ChangePassphrase() error = %v, want %v", err, ErrInvalidPassphrase)
+	}
+
+	newToken, err := authenticator.ChangePassphrase(context.Background(), "user", "passphrase", "new passphrase")
+	if err != nil {
+		t.Fatalf("ChangePassphrase() error = %v", err)
+	}
+
+	if _, err = authenticator.ValidateToken(context.Background(), token); err == nil {
+		t.Fatal("ValidateToken() of the old token succeeded, want error")
+	}
+
+	if _, err = authenticator.Authenticate(context.Background(), "user", "passphrase"); err != ErrInvalidPassphrase {
+		t.Fatalf("Authenticate() error = %v, want %v", err, ErrInvalidPassphrase)
+	}
+
+	user, err = authenticator.ValidateToken(context.Background(), newToken)
+	if err != nil {
+		t.Fatalf("ValidateToken() error = %v", err)
+	}
+
+	downloadTestFiles(t, user)
+}
+
+func TestAuthenticator_ChangePassphrase_StaleKey(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	user := addTestUser(t, authenticator, "user", masterKey)
+
+	if _, err := authenticator.ChangePassphrase(context.Background(), "user", "passphrase", "new passphrase"); err != nil {
+		t.Fatalf("ChangePassphrase() error = %v", err)
+	}
+
+	// The user obtained the old key before the change, like a request in flight.
+	_, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent))
+	if !errors.Is(err, ErrKeyChanged) {
+		t.Fatalf("Upload() error = %v, want %v", err, ErrKeyChanged)
+	}
+
+	if _, err = authenticator.ChangePassphrase(context.Background(), "user", "new passphrase", "passphrase"); err != nil {
+		t.Fatalf("ChangePassphrase() error = %v", err)
+	}
+}
+
+func TestAuthenticator_ChangePassphrase_DuringUpload(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	user := addTestUser(t, authenticator, "user", masterKey)
+
+	pr, pw := io.Pipe()
+	uploaded := make(chan error, 1)
+
+	go func() {
+		_, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, pr)
+		uploaded <- err
+	}()
+
+	// The upload has started once it reads the content.
+	if _, err := pw.Write([]byte(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client is still sending the content, the change doesn't wait for it.
+	if _, err := authenticator.ChangePassphrase(context.Background(), "user", "passphrase", "new passphrase"); err != nil {
+		t.Fatalf("ChangePassphrase() error = %v", err)
+	}
+
+	_ = pw.Close()
+
+	if err := <-uploaded; !errors.Is(err, ErrKeyChanged) {
+		t.Fatalf("Upload() error = %v, want %v", err, ErrKeyChanged)
+	}
+
+	if _, err := storage.Stat(context.Background(), user, fileName); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Stat() error = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestAuthenticator_ChangePassphrase_Interrupted(t *testing.T) {
+	t.Parallel()
+
+	for _, passphrase := range []string{"passphrase", "new passphrase"} {
+		passphrase := passphrase
+
+		t.Run(passphrase, func(t *testing.T) {
+			t.Parallel()
+
+			authenticator, masterKey := newAuthenticator(t)
+
+			token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+			if err != nil {
+				t.Fatalf("AddUser() error = %v", err)
+			}
+
+			user, err := authenticator.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+
+			uploadTestFiles(t, user)
+
+			// Simulate a crash after the journal was written and one of the files was re-keyed.
+			record, newKey, err := newUserRecord(context.Background(), "new passphrase", testKDFParams)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := json.Marshal(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			oldKey := user.Key()
+
+			journal, err := newRekeyJournal(data, oldKey, newKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if data, err = json.Marshal(journal); err != nil {
+				t.Fatal(err)
+			}
+
+			recordPath := filepath.Join(user.DataDir, ".user")
+
+			if err = os.WriteFile(recordPath+journalSuffix, data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = rekeyFile(context.Background(), authenticator.store, user.Username+"/"+fileName, oldKey, newKey); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = authenticator.Authenticate(context.Background(), "user", passphrase)
+
+			if passphrase == "passphrase" && err != ErrInvalidPassphrase {
+				t.Fatalf("Authenticate() error = %v, want %v", err, ErrInvalidPassphrase)
+			}
+
+			if _, err = os.Stat(recordPath + journalSuffix); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("journal was not removed: %v", err)
+			}
+
+			token, err = authenticator.Authenticate(context.Background(), "user", "new passphrase")
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+
+			user, err = authenticator.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+
+			downloadTestFiles(t, user)
+		})
+	}
+}
+
+func TestAuthenticator_InvalidUsername(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	addTestUser(t, authenticator, "user", masterKey)
+
+	for _, username := range []string{".", "..", "x/..", "user/a", ".user", "x.v00000000000000000001", "user name", "üser", strings.Repeat("u", maxUsernameLength+1)} {
+		if _, err := authenticator.Authenticate(context.Background(), username, "passphrase"); err != ErrInvalidUsername {
+			t.Fatalf("Authenticate(%q) error = %v, want %v", username, err, ErrInvalidUsername)
+		}
+
+		if _, err := authenticator.ChangePassphrase(context.Background(), username, "passphrase", "new passphrase"); err != ErrInvalidUsername {
+			t.Fatalf("ChangePassphrase(%q) error = %v, want %v", username, err, ErrInvalidUsername)
+		}
+
+		if err := authenticator.SetQuota(context.Background(), masterKey, username, &Quota{MaxFiles: 1}); err != ErrInvalidUsername {
+			t.Fatalf("SetQuota(%q) error = %v, want %v", username, err, ErrInvalidUsername)
+		}
+
+		if _, err := authenticator.PublicKey(username); err != ErrInvalidUsername {
+			t.Fatalf("PublicKey(%q) error = %v, want %v", username, err, ErrInvalidUsername)
+		}
+	}
+}
+
+func TestAuthenticator_DeleteUser(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		username   string
+		passphrase string
+		masterKey  bool
+		wantErr    error
+	}{
+		{name: "by passphrase", passphrase: "passphrase"},
+		{name: "by master key", masterKey: true},
+		{name: "invalid passphrase", passphrase: "invalid", wantErr: ErrInvalidPassphrase},
+		{name: "no credentials", wantErr: ErrNotEnoughParams},
+		{name: "data dir", username: ".", masterKey: true, wantErr: ErrInvalidUsername},
+		{name: "traversal", username: "x/..", masterKey: true, wantErr: ErrInvalidUsername},
+		{name: "subdirectory", username: "user/a", masterKey: true, wantErr: ErrInvalidUsername},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			authenticator, masterKey := newAuthenticator(t)
+
+			token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+			if err != nil {
+				t.Fatalf("AddUser() error = %v", err)
+			}
+
+			user, err := authenticator.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatalf("ValidateToken() error = %v", err)
+			}
+
+			uploadTestFiles(t, user)
+
+			if !tc.masterKey {
+				masterKey = ""
+			}
+
+			username := tc.username
+			if username == "" {
+				username = "user"
+			}
+
+			err = authenticator.DeleteUser(context.Background(), username, tc.passphrase, masterKey)
+			if err != tc.wantErr {
+				t.Fatalf("DeleteUser() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if tc.wantErr != nil {
+				if _, err = os.Stat(user.DataDir); err != nil {
+					t.Fatalf("user data dir was removed: %v", err)
+				}
+				return
+			}
+
+			if _, err = os.Stat(user.DataDir); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("user data dir was not removed: %v", err)
+			}
+
+			if _, err = authenticator.ValidateToken(context.Background(), token); err == nil {
+				t.Fatal("ValidateToken() succeeded after the user was deleted")
+			}
+
+			if _, err = authenticator.Authenticate(context.Background(), "user", "passphrase"); err != ErrUserNotFound {
+				t.Fatalf("Authenticate() error = %v, want %v", err, ErrUserNotFound)
+			}
+		})
+	}
+}
+
+func TestAuthenticator_RotateMasterKey(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	if _, err := authenticator.RotateMasterKey(context.Background(), "invalid"); err != ErrInvalidMasterKey {
+		t.Fatalf("RotateMasterKey() error = %v, want %v", err, ErrInvalidMasterKey)
+	}
+
+	newMasterKey, err := authenticator.RotateMasterKey(context.Background(), masterKey)
+	if err != nil {
+		t.Fatalf("RotateMasterKey() error = %v", err)
+	}
+
+	if _, err = authenticator.RotateMasterKey(context.Background(), masterKey); err != ErrInvalidMasterKey {
+		t.Fatalf("RotateMasterKey() with the old master key error = %v, want %v", err, ErrInvalidMasterKey)
+	}
+
+	if _, err = authenticator.AddUser(context.Background(), "user", "passphrase", newMasterKey); err != nil {
+		t.Fatalf("AddUser() with the new master key error = %v", err)
+	}
+}
+
+func TestAuthenticator_RotatedMasterKey(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	// The key is rotated by the command while the server is running.
+	newMasterKey, err := RotateMasterKey(authenticator.dataDir, masterKey)
+	if err != nil {
+		t.Fatalf("RotateMasterKey() error = %v", err)
+	}
+
+	if _, err = authenticator.AddUser(context.Background(), "user", "passphrase", masterKey); err != ErrInvalidMasterKey {
+		t.Fatalf("AddUser() with the old master key error = %v, want %v", err, ErrInvalidMasterKey)
+	}
+
+	if _, err = authenticator.AddUser(context.Background(), "user", "passphrase", newMasterKey); err != nil {
+		t.Fatalf("AddUser() with the new master key error = %v", err)
+	}
+}
+
+func TestAuthenticator_QueryAudit(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	for _, username := range []string{"user", "other"} {
+		if err := authenticator.auditLog.Record(audit.Entry{Action: "set_quota", Username: username, Actor: "master key"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := authenticator.QueryAudit(context.Background(), "invalid", audit.Filter{}); err != ErrInvalidMasterKey {
+		t.Fatalf("QueryAudit() error = %v, want %v", err, ErrInvalidMasterKey)
+	}
+
+	entries, err := authenticator.QueryAudit(context.Background(), masterKey, audit.Filter{Action: "set_quota", Username: "user"})
+	if err != nil {
+		t.Fatalf("QueryAudit() error = %v", err)
+	}
+
+	if len(entries) != 1 || entries[0].Username != "user" || entries[0].Hash == "" {
+		t.Fatalf("got entries %+v, want one chained quota entry", entries)
+	}
+}
+
+func TestShredFile(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	uploadTestFiles(t, user)
+
+	for _, filename := range []string{fileName, file2Name} {
+		if err := shredFile(storage.store.store, user.Username+"/"+filename); err != nil {
+			t.Fatalf("shredFile(%q) error = %v", filename, err)
+		}
+
+		// Files without a header are not authenticated, so they may decrypt into garbage without an error.
+		dst := &strings.Builder{}
+
+		if _, err := newUserStorage(user).Download(context.Background(), user, filename, ByteRange{}, dst); err == nil && dst.String() == fileContent {
+			t.Fatalf("Download(%q) returned the content after the file was shredded", filename)
+		}
+	}
+}
+
+// newUserStorage returns a storage over the files of a user created by an authenticator from newAuthenticator.
+func newUserStorage(user User) *Storage {
+	return NewStorage(blob.NewLocalStore(filepath.Dir(user.DataDir)), nil, NewKeyLocks(), testUploadTimeout, Quota{}, ShareLinkPolicy{})
+}
+
+// uploadTestFiles uploads a file with a header and a file encrypted with the user key directly.
+func uploadTestFiles(t *testing.T, user User) {
+	t.Helper()
+
+	if _, err := newUserStorage(user).Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Create(filepath.Join(user.DataDir, file2Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err = aes.NewEncrypter(strings.NewReader(fileContent), file).Encrypt(user.Key()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func downloadTestFiles(t *testing.T, user User) {
+	t.Helper()
+
+	for _, filename := range []string{fileName, file2Name} {
+		dst := &strings.Builder{}
+
+		if _, err := newUserStorage(user).Download(context.Background(), user, filename, ByteRange{}, dst); err != nil {
+			t.Fatalf("Download(%q) error = %v", filename, err)
+		}
+
+		if got, want := dst.String(), fileContent; got != want {
+			t.Fatalf("Download(%q) = %q, want %q", filename, got, want)
+		}
+	}
+}
+
+// userKey derives the key of the user from the stored user record.
+func userKey(t *testing.T, user User, passphrase string) []byte {
+	t.Helper()
+
+	record, err := readUserRecord(filepath.Join(user.DataDir, "."+user.Username), user.Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := record.verify(context.Background(), passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
 func newAuthenticator(t *testing.T) (authenticator *Authenticator, masterKey string) {
 	t.Helper()
 
@@ -247,7 +815,9 @@ func newAuthenticator(t *testing.T) (authenticator *Authenticator, masterKey str
 
 	tokenManage := jwt.NewManager[User]("secret", time.Hour)
 
-	authenticator, err := NewAuthenticator(dataDir, logger, tokenManage)
+	auditLog := audit.NewFileLog(filepath.Join(dataDir, ".audit.log"), filepath.Join(dataDir, ".audit.key"))
+
+	authenticator, err := NewAuthenticator(dataDir, blob.NewLocalStore(dataDir), logger, tokenManage, session.NewMemoryStore(time.Hour, 10), auditLog, NewKeyLocks(), testKDFParams)
 	if err != nil {
 		t.Fatal(err)
 	}
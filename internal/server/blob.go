@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+// The files of a user are stored as blobs under "<username>/", the key of a file is its path prefixed with the username.
+// The store has no directories, a directory exists as long as a blob exists under it.
+// Directories created explicitly or as parents of uploaded files hold an empty ".dir" marker,
+// so they remain after their last file is removed.
+const dirMarker = ".dir"
+
+// pathStore reports keys rejected by the underlying store as invalid paths.
+type pathStore struct {
+	store blob.Store
+}
+
+func (s pathStore) Put(key string, src io.Reader) error {
+	return pathError(s.store.Put(key, src))
+}
+
+func (s pathStore) Get(key string) (blob.Blob, error) {
+	b, err := s.store.Get(key)
+	return b, pathError(err)
+}
+
+func (s pathStore) Delete(key string) error {
+	return pathError(s.store.Delete(key))
+}
+
+func (s pathStore) List(prefix string) ([]blob.Info, error) {
+	infos, err := s.store.List(prefix)
+	return infos, pathError(err)
+}
+
+func (s pathStore) Stat(key string) (blob.Info, error) {
+	info, err := s.store.Stat(key)
+	return info, pathError(err)
+}
+
+func (s pathStore) Move(src, dst string) error {
+	return pathError(blob.Move(s.store, src, dst))
+}
+
+func pathError(err error) error {
+	if errors.Is(err, blob.ErrInvalidKey) {
+		return fmt.Errorf("%w: %v", ErrInvalidPath, err)
+	}
+
+	return err
+}
+
+// putStream stores the content produced by write under the key.
+// If write fails, nothing is stored and its error is returned.
+func putStream(store blob.Store, key string, write func(io.Writer) error) error {
+	pr, pw := io.Pipe()
+
+	errs := make(chan error, 1)
+
+	go func() {
+		err := write(pw)
+		_ = pw.CloseWithError(err)
+		errs <- err
+	}()
+
+	err := store.Put(key, pr)
+
+	// Unblocks the writer if the store stopped reading early.
+	_ = pr.Close()
+
+	if writeErr := <-errs; writeErr != nil && !errors.Is(writeErr, io.ErrClosedPipe) {
+		return writeErr
+	}
+
+	return err
+}
+
+func putBytes(store blob.Store, key string, data []byte) error {
+	return store.Put(key, bytes.NewReader(data))
+}
+
+func readBlob(store blob.Store, key string) ([]byte, error) {
+	b, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer b.Close()
+
+	return io.ReadAll(b)
+}
+
+// deletePrefix deletes every blob whose key starts with the prefix.
+func deletePrefix(store blob.Store, prefix string) error {
+	infos, err := store.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if err = store.Delete(info.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tempKey returns a new hidden key next to the key to write its content to before it is published.
+func tempKey(key string) (string, error) {
+	suffix, err := rand.Bytes(8)
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(path.Dir(key), "."+path.Base(key)+"."+hex.EncodeToString(suffix)+".tmp"), nil
+}
+
+// walkUserFiles calls fn for every blob uploaded by the user,
+// including the previous versions of files and the parts of upload sessions.
+// Other blobs with an element starting with a dot hold internal data and are skipped.
+func walkUserFiles(store blob.Store, username string, fn func(blob.Info) error) error {
+	infos, err := store.List(username + "/")
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if !isUserFile(strings.TrimPrefix(info.Key, username+"/")) {
+			continue
+		}
+
+		if err = fn(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isUserFile reports whether the key relative to the user prefix holds a file, a previous version or an upload part.
+func isUserFile(rel string) bool {
+	elements := strings.Split(rel, "/")
+
+	if len(elements) == 3 && elements[0] == uploadsDirname {
+		return !strings.HasPrefix(elements[2], ".")
+	}
+
+	for _, element := range elements[:len(elements)-1] {
+		if strings.HasPrefix(element, ".") {
+			return false
+		}
+	}
+
+	name := elements[len(elements)-1]
+
+	return !strings.HasPrefix(name, ".") || isVersionFilename(name)
+}
+
+// isUploadPart reports whether the key relative to the user prefix holds a part of an upload session.
+func isUploadPart(rel string) bool {
+	return strings.HasPrefix(rel, uploadsDirname+"/")
+}
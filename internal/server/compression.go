@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+// Compression selects whether the plaintext of an upload is compressed before it is encrypted,
+// as encrypted content can't be compressed afterwards.
+type Compression int
+
+const (
+	// CompressionNone stores the plaintext as is.
+	CompressionNone Compression = iota
+	// CompressionGzip compresses the plaintext with gzip.
+	CompressionGzip
+	// CompressionAuto compresses the plaintext with gzip unless its content type is already compressed.
+	CompressionAuto
+)
+
+// codec identifies the compression of a stored file in its header.
+type codec byte
+
+const (
+	codecNone codec = iota
+	codecGzip
+)
+
+// sniffSize is the number of leading bytes the content type of a plaintext is detected from.
+const sniffSize = 512
+
+// incompressibleTypes are the prefixes of detected content types whose formats are compressed already.
+var incompressibleTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff",
+	"application/zip",
+	"application/x-gzip",
+	"application/x-rar-compressed",
+	"application/pdf",
+	"application/wasm",
+}
+
+func (c codec) valid() bool {
+	return c == codecNone || c == codecGzip
+}
+
+func (c codec) String() string {
+	switch c {
+	case codecNone:
+		return "none"
+	case codecGzip:
+		return "gzip"
+	default:
+		return "unknown"
+	}
+}
+
+// chooseCodec returns the codec to store the plaintext read from src with.
+// Automatic compression peeks at the beginning of src, so src must be read from the returned reader.
+func chooseCodec(compression Compression, src io.Reader) (codec, io.Reader) {
+	switch compression {
+	case CompressionGzip:
+		return codecGzip, src
+	case CompressionAuto:
+		reader := bufio.NewReaderSize(src, sniffSize)
+
+		// A short or failing source is sniffed by what has been read, the error is returned by later reads.
+		head, _ := reader.Peek(sniffSize)
+
+		if compressible(head) {
+			return codecGzip, reader
+		}
+
+		return codecNone, reader
+	default:
+		return codecNone, src
+	}
+}
+
+// compressible reports whether the content starting with head is worth compressing.
+func compressible(head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+
+	contentType := http.DetectContentType(head)
+
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// compress returns a reader of src compressed with the codec.
+// The reader must be closed to release the compressing goroutine if it isn't read to the end.
+func compress(src io.Reader, codec codec) io.ReadCloser {
+	if codec == codecNone {
+		return io.NopCloser(src)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		writer := gzip.NewWriter(pw)
+
+		_, err := io.Copy(writer, src)
+		if err == nil {
+			err = writer.Close()
+		}
+
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// decryptStream decrypts src with the key and writes the plaintext decompressed with the codec to dst.
+func decryptStream(ctx context.Context, dst io.Writer, src io.Reader, key []byte, codec codec) (err error) {
+	_, span := trace.Start(ctx, "aes.decrypt")
+	span.SetAttribute("codec", codec.String())
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	if codec == codecNone {
+		return aes.NewDecrypter(src, dst).Decrypt(key)
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		_ = pw.CloseWithError(aes.NewDecrypter(src, pw).Decrypt(key))
+	}()
+
+	// Stops the decryption if decompression fails.
+	defer pr.Close()
+
+	reader, err := gzip.NewReader(pr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, reader)
+
+	return err
+}
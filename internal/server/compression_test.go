@@ -0,0 +1,215 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+)
+
+func TestStorage_Compression(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	text := strings.Repeat("0123456789", 20000)
+	png := "\x89PNG\r\n\x1a\n" + text
+
+	tests := []struct {
+		filename    string
+		compression Compression
+		content     string
+		want        codec
+	}{
+		{filename: "none.txt", compression: CompressionNone, content: text, want: codecNone},
+		{filename: "gzip.txt", compression: CompressionGzip, content: text, want: codecGzip},
+		{filename: "auto.txt", compression: CompressionAuto, content: text, want: codecGzip},
+		{filename: "auto.png", compression: CompressionAuto, content: png, want: codecNone},
+		{filename: "empty.txt", compression: CompressionAuto, content: "", want: codecNone},
+		{filename: "gzip-empty.txt", compression: CompressionGzip, content: "", want: codecGzip},
+	}
+
+	for _, tc := range tests {
+		metadata := FileMetadata{Filename: tc.filename, Size: int64(len(tc.content)), Compression: tc.compression}
+
+		result, err := storage.Upload(context.Background(), user, metadata, strings.NewReader(tc.content))
+		if err != nil {
+			t.Fatalf("%s: %v", tc.filename, err)
+		}
+
+		if digest := sha256.Sum256([]byte(tc.content)); !bytes.Equal(result.Digest, digest[:]) {
+			t.Fatalf("%s: got digest %x, want the digest of the plaintext %x", tc.filename, result.Digest, digest)
+		}
+
+		if got := readCodec(t, filepath.Join(user.DataDir, tc.filename)); got != tc.want {
+			t.Fatalf("%s: got codec %d, want %d", tc.filename, got, tc.want)
+		}
+
+		info, err := storage.Stat(context.Background(), user, tc.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info.Size != int64(len(tc.content)) {
+			t.Fatalf("%s: got size %d, want %d", tc.filename, info.Size, len(tc.content))
+		}
+
+		if compressed := tc.want == codecGzip && tc.content != ""; compressed != (info.StoredSize < info.Size) {
+			t.Fatalf("%s: got stored size %d for %d bytes", tc.filename, info.StoredSize, info.Size)
+		}
+
+		dst := &strings.Builder{}
+
+		if _, err = storage.Download(context.Background(), user, tc.filename, ByteRange{}, dst); err != nil {
+			t.Fatalf("%s: %v", tc.filename, err)
+		}
+
+		if dst.String() != tc.content {
+			t.Fatalf("%s: got %d bytes, want %d", tc.filename, dst.Len(), len(tc.content))
+		}
+	}
+
+	size := int64(len(text))
+
+	for _, byteRange := range []ByteRange{{Offset: 1}, {Offset: 70000, Length: 10}, {Offset: size - 5, Length: 100}, {Offset: size}} {
+		dst := &strings.Builder{}
+
+		if _, err := storage.Download(context.Background(), user, "gzip.txt", byteRange, dst); err != nil {
+			t.Fatalf("%+v: %v", byteRange, err)
+		}
+
+		end := size
+		if byteRange.Length > 0 && byteRange.Offset+byteRange.Length < size {
+			end = byteRange.Offset + byteRange.Length
+		}
+
+		if got, want := dst.String(), text[byteRange.Offset:end]; got != want {
+			t.Fatalf("%+v: got %d bytes, want %d", byteRange, len(got), len(want))
+		}
+	}
+
+	// A copy is encrypted under a new data key but keeps the compression of its source.
+	if err := storage.Copy(context.Background(), user, "gzip.txt", "copy.txt", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readCodec(t, filepath.Join(user.DataDir, "copy.txt")); got != codecGzip {
+		t.Fatalf("got codec %d for a copy, want %d", got, codecGzip)
+	}
+}
+
+func TestStorage_Compression_ResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	content := strings.Repeat("0123456789", 1000)
+
+	session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName, Compression: CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, part := range []string{content[:4000], content[4000:]} {
+		if session, err = storage.AppendUpload(context.Background(), user, session.ID, session.CommittedOffset, strings.NewReader(part)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readCodec(t, filepath.Join(user.DataDir, fileName)); got != codecGzip {
+		t.Fatalf("got codec %d, want %d", got, codecGzip)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err = storage.Download(context.Background(), user, fileName, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if dst.String() != content {
+		t.Fatalf("got %d bytes, want %d", dst.Len(), len(content))
+	}
+}
+
+// TestStorage_Download_HeaderVersion1 checks that files stored before the codec was recorded remain readable.
+func TestStorage_Download_HeaderVersion1(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	header, dataKey, err := newFileHeader(user.Key(), codecNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header.version = 1
+
+	data, err := header.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(data)) != codecOffset {
+		t.Fatalf("got a header of %d bytes, want %d", len(data), codecOffset)
+	}
+
+	content := strings.Repeat("0123456789", 20000)
+
+	buf := bytes.NewBuffer(data)
+
+	if err = aes.NewEncrypter(strings.NewReader(content), buf).Encrypt(dataKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(filepath.Join(user.DataDir, fileName), buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, byteRange := range []ByteRange{{}, {Offset: 70000, Length: 10}} {
+		dst := &strings.Builder{}
+
+		if _, err = storage.Download(context.Background(), user, fileName, byteRange, dst); err != nil {
+			t.Fatalf("%+v: %v", byteRange, err)
+		}
+
+		end := int64(len(content))
+		if byteRange.Length > 0 {
+			end = byteRange.Offset + byteRange.Length
+		}
+
+		if got, want := dst.String(), content[byteRange.Offset:end]; got != want {
+			t.Fatalf("%+v: got %d bytes, want %d", byteRange, len(got), len(want))
+		}
+	}
+}
+
+func readCodec(t *testing.T, filename string) codec {
+	t.Helper()
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	header, ok, err := readFileHeader(bufio.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok {
+		t.Fatalf("file %q has no header", filename)
+	}
+
+	return header.codec
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"time"
 
 	"github.com/caarlos0/env/v8"
@@ -14,8 +15,86 @@ type Config struct {
 		Secret   string        `env:"JWT_SECRET,required"`
 		TokenTTL time.Duration `env:"JWT_TOKEN_TTL" envDefault:"1h"`
 	}
+
+	KDF struct {
+		Time    uint32 `env:"KDF_TIME" envDefault:"3"`
+		Memory  uint32 `env:"KDF_MEMORY" envDefault:"65536"`
+		Threads uint8  `env:"KDF_THREADS" envDefault:"4"`
+	}
+
+	Sessions struct {
+		Limit int `env:"SESSIONS_LIMIT" envDefault:"10000"`
+	}
+
+	Uploads struct {
+		Timeout         time.Duration `env:"UPLOADS_TIMEOUT" envDefault:"24h"`
+		CollectInterval time.Duration `env:"UPLOADS_COLLECT_INTERVAL" envDefault:"1h"`
+	}
+
+	// ShareLinks limits the lifetime of share links, zero is unlimited.
+	// Keys are derived from the passphrases of share links with the KDF parameters.
+	ShareLinks struct {
+		MaxTTL time.Duration `env:"SHARE_LINKS_MAX_TTL" envDefault:"168h"`
+	}
+
+	// Metrics serves Prometheus metrics at "/metrics" on Address, an empty Address disables them.
+	// The storage gauges list every stored file, so they are refreshed every StatsInterval rather than on each scrape.
+	Metrics struct {
+		Address       string        `env:"METRICS_ADDRESS"`
+		StatsInterval time.Duration `env:"METRICS_STATS_INTERVAL" envDefault:"1m"`
+	}
+
+	// Tracing exports a span for every RPC and for the storage, key derivation and encryption steps it takes.
+	// Exporter is "none", "stdout" or "file", which appends the spans as JSON lines to File.
+	Tracing struct {
+		Exporter string `env:"TRACING_EXPORTER" envDefault:"none"`
+		File     string `env:"TRACING_FILE"`
+	}
+
+	// Quota is the default for users without an override, zero limits are unlimited.
+	Quota struct {
+		MaxBytes int64 `env:"QUOTA_MAX_BYTES" envDefault:"0"`
+		MaxFiles int64 `env:"QUOTA_MAX_FILES" envDefault:"0"`
+	}
+
+	// Blob selects where the encrypted files are kept: "local" keeps them in DataDir,
+	// "memory" loses them on restart and "s3" keeps them in an S3-compatible bucket.
+	// User records and the master key are kept in DataDir regardless of the backend.
+	Blob struct {
+		Backend string `env:"BLOB_BACKEND" envDefault:"local"`
+
+		S3 struct {
+			Endpoint        string `env:"S3_ENDPOINT"`
+			Region          string `env:"S3_REGION" envDefault:"us-east-1"`
+			Bucket          string `env:"S3_BUCKET"`
+			AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+			SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+			// Timeout bounds waiting for a response, and whole requests that don't transfer file content.
+			Timeout time.Duration `env:"S3_TIMEOUT" envDefault:"30s"`
+		}
+	}
 }
 
 func Load() (config Config, _ error) {
-	return config, env.ParseWithOptions(&config, env.Options{Prefix: "BEAVER_"})
+	if err := env.ParseWithOptions(&config, env.Options{Prefix: "BEAVER_"}); err != nil {
+		return Config{}, err
+	}
+
+	return config, config.validate()
+}
+
+func (c Config) validate() error {
+	if c.Uploads.CollectInterval <= 0 {
+		return errors.New("uploads collect interval must be positive")
+	}
+
+	if c.Metrics.StatsInterval <= 0 {
+		return errors.New("metrics stats interval must be positive")
+	}
+
+	if c.Blob.S3.Timeout <= 0 {
+		return errors.New("s3 timeout must be positive")
+	}
+
+	return nil
 }
@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "zero collect interval", env: map[string]string{"BEAVER_UPLOADS_COLLECT_INTERVAL": "0s"}, wantErr: true},
+		{name: "negative collect interval", env: map[string]string{"BEAVER_UPLOADS_COLLECT_INTERVAL": "-1m"}, wantErr: true},
+		{name: "zero stats interval", env: map[string]string{"BEAVER_METRICS_STATS_INTERVAL": "0s"}, wantErr: true},
+		{name: "zero s3 timeout", env: map[string]string{"BEAVER_S3_TIMEOUT": "0s"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("BEAVER_DATA_DIR", t.TempDir())
+			t.Setenv("BEAVER_JWT_SECRET", "secret")
+
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			if _, err := Load(); (err != nil) != tc.wantErr {
+				t.Fatalf("got %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
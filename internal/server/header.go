@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+// Every stored file starts with a header
+// followed by the file content encrypted with a random data key:
+//
+//	magic (4) | version (1) | wrapped data key (80) | codec (1)
+//
+// The data key is wrapped with the user key, so re-keying a file only requires a new header, not re-encrypting the content.
+// The codec the plaintext was compressed with before encryption was added in version 2,
+// headers of version 1 end with the wrapped key and their files are not compressed.
+// Files uploaded before the header was introduced are encrypted with the user key directly.
+const (
+	headerVersion    = 2
+	headerMagicSize  = 4
+	wrappedKeyOffset = headerMagicSize + 1
+	wrappedKeySize   = 80
+	codecOffset      = wrappedKeyOffset + wrappedKeySize
+	fileHeaderSize   = codecOffset + 1
+)
+
+var headerMagic = []byte{0x89, 'B', 'V', 'F'}
+
+var errInvalidHeader = errors.New("invalid file header")
+
+type fileHeader struct {
+	version    byte
+	wrappedKey []byte
+	codec      codec
+}
+
+// newFileHeader generates a random data key and returns a header holding the key wrapped with the user key
+// and the codec of the file.
+func newFileHeader(userKey []byte, codec codec) (header fileHeader, dataKey []byte, err error) {
+	dataKey, err = rand.Bytes(aes.KeyLength)
+	if err != nil {
+		return fileHeader{}, nil, err
+	}
+
+	header.version = headerVersion
+	header.codec = codec
+
+	if err = header.wrap(dataKey, userKey); err != nil {
+		return fileHeader{}, nil, err
+	}
+
+	return header, dataKey, nil
+}
+
+// readFileHeader reads the header from the beginning of the file.
+// It returns false if the file was stored without a header.
+func readFileHeader(r *bufio.Reader) (fileHeader, bool, error) {
+	magic, err := r.Peek(headerMagicSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fileHeader{}, false, err
+	}
+
+	if !bytes.Equal(magic, headerMagic) {
+		return fileHeader{}, false, nil
+	}
+
+	version, err := r.Peek(headerMagicSize + 1)
+	if err != nil {
+		return fileHeader{}, false, errInvalidHeader
+	}
+
+	header := fileHeader{version: version[headerMagicSize]}
+
+	if header.version < 1 || header.version > headerVersion {
+		return fileHeader{}, false, errInvalidHeader
+	}
+
+	data := make([]byte, header.size())
+
+	if _, err = io.ReadFull(r, data); err != nil {
+		return fileHeader{}, false, errInvalidHeader
+	}
+
+	header.wrappedKey = data[wrappedKeyOffset:codecOffset]
+
+	if header.version >= 2 {
+		header.codec = codec(data[codecOffset])
+		if !header.codec.valid() {
+			return fileHeader{}, false, errInvalidHeader
+		}
+	}
+
+	return header, true, nil
+}
+
+func (h fileHeader) MarshalBinary() ([]byte, error) {
+	data := make([]byte, 0, fileHeaderSize)
+	data = append(data, headerMagic...)
+	data = append(data, h.version)
+	data = append(data, h.wrappedKey...)
+
+	if h.version >= 2 {
+		data = append(data, byte(h.codec))
+	}
+
+	return data, nil
+}
+
+// size returns the size of the header, which is followed by the encrypted content.
+func (h fileHeader) size() int64 {
+	if h.version < 2 {
+		return codecOffset
+	}
+
+	return fileHeaderSize
+}
+
+// dataKey unwraps the data key with the user key.
+func (h fileHeader) dataKey(userKey []byte) ([]byte, error) {
+	return aes.Decrypt(h.wrappedKey, userKey)
+}
+
+func (h *fileHeader) wrap(dataKey, userKey []byte) error {
+	wrappedKey, err := aes.Encrypt(dataKey, userKey)
+	if err != nil {
+		return err
+	}
+
+	if len(wrappedKey) != wrappedKeySize {
+		return errInvalidHeader
+	}
+
+	h.wrappedKey = wrappedKey
+
+	return nil
+}
+
+// fileKey returns the key the file content is encrypted with and the codec of the plaintext,
+// consuming the header if there is one.
+func fileKey(r *bufio.Reader, userKey []byte) ([]byte, codec, error) {
+	header, ok, err := readFileHeader(r)
+	if err != nil {
+		return nil, codecNone, err
+	}
+
+	if !ok {
+		return userKey, codecNone, nil
+	}
+
+	key, err := header.dataKey(userKey)
+
+	return key, header.codec, err
+}
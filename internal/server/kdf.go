@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/rand"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+var errInvalidKDFParams = errors.New("invalid key derivation parameters")
+
+// ErrTooManyAttempts is returned when a passphrase can't be checked because too many were checked recently.
+var ErrTooManyAttempts = errors.New("too many passphrase attempts, try again later")
+
+const (
+	kdfArgon2id = "argon2id"
+	kdfPBKDF2   = "pbkdf2-sha256"
+
+	kdfSaltSize      = 16
+	pbkdf2Iterations = 10000
+)
+
+// Argon2Params defines the cost of the key derivation for new keys.
+// Memory is measured in KiB.
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// kdfParams describes how a user key is derived from the passphrase.
+// It is stored in the user record, so the parameters can be changed without breaking existing users.
+type kdfParams struct {
+	Algorithm  string `json:"algorithm"`
+	Salt       []byte `json:"salt"`
+	Time       uint32 `json:"time,omitempty"`
+	Memory     uint32 `json:"memory,omitempty"`
+	Threads    uint8  `json:"threads,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+// Validate fails unless the parameters are usable by Argon2id.
+func (p Argon2Params) Validate() error {
+	if p.Time < 1 || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) {
+		return fmt.Errorf("%w: time and threads must be positive and memory at least 8 KiB per thread", errInvalidKDFParams)
+	}
+
+	return nil
+}
+
+// newKDFParams returns Argon2id parameters with a random salt.
+func newKDFParams(params Argon2Params) (kdfParams, error) {
+	salt, err := rand.Bytes(kdfSaltSize)
+	if err != nil {
+		return kdfParams{}, err
+	}
+
+	return kdfParams{
+		Algorithm: kdfArgon2id,
+		Salt:      salt,
+		Time:      params.Time,
+		Memory:    params.Memory,
+		Threads:   params.Threads,
+	}, nil
+}
+
+// legacyKDFParams returns the parameters of users created before the user record was versioned.
+func legacyKDFParams(username string) kdfParams {
+	return kdfParams{
+		Algorithm:  kdfPBKDF2,
+		Salt:       []byte(username),
+		Iterations: pbkdf2Iterations,
+	}
+}
+
+func (p kdfParams) deriveKey(ctx context.Context, passphrase string) ([]byte, error) {
+	_, span := trace.Start(ctx, "kdf.derive_key")
+	span.SetAttribute("algorithm", p.Algorithm)
+	defer span.End()
+
+	switch p.Algorithm {
+	case kdfArgon2id:
+		// Argon2 panics on zero parameters, which a damaged record could hold.
+		if err := (Argon2Params{Time: p.Time, Memory: p.Memory, Threads: p.Threads}).Validate(); err != nil {
+			return nil, err
+		}
+		return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, aes.KeyLength), nil
+	case kdfPBKDF2:
+		return pbkdf2.Key([]byte(passphrase), p.Salt, p.Iterations, aes.KeyLength, sha256.New), nil
+	default:
+		return nil, fmt.Errorf("unknown key derivation function %q", p.Algorithm)
+	}
+}
+
+// outdated reports whether the parameters are weaker than the given ones.
+// Parameters that only differ, like the ones of a lowered configuration, are kept,
+// as an upgrade re-keys every file of the user and ends the sessions of the user.
+// The parallelism doesn't change the cost of a guess, so it is never upgraded alone.
+func (p kdfParams) outdated(params Argon2Params) bool {
+	return p.Algorithm != kdfArgon2id || p.Time < params.Time || p.Memory < params.Memory
+}
+
+// kdfLimiter bounds the cost of the key derivations anyone can request, like the ones of protected share links.
+// Up to burst derivations start at once and one more every interval, the others fail at once instead of queueing,
+// and at most concurrency of them run at the same time.
+type kdfLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+	burst    int
+	now      func() time.Time
+	mu       sync.Mutex
+	tokens   int
+	last     time.Time
+}
+
+func newKDFLimiter(concurrency int, interval time.Duration, burst int) *kdfLimiter {
+	return &kdfLimiter{
+		slots:    make(chan struct{}, concurrency),
+		interval: interval,
+		burst:    burst,
+		now:      time.Now,
+		tokens:   burst,
+	}
+}
+
+// deriveKey derives the key once a slot is free, failing with ErrTooManyAttempts if too many derivations
+// were requested recently.
+func (l *kdfLimiter) deriveKey(ctx context.Context, params kdfParams, passphrase string) ([]byte, error) {
+	if !l.allow() {
+		return nil, ErrTooManyAttempts
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-l.slots }()
+
+	return params.deriveKey(ctx, passphrase)
+}
+
+// allow takes a token of the bucket refilled one every interval up to burst.
+func (l *kdfLimiter) allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	if l.last.IsZero() {
+		l.last = now
+	}
+
+	if refill := int(now.Sub(l.last) / l.interval); refill > 0 {
+		l.tokens += refill
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = l.last.Add(time.Duration(refill) * l.interval)
+	}
+
+	if l.tokens == 0 {
+		return false
+	}
+
+	l.tokens--
+
+	return true
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestArgon2Params_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		params  Argon2Params
+		wantErr bool
+	}{
+		{name: "valid", params: testKDFParams},
+		{name: "zero", params: Argon2Params{}, wantErr: true},
+		{name: "zero time", params: Argon2Params{Memory: 1024, Threads: 1}, wantErr: true},
+		{name: "zero threads", params: Argon2Params{Time: 1, Memory: 1024}, wantErr: true},
+		{name: "memory below threads", params: Argon2Params{Time: 1, Memory: 8, Threads: 2}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		err := tc.params.Validate()
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: got %v, wantErr %v", tc.name, err, tc.wantErr)
+		}
+
+		if err == nil {
+			continue
+		}
+
+		// Records holding the same parameters fail instead of crashing the key derivation.
+		params := kdfParams{Algorithm: kdfArgon2id, Time: tc.params.Time, Memory: tc.params.Memory, Threads: tc.params.Threads}
+
+		if _, err = params.deriveKey(context.Background(), "passphrase"); !errors.Is(err, errInvalidKDFParams) {
+			t.Fatalf("%s: got %v, want %v", tc.name, err, errInvalidKDFParams)
+		}
+	}
+}
+
+func TestKDFParams_Outdated(t *testing.T) {
+	t.Parallel()
+
+	current := Argon2Params{Time: 3, Memory: 65536, Threads: 4}
+
+	tests := []struct {
+		name   string
+		params kdfParams
+		want   bool
+	}{
+		{name: "legacy", params: legacyKDFParams("user"), want: true},
+		{name: "same", params: kdfParams{Algorithm: kdfArgon2id, Time: 3, Memory: 65536, Threads: 4}},
+		{name: "less time", params: kdfParams{Algorithm: kdfArgon2id, Time: 2, Memory: 65536, Threads: 4}, want: true},
+		{name: "less memory", params: kdfParams{Algorithm: kdfArgon2id, Time: 3, Memory: 32768, Threads: 4}, want: true},
+		{name: "stronger", params: kdfParams{Algorithm: kdfArgon2id, Time: 4, Memory: 131072, Threads: 4}},
+		{name: "other threads", params: kdfParams{Algorithm: kdfArgon2id, Time: 3, Memory: 65536, Threads: 1}},
+	}
+
+	for _, tc := range tests {
+		if got := tc.params.outdated(current); got != tc.want {
+			t.Fatalf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestKDFLimiter(t *testing.T) {
+	t.Parallel()
+
+	params, err := newKDFParams(testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	limiter := newKDFLimiter(1, time.Second, 2)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err = limiter.deriveKey(context.Background(), params, "passphrase"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = limiter.deriveKey(context.Background(), params, "passphrase"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Fatalf("got %v past the burst, want %v", err, ErrTooManyAttempts)
+	}
+
+	now = now.Add(time.Second)
+
+	// The only slot is taken, so the derivation waits until the client gives up.
+	limiter.slots <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err = limiter.deriveKey(ctx, params, "passphrase"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v with every slot taken, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+)
+
+// ErrKeyChanged is returned when a write was requested with a user key replaced by a passphrase change.
+var ErrKeyChanged = errors.New("user key has changed, sign in again")
+
+// maxRetiredKeys is the number of replaced keys remembered per user. Older keys belong to sessions
+// revoked long ago, so no request can still hold them.
+const maxRetiredKeys = 8
+
+// KeyLocks is shared by the storage and the authenticator, so the writes encrypted with a user key
+// are never published while the key is changed or the user is deleted.
+// The content of a write is encrypted without holding the lock, only its publication waits for a running change,
+// so a slow client can't hold off the change. A write that started before a change fails when it is published.
+// Replaced keys are remembered, so the writes that obtained the old key before the change fail too
+// instead of storing files no key of the user can decrypt anymore.
+type KeyLocks struct {
+	locks       *userLocks
+	mu          sync.Mutex
+	retired     map[string][][sha256.Size]byte
+	generations map[string]uint64
+}
+
+func NewKeyLocks() *KeyLocks {
+	return &KeyLocks{
+		locks:       newUserLocks(),
+		retired:     make(map[string][][sha256.Size]byte),
+		generations: make(map[string]uint64),
+	}
+}
+
+// keyWrite is a write encrypted with the key of the user, started before its content is received.
+type keyWrite struct {
+	locks      *KeyLocks
+	user       User
+	generation uint64
+}
+
+// startWrite starts a write with the key of the user, failing with ErrKeyChanged if the key has been replaced.
+func (l *KeyLocks) startWrite(user User) (keyWrite, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isRetired(user) {
+		return keyWrite{}, ErrKeyChanged
+	}
+
+	return keyWrite{locks: l, user: user, generation: l.generations[user.Username]}, nil
+}
+
+// lock locks the key of the user to publish the write, failing with ErrKeyChanged
+// if the key has been replaced or changed since the write started.
+func (w keyWrite) lock() (unlock func(), err error) {
+	unlock = w.locks.locks.rlock(w.user.Username)
+
+	w.locks.mu.Lock()
+	changed := w.locks.isRetired(w.user) || w.locks.generations[w.user.Username] != w.generation
+	w.locks.mu.Unlock()
+
+	if changed {
+		unlock()
+		return nil, ErrKeyChanged
+	}
+
+	return unlock, nil
+}
+
+// lockChange locks the key of the user for a change once the running publications are done.
+// The writes started before the change is unlocked fail when they are published.
+func (l *KeyLocks) lockChange(username string) (unlock func()) {
+	unlockChange := l.locks.lock(username)
+
+	return func() {
+		l.mu.Lock()
+		l.generations[username]++
+		l.mu.Unlock()
+
+		unlockChange()
+	}
+}
+
+// retire rejects the following writes with the key. The caller must hold the lock of the change.
+func (l *KeyLocks) retire(username string, key []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	retired := append(l.retired[username], keyFingerprint(key))
+	if len(retired) > maxRetiredKeys {
+		retired = append([][sha256.Size]byte(nil), retired[len(retired)-maxRetiredKeys:]...)
+	}
+
+	l.retired[username] = retired
+}
+
+// isRetired reports whether the key of the user has been replaced. The caller must hold mu.
+func (l *KeyLocks) isRetired(user User) bool {
+	fingerprint := keyFingerprint(user.key)
+
+	for _, retired := range l.retired[user.Username] {
+		if retired == fingerprint {
+			return true
+		}
+	}
+
+	return false
+}
+
+func keyFingerprint(key []byte) [sha256.Size]byte {
+	return sha256.Sum256(key)
+}
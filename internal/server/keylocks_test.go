@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestKeyLocks(t *testing.T) {
+	t.Parallel()
+
+	locks := NewKeyLocks()
+
+	user := User{Username: "user", key: []byte("key")}
+
+	write, err := locks.startWrite(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A deletion of the user changes no key, but the write started before it must not be published.
+	locks.lockChange(user.Username)()
+
+	if _, err = write.lock(); !errors.Is(err, ErrKeyChanged) {
+		t.Fatalf("got %v publishing a write started before a change, want %v", err, ErrKeyChanged)
+	}
+
+	if write, err = locks.startWrite(user); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := write.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+func TestKeyLocks_Retire(t *testing.T) {
+	t.Parallel()
+
+	locks := NewKeyLocks()
+
+	for i := 0; i <= maxRetiredKeys; i++ {
+		locks.retire("user", []byte(fmt.Sprint("key", i)))
+	}
+
+	if got := len(locks.retired["user"]); got != maxRetiredKeys {
+		t.Fatalf("got %d retired keys, want %d", got, maxRetiredKeys)
+	}
+
+	for _, tc := range []struct {
+		user User
+		want error
+	}{
+		{user: User{Username: "user", key: []byte(fmt.Sprint("key", maxRetiredKeys))}, want: ErrKeyChanged},
+		{user: User{Username: "other", key: []byte(fmt.Sprint("key", maxRetiredKeys))}, want: nil},
+		{user: User{Username: "user", key: []byte("current")}, want: nil},
+	} {
+		if _, err := locks.startWrite(tc.user); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.user.key, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+)
+
+// Every user has an X25519 key pair other users wrap the data keys of shared files to.
+// The public key is stored in the user record as is, the private key is encrypted with the user key,
+// so it is re-encrypted along with the record when the passphrase changes.
+const keySize = 32
+
+var errNoKeyPair = errors.New("user has no key pair")
+
+func generateKeyPair() (publicKey, privateKey *[keySize]byte, err error) {
+	return box.GenerateKey(rand.Reader)
+}
+
+func publicKeyOf(privateKey *[keySize]byte) (*[keySize]byte, error) {
+	publicKey, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*[keySize]byte)(publicKey), nil
+}
+
+// setKeyPair stores the key pair in the record, encrypting the private key with the user key.
+func (r *userRecord) setKeyPair(publicKey, privateKey *[keySize]byte, userKey []byte) error {
+	encryptedPrivateKey, err := aes.Encrypt(privateKey[:], userKey)
+	if err != nil {
+		return err
+	}
+
+	r.PublicKey = publicKey[:]
+	r.PrivateKey = encryptedPrivateKey
+
+	return nil
+}
+
+// keyPair returns the key pair stored in the record.
+// Records created before key pairs were introduced get a new one.
+func (r userRecord) keyPair(userKey []byte) (publicKey, privateKey *[keySize]byte, err error) {
+	if r.PublicKey == nil {
+		return generateKeyPair()
+	}
+
+	if publicKey, err = r.publicKey(); err != nil {
+		return nil, nil, err
+	}
+
+	if privateKey, err = r.privateKey(userKey); err != nil {
+		return nil, nil, err
+	}
+
+	return publicKey, privateKey, nil
+}
+
+func (r userRecord) publicKey() (*[keySize]byte, error) {
+	if r.PublicKey == nil {
+		return nil, errNoKeyPair
+	}
+
+	if len(r.PublicKey) != keySize {
+		return nil, errors.New("invalid public key")
+	}
+
+	return (*[keySize]byte)(r.PublicKey), nil
+}
+
+func (r userRecord) privateKey(userKey []byte) (*[keySize]byte, error) {
+	if r.PrivateKey == nil {
+		return nil, errNoKeyPair
+	}
+
+	privateKey, err := aes.Decrypt(r.PrivateKey, userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(privateKey) != keySize {
+		return nil, errors.New("invalid private key")
+	}
+
+	return (*[keySize]byte)(privateKey), nil
+}
+
+// PublicKey returns the public key of the user.
+func (a Authenticator) PublicKey(username string) (*[keySize]byte, error) {
+	if username == "" {
+		return nil, ErrNotEnoughParams
+	}
+
+	if !validUsername(username) {
+		return nil, ErrInvalidUsername
+	}
+
+	record, err := readUserRecord(filepath.Join(a.dataDir, username, "."+username), username)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.publicKey()
+}
+
+// PrivateKey decrypts the private key of the user with the user key.
+func (a Authenticator) PrivateKey(user User) (*[keySize]byte, error) {
+	record, err := readUserRecord(filepath.Join(user.DataDir, "."+user.Username), user.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	return record.privateKey(user.key)
+}
+
+// addKeyPair generates a key pair for a user whose record was created before key pairs were introduced.
+func (a Authenticator) addKeyPair(recordPath string, record userRecord, key []byte) error {
+	publicKey, privateKey, err := generateKeyPair()
+	if err != nil {
+		return err
+	}
+
+	if err = record.setKeyPair(publicKey, privateKey, key); err != nil {
+		return err
+	}
+
+	record.Version = recordVersion
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(recordPath, data, 0400)
+}
@@ -0,0 +1,419 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/rand"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+// A share link lets anyone holding its token download a file without an account.
+// The token is random and never stored: the id the link is stored under and the key the data key of the file
+// is wrapped with are both derived from it, so the stored link neither reveals the token nor the owner's key.
+// The key of a link protected by a passphrase is also derived from the passphrase,
+// and the link is removed once the passphrase has been wrong maxLinkPassphraseAttempts times.
+// Links are stored under ".links/<id>", outside the keys of every user.
+// The owner keeps "<owner>/.links/<file id>/<id>" for every link, so the links of a file are removed
+// when the file or its owner is deleted.
+const (
+	linksDirname = ".links"
+	linkIDInfo   = "beaver share link id"
+	linkKeyInfo  = "beaver share link key"
+
+	linkTokenSize = 32
+	linkIDSize    = 16
+
+	maxLinkPassphraseAttempts = 10
+
+	// The passphrases of links are checked by anyone holding a token, so the key derivations they cost
+	// are limited for all the links together: linkKDFConcurrency at once and linkKDFBurst in a row,
+	// then one every linkKDFInterval.
+	linkKDFConcurrency = 2
+	linkKDFBurst       = 20
+	linkKDFInterval    = 100 * time.Millisecond
+)
+
+var (
+	// ErrInvalidShareLink is returned when creating a share link with a lifetime or a download limit out of range.
+	ErrInvalidShareLink = errors.New("invalid share link")
+	// ErrInvalidLinkPassphrase is returned when the passphrase of a protected share link is missing or wrong.
+	ErrInvalidLinkPassphrase = errors.New("invalid share link passphrase")
+)
+
+// ShareLinkPolicy limits the lifetime of share links and defines the cost of deriving keys from their passphrases.
+// A zero MaxTTL doesn't limit the lifetime.
+type ShareLinkPolicy struct {
+	MaxTTL    time.Duration
+	KDFParams Argon2Params
+}
+
+// ShareLinkOptions describe a new share link. An empty Passphrase leaves the link unprotected,
+// a zero MaxDownloads doesn't limit the number of downloads.
+type ShareLinkOptions struct {
+	TTL          time.Duration
+	Passphrase   string
+	MaxDownloads int64
+}
+
+// ShareLink is the token of a share link, it's only known to the owner of the file and to whom they pass it.
+type ShareLink struct {
+	Token   string
+	Expires time.Time
+}
+
+type shareLinkRecord struct {
+	Owner        string     `json:"owner"`
+	Path         string     `json:"path"`
+	Key          []byte     `json:"key"`
+	KDF          *kdfParams `json:"kdf,omitempty"`
+	Expires      time.Time  `json:"expires"`
+	MaxDownloads int64      `json:"max_downloads,omitempty"`
+	Downloads    int64      `json:"downloads"`
+	// FailedAttempts counts the downloads refused for a wrong passphrase.
+	FailedAttempts int64 `json:"failed_attempts,omitempty"`
+}
+
+// live reports whether the link can still be used to download the file.
+func (r shareLinkRecord) live(now time.Time) bool {
+	return now.Before(r.Expires) && (r.MaxDownloads == 0 || r.Downloads < r.MaxDownloads)
+}
+
+// CreateShareLink issues a link to download the current content of the file.
+// Replacing, renaming or deleting the file ends the link, as does its expiration or its last download.
+func (s Storage) CreateShareLink(ctx context.Context, user User, name string, options ShareLinkOptions) (ShareLink, error) {
+	ctx, span := trace.Start(ctx, "storage.CreateShareLink")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	if options.TTL <= 0 {
+		return ShareLink{}, fmt.Errorf("%w: lifetime %v is not positive", ErrInvalidShareLink, options.TTL)
+	}
+
+	if s.linkPolicy.MaxTTL > 0 && options.TTL > s.linkPolicy.MaxTTL {
+		return ShareLink{}, fmt.Errorf("%w: lifetime %v exceeds %v", ErrInvalidShareLink, options.TTL, s.linkPolicy.MaxTTL)
+	}
+
+	if options.MaxDownloads < 0 {
+		return ShareLink{}, fmt.Errorf("%w: download limit %d", ErrInvalidShareLink, options.MaxDownloads)
+	}
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	dataKey, err := s.dataKey(key, name, user.Key())
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	token, err := rand.Bytes(linkTokenSize)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	id, linkKey, err := linkKeys(token)
+	if err != nil {
+		return ShareLink{}, err
+	}
+
+	record := shareLinkRecord{
+		Owner:        user.Username,
+		Path:         strings.TrimPrefix(key, user.Username+"/"),
+		Expires:      time.Now().UTC().Add(options.TTL),
+		MaxDownloads: options.MaxDownloads,
+	}
+
+	if options.Passphrase != "" {
+		kdf, err := newKDFParams(s.linkPolicy.KDFParams)
+		if err != nil {
+			return ShareLink{}, err
+		}
+
+		passphraseKey, err := kdf.deriveKey(ctx, options.Passphrase)
+		if err != nil {
+			return ShareLink{}, err
+		}
+
+		if linkKey, err = passphraseLinkKey(linkKey, passphraseKey); err != nil {
+			return ShareLink{}, err
+		}
+
+		record.KDF = &kdf
+	}
+
+	if record.Key, err = aes.Encrypt(dataKey, linkKey); err != nil {
+		return ShareLink{}, err
+	}
+
+	// The link is recorded with its owner first, so it is never left without its entry on the owner side.
+	if err = putBytes(s.store, ownedLinkKey(key, id), nil); err != nil {
+		return ShareLink{}, err
+	}
+
+	if err = writeShareLink(s.store, id, record); err != nil {
+		return ShareLink{}, err
+	}
+
+	return ShareLink{Token: base64.RawURLEncoding.EncodeToString(token), Expires: record.Expires}, nil
+}
+
+// DownloadShared writes the plaintext of the byte range of the file of the share link to dst.
+// Every download started counts towards the download limit of the link, whether it completes or not.
+func (s Storage) DownloadShared(ctx context.Context, token, passphrase string, byteRange ByteRange, dst io.Writer) (DownloadResult, error) {
+	ctx, span := trace.Start(ctx, "storage.DownloadShared")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	if err := checkRange(byteRange); err != nil {
+		return DownloadResult{}, err
+	}
+
+	record, dataKey, err := s.claimShareLink(ctx, token, passphrase)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	result, err := s.downloadWithKey(ctx, record.Owner+"/"+record.Path, record.Path, dataKey, byteRange, dst)
+
+	result.Owner, result.Path = record.Owner, record.Path
+
+	return result, err
+}
+
+// claimShareLink unwraps the data key of the link and counts a download.
+// A wrong passphrase counts as a failed attempt, and the link is removed after too many of them.
+func (s Storage) claimShareLink(ctx context.Context, token, passphrase string) (shareLinkRecord, []byte, error) {
+	errNotFound := fmt.Errorf("%w: share link not found", fs.ErrNotExist)
+
+	rawToken, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(rawToken) != linkTokenSize {
+		return shareLinkRecord{}, nil, errNotFound
+	}
+
+	id, linkKey, err := linkKeys(rawToken)
+	if err != nil {
+		return shareLinkRecord{}, nil, err
+	}
+
+	defer s.locks.lock(shareLinkKey(id))()
+
+	record, err := readShareLink(s.store, id)
+	if errors.Is(err, fs.ErrNotExist) {
+		return shareLinkRecord{}, nil, errNotFound
+	}
+	if err != nil {
+		return shareLinkRecord{}, nil, err
+	}
+
+	if !record.live(time.Now()) {
+		if err = deleteShareLink(s.store, id, record); err != nil {
+			return shareLinkRecord{}, nil, err
+		}
+		return shareLinkRecord{}, nil, errNotFound
+	}
+
+	if record.KDF != nil {
+		if passphrase == "" {
+			return shareLinkRecord{}, nil, ErrInvalidLinkPassphrase
+		}
+
+		// The derivation is limited before it runs, as anyone holding the token can request it.
+		passphraseKey, err := s.linkKDF.deriveKey(ctx, *record.KDF, passphrase)
+		if err != nil {
+			return shareLinkRecord{}, nil, err
+		}
+
+		if linkKey, err = passphraseLinkKey(linkKey, passphraseKey); err != nil {
+			return shareLinkRecord{}, nil, err
+		}
+	}
+
+	dataKey, err := aes.Decrypt(record.Key, linkKey)
+	if err != nil {
+		if record.KDF == nil {
+			return shareLinkRecord{}, nil, ErrInvalidLinkPassphrase
+		}
+
+		if record.FailedAttempts++; record.FailedAttempts >= maxLinkPassphraseAttempts {
+			err = deleteShareLink(s.store, id, record)
+		} else {
+			err = writeShareLink(s.store, id, record)
+		}
+		if err != nil {
+			return shareLinkRecord{}, nil, err
+		}
+
+		return shareLinkRecord{}, nil, ErrInvalidLinkPassphrase
+	}
+
+	record.Downloads++
+
+	if err = writeShareLink(s.store, id, record); err != nil {
+		return shareLinkRecord{}, nil, err
+	}
+
+	return record, dataKey, nil
+}
+
+// CollectShareLinks removes the share links that expired, ran out of downloads or whose file no longer exists.
+// It returns the number of removed links.
+func CollectShareLinks(store blob.Store) (removed int, err error) {
+	infos, err := store.List(linksDirname + "/")
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	for _, info := range infos {
+		id := strings.TrimPrefix(info.Key, linksDirname+"/")
+
+		record, err := readShareLink(store, id)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return removed, err
+		}
+
+		if record.live(now) {
+			_, err = store.Stat(record.Owner + "/" + record.Path)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return removed, err
+			}
+		}
+
+		if err = deleteShareLink(store, id, record); err != nil {
+			return removed, err
+		}
+
+		removed++
+	}
+
+	return removed, nil
+}
+
+// linkKeys derives the id of the link and the key its data key is wrapped with from the token.
+func linkKeys(token []byte) (id string, key []byte, err error) {
+	rawID := make([]byte, linkIDSize)
+
+	if _, err = io.ReadFull(hkdf.New(sha256.New, token, nil, []byte(linkIDInfo)), rawID); err != nil {
+		return "", nil, err
+	}
+
+	key = make([]byte, aes.KeyLength)
+
+	if _, err = io.ReadFull(hkdf.New(sha256.New, token, nil, []byte(linkKeyInfo)), key); err != nil {
+		return "", nil, err
+	}
+
+	return hex.EncodeToString(rawID), key, nil
+}
+
+// passphraseLinkKey combines the key derived from the token with the key derived from the passphrase.
+func passphraseLinkKey(linkKey, passphraseKey []byte) ([]byte, error) {
+	key := make([]byte, aes.KeyLength)
+
+	if _, err := io.ReadFull(hkdf.New(sha256.New, passphraseKey, linkKey, []byte(linkKeyInfo)), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// removeLinks removes the share links of the file.
+func removeLinks(store blob.Store, fileKey string) error {
+	owner, _, _ := strings.Cut(fileKey, "/")
+
+	return removeOwnedLinks(store, owner+"/"+linksDirname+"/"+shareID(fileKey)+"/")
+}
+
+// removeUserLinks removes the share links of all the files of the owner.
+func removeUserLinks(store blob.Store, owner string) error {
+	return removeOwnedLinks(store, owner+"/"+linksDirname+"/")
+}
+
+// removeOwnedLinks removes the links recorded under the prefix of the links of an owner along with their entries.
+func removeOwnedLinks(store blob.Store, prefix string) error {
+	infos, err := store.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		if err = store.Delete(shareLinkKey(path.Base(info.Key))); err != nil {
+			return err
+		}
+
+		if err = store.Delete(info.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteShareLink removes the link and its entry on the owner side.
+func deleteShareLink(store blob.Store, id string, record shareLinkRecord) error {
+	if err := store.Delete(shareLinkKey(id)); err != nil {
+		return err
+	}
+
+	return store.Delete(ownedLinkKey(record.Owner+"/"+record.Path, id))
+}
+
+func shareLinkKey(id string) string {
+	return linksDirname + "/" + id
+}
+
+// ownedLinkKey returns the key recording on the owner side that the link was issued for the file.
+func ownedLinkKey(fileKey, id string) string {
+	owner, _, _ := strings.Cut(fileKey, "/")
+
+	return owner + "/" + linksDirname + "/" + shareID(fileKey) + "/" + id
+}
+
+func readShareLink(store blob.Store, id string) (shareLinkRecord, error) {
+	data, err := readBlob(store, shareLinkKey(id))
+	if err != nil {
+		return shareLinkRecord{}, err
+	}
+
+	var record shareLinkRecord
+
+	if err = json.Unmarshal(data, &record); err != nil {
+		return shareLinkRecord{}, err
+	}
+
+	return record, nil
+}
+
+func writeShareLink(store blob.Store, id string, record shareLinkRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return putBytes(store, shareLinkKey(id), data)
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorage_CreateShareLink(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{MaxTTL: time.Hour, KDFParams: testKDFParams})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+
+	content := strings.Repeat("0123456789", 20000)
+
+	metadata := FileMetadata{Filename: "a/" + fileName, Compression: CompressionGzip}
+
+	if _, err := storage.Upload(context.Background(), owner, metadata, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		options ShareLinkOptions
+		wantErr error
+	}{
+		{name: "zero lifetime", path: metadata.Filename, options: ShareLinkOptions{}, wantErr: ErrInvalidShareLink},
+		{name: "lifetime over limit", path: metadata.Filename, options: ShareLinkOptions{TTL: 2 * time.Hour}, wantErr: ErrInvalidShareLink},
+		{name: "negative download limit", path: metadata.Filename, options: ShareLinkOptions{TTL: time.Minute, MaxDownloads: -1}, wantErr: ErrInvalidShareLink},
+		{name: "directory", path: "a", options: ShareLinkOptions{TTL: time.Minute}, wantErr: ErrInvalidPath},
+		{name: "missing file", path: "missing", options: ShareLinkOptions{TTL: time.Minute}, wantErr: os.ErrNotExist},
+	}
+
+	for _, tc := range tests {
+		if _, err := storage.CreateShareLink(context.Background(), owner, tc.path, tc.options); !errors.Is(err, tc.wantErr) {
+			t.Fatalf("%s: got %v, want %v", tc.name, err, tc.wantErr)
+		}
+	}
+
+	link, err := storage.CreateShareLink(context.Background(), owner, metadata.Filename, ShareLinkOptions{TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if until := time.Until(link.Expires); until <= 0 || until > time.Minute {
+		t.Fatalf("got expiration %v, want within a minute", link.Expires)
+	}
+
+	for _, byteRange := range []ByteRange{{}, {Offset: 70000, Length: 10}} {
+		dst := &strings.Builder{}
+
+		result, err := storage.DownloadShared(context.Background(), link.Token, "", byteRange, dst)
+		if err != nil {
+			t.Fatalf("%+v: %v", byteRange, err)
+		}
+
+		end := int64(len(content))
+		if byteRange.Length > 0 {
+			end = byteRange.Offset + byteRange.Length
+		}
+
+		if got, want := dst.String(), content[byteRange.Offset:end]; got != want {
+			t.Fatalf("%+v: got %d bytes, want %d", byteRange, len(got), len(want))
+		}
+
+		if digest := sha256.Sum256([]byte(content)); !bytes.Equal(result.Digest, digest[:]) {
+			t.Fatalf("%+v: got digest %x, want %x", byteRange, result.Digest, digest)
+		}
+	}
+
+	for _, token := range []string{"", "invalid", base64.RawURLEncoding.EncodeToString(make([]byte, linkTokenSize))} {
+		if _, err = storage.DownloadShared(context.Background(), token, "", ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("got %v for token %q, want %v", err, token, os.ErrNotExist)
+		}
+	}
+
+	// A replaced file is no longer shared.
+	if err = storage.Delete(context.Background(), owner, metadata.Filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Upload(context.Background(), owner, metadata, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.DownloadShared(context.Background(), link.Token, "", ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v after the file was replaced, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestStorage_DownloadShared_Limits(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{KDFParams: testKDFParams})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+
+	if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := storage.CreateShareLink(context.Background(), owner, fileName, ShareLinkOptions{TTL: time.Hour, Passphrase: "secret", MaxDownloads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, passphrase := range []string{"", "wrong"} {
+		if _, err = storage.DownloadShared(context.Background(), link.Token, passphrase, ByteRange{}, &strings.Builder{}); !errors.Is(err, ErrInvalidLinkPassphrase) {
+			t.Fatalf("got %v for passphrase %q, want %v", err, passphrase, ErrInvalidLinkPassphrase)
+		}
+	}
+
+	// Failed attempts don't count towards the download limit.
+	for i := 0; i < 2; i++ {
+		dst := &strings.Builder{}
+
+		if _, err = storage.DownloadShared(context.Background(), link.Token, "secret", ByteRange{}, dst); err != nil {
+			t.Fatalf("download %d: %v", i+1, err)
+		}
+
+		if dst.String() != fileContent {
+			t.Fatalf("download %d: got %q, want %q", i+1, dst.String(), fileContent)
+		}
+	}
+
+	if _, err = storage.DownloadShared(context.Background(), link.Token, "secret", ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v after the last download, want %v", err, os.ErrNotExist)
+	}
+
+	// Too many wrong passphrases remove the link.
+	link, err = storage.CreateShareLink(context.Background(), owner, fileName, ShareLinkOptions{TTL: time.Hour, Passphrase: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < maxLinkPassphraseAttempts; i++ {
+		if _, err = storage.DownloadShared(context.Background(), link.Token, "wrong", ByteRange{}, &strings.Builder{}); !errors.Is(err, ErrInvalidLinkPassphrase) {
+			t.Fatalf("attempt %d: got %v, want %v", i+1, err, ErrInvalidLinkPassphrase)
+		}
+	}
+
+	if _, err = storage.DownloadShared(context.Background(), link.Token, "secret", ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v after too many wrong passphrases, want %v", err, os.ErrNotExist)
+	}
+
+	// Expire a link by rewriting its record.
+	link, err = storage.CreateShareLink(context.Background(), owner, fileName, ShareLinkOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(link.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := linkKeys(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := readShareLink(storage.store, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record.Expires = time.Now().Add(-time.Second)
+
+	if err = writeShareLink(storage.store, id, record); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.DownloadShared(context.Background(), link.Token, "", ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v after the link expired, want %v", err, os.ErrNotExist)
+	}
+
+	if _, err = readShareLink(storage.store, id); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want the expired link removed", err)
+	}
+}
+
+func TestStorage_ShareLink_Delete(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+
+	links := make(map[string]ShareLink)
+
+	for _, name := range []string{fileName, file2Name} {
+		if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: name}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := storage.CreateShareLink(context.Background(), owner, name, ShareLinkOptions{TTL: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		links[name] = link
+	}
+
+	if err := storage.Delete(context.Background(), owner, fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists := shareLinkExists(t, storage, links[fileName]); exists {
+		t.Fatal("got the link of a deleted file, want it removed")
+	}
+
+	if exists := shareLinkExists(t, storage, links[file2Name]); !exists {
+		t.Fatal("got the link of a kept file removed, want it to exist")
+	}
+
+	if err := authenticator.DeleteUser(context.Background(), "owner", "", masterKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists := shareLinkExists(t, storage, links[file2Name]); exists {
+		t.Fatal("got a link after its owner was deleted, want it removed")
+	}
+}
+
+func TestStorage_ShareLink_RenameCopy(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+
+	links := make(map[string]ShareLink)
+
+	for _, name := range []string{fileName, file2Name, "a/" + fileName} {
+		if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: name}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := storage.CreateShareLink(context.Background(), owner, name, ShareLinkOptions{TTL: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		links[name] = link
+	}
+
+	if err := storage.Copy(context.Background(), owner, fileName, file2Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !shareLinkExists(t, storage, links[fileName]) || shareLinkExists(t, storage, links[file2Name]) {
+		t.Fatal("got the link of a file replaced by a copy kept, want it removed")
+	}
+
+	if err := storage.Rename(context.Background(), owner, fileName, file2Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if shareLinkExists(t, storage, links[fileName]) {
+		t.Fatal("got the link of a renamed file kept, want it removed")
+	}
+
+	if err := storage.Rename(context.Background(), owner, "a", "b", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if shareLinkExists(t, storage, links["a/"+fileName]) {
+		t.Fatal("got the link of a file in a renamed directory kept, want it removed")
+	}
+}
+
+func shareLinkExists(t *testing.T, storage *Storage, link ShareLink) bool {
+	t.Helper()
+
+	token, err := base64.RawURLEncoding.DecodeString(link.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _, err := linkKeys(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readShareLink(storage.store, id)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return true
+}
+
+func TestCollectShareLinks(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+
+	for _, name := range []string{"kept", "deleted"} {
+		if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: name}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kept, err := storage.CreateShareLink(context.Background(), owner, "kept", ShareLinkOptions{TTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.CreateShareLink(context.Background(), owner, "deleted", ShareLinkOptions{TTL: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.CreateShareLink(context.Background(), owner, "kept", ShareLinkOptions{TTL: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The links of a file lost without them being removed, as after a crash, are left for the collector.
+	if err = authenticator.store.Delete("owner/deleted"); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := CollectShareLinks(storage.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 2 {
+		t.Fatalf("got %d removed links, want 2", removed)
+	}
+
+	if _, err = storage.DownloadShared(context.Background(), kept.Token, "", ByteRange{}, &strings.Builder{}); err != nil {
+		t.Fatalf("got %v for the live link, want nil", err)
+	}
+}
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+// maxPageSize limits the number of entries returned by List at once.
+const maxPageSize = 1000
+
+// ErrInvalidListOptions is returned when the pattern, the sort order or the page token of a listing is invalid.
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// errStopWalk stops a walk early without failing it.
+var errStopWalk = errors.New("stop walk")
+
+type SortField int
+
+const (
+	SortByName SortField = iota
+	SortBySize
+	SortByModified
+)
+
+// ListOptions control which entries of a directory are listed and in which order.
+// Pattern is matched against the paths relative to the listed directory:
+// a pattern without glob metacharacters is treated as a prefix, otherwise it is matched with path.Match.
+// Directories that don't match the pattern are still descended into when listing recursively.
+// PageToken is the NextPageToken of the previous page listed with the same options.
+type ListOptions struct {
+	Recursive  bool
+	Pattern    string
+	SortBy     SortField
+	Descending bool
+	PageSize   int
+	PageToken  string
+}
+
+// ListPage is a page of entries. NextPageToken is empty on the last page.
+type ListPage struct {
+	Entries       []FileInfo
+	NextPageToken string
+}
+
+// pageToken holds the sort key of the last entry of a page.
+// Listing continues after the entry even if it has been removed in the meantime.
+type pageToken struct {
+	SortBy     SortField `json:"sort_by"`
+	Descending bool      `json:"descending"`
+	Path       string    `json:"path"`
+	Size       int64     `json:"size,omitempty"`
+	Modified   time.Time `json:"modified"`
+}
+
+// List returns a page of the entries in the directory, their paths are relative to it.
+// PageSize defaults to and is capped at maxPageSize.
+func (s Storage) List(ctx context.Context, user User, dir string, options ListOptions) (ListPage, error) {
+	ctx, span := trace.Start(ctx, "storage.List")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	pageSize := options.PageSize
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	var page ListPage
+
+	err := s.walk(user, dir, options, func(info FileInfo) error {
+		if len(page.Entries) == pageSize {
+			page.NextPageToken = encodePageToken(options, page.Entries[pageSize-1])
+			return errStopWalk
+		}
+
+		page.Entries = append(page.Entries, info)
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return ListPage{}, err
+	}
+
+	return page, nil
+}
+
+// Walk calls fn for every entry in the directory in the requested order, starting after the page token.
+// PageSize is ignored. The keys under the directory are listed first, so the order doesn't affect the cost.
+func (s Storage) Walk(ctx context.Context, user User, dir string, options ListOptions, fn func(FileInfo) error) error {
+	ctx, span := trace.Start(ctx, "storage.Walk")
+	defer span.End()
+
+	return s.traced(ctx).walk(user, dir, options, fn)
+}
+
+func (s Storage) walk(user User, dir string, options ListOptions, fn func(FileInfo) error) error {
+	root, err := s.resolve(user, dir)
+	if err != nil {
+		return err
+	}
+
+	if options.SortBy < SortByName || options.SortBy > SortByModified {
+		return fmt.Errorf("%w: unknown sort field %d", ErrInvalidListOptions, options.SortBy)
+	}
+
+	if _, err = path.Match(options.Pattern, ""); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidListOptions, err)
+	}
+
+	after, err := decodePageToken(options)
+	if err != nil {
+		return err
+	}
+
+	if root != user.Username {
+		_, isDir, err := s.lookup(root)
+		if err != nil {
+			return err
+		}
+
+		if !isDir {
+			return fmt.Errorf("%w: %q is not a directory", ErrInvalidPath, dir)
+		}
+	}
+
+	if options.SortBy == SortByName && !options.Descending {
+		var afterPath string
+		if after != nil {
+			afterPath = after.Path
+		}
+
+		return s.walkDir(root, options, afterPath, user.Key(), fn)
+	}
+
+	var entries []FileInfo
+
+	err = s.walkDir(root, options, "", user.Key(), func(info FileInfo) error {
+		entries = append(entries, info)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	less := entryLess(options.SortBy, options.Descending)
+
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+
+	for _, entry := range entries {
+		if after != nil && !less(*after, entry) {
+			continue
+		}
+
+		if err = fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkDir calls fn for the matching entries in the directory in ascending order of their paths,
+// skipping the entries up to and including afterPath.
+// Directories are derived from the keys of the blobs under them, elements starting with a dot are internal.
+func (s Storage) walkDir(root string, options ListOptions, afterPath string, userKey []byte, fn func(FileInfo) error) error {
+	infos, err := s.store.List(root + "/")
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		path   string
+		info   blob.Info
+		dir    bool
+		marked bool
+	}
+
+	var (
+		entries []entry
+		dirs    = make(map[string]int)
+	)
+
+	for _, info := range infos {
+		elements := strings.Split(strings.TrimPrefix(info.Key, root+"/"), "/")
+
+		for i, element := range elements {
+			if strings.HasPrefix(element, ".") || (i > 0 && !options.Recursive) {
+				break
+			}
+
+			rel := strings.Join(elements[:i+1], "/")
+
+			if i == len(elements)-1 {
+				entries = append(entries, entry{path: rel, info: info})
+				break
+			}
+
+			// A directory is dated like in lookup, by its marker or by the newest blob under it.
+			marker := i == len(elements)-2 && elements[i+1] == dirMarker
+
+			index, ok := dirs[rel]
+			if !ok {
+				dirs[rel] = len(entries)
+				entries = append(entries, entry{path: rel, info: blob.Info{Key: root + "/" + rel}, dir: true})
+				index = len(entries) - 1
+			}
+
+			if dir := &entries[index]; marker || !dir.marked && dir.info.Modified.Before(info.Modified) {
+				dir.info.Modified = info.Modified
+				dir.marked = dir.marked || marker
+			}
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return comparePaths(entries[i].path, entries[j].path) < 0
+	})
+
+	for _, entry := range entries {
+		if afterPath != "" && comparePaths(entry.path, afterPath) <= 0 {
+			continue
+		}
+
+		if !matchPattern(options.Pattern, entry.path) {
+			continue
+		}
+
+		fileInfo, err := statFile(s.store, entry.info, entry.path, entry.dir, userKey)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since the keys were listed.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err = fn(fileInfo); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchPattern reports whether the slash-separated path matches the pattern described in ListOptions.
+func matchPattern(pattern, name string) bool {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return strings.HasPrefix(name, pattern)
+	}
+
+	matched, _ := path.Match(pattern, name)
+
+	return matched
+}
+
+// comparePaths compares slash-separated paths element by element,
+// so the entries of a directory sort right after the directory itself, as they are walked.
+func comparePaths(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// entryLess returns the ordering of entries. Entries with equal sort keys are ordered by path.
+func entryLess(sortBy SortField, descending bool) func(a, b FileInfo) bool {
+	return func(a, b FileInfo) bool {
+		if descending {
+			a, b = b, a
+		}
+
+		switch sortBy {
+		case SortBySize:
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case SortByModified:
+			if !a.Modified.Equal(b.Modified) {
+				return a.Modified.Before(b.Modified)
+			}
+		}
+
+		return comparePaths(a.Path, b.Path) < 0
+	}
+}
+
+func encodePageToken(options ListOptions, last FileInfo) string {
+	data, _ := json.Marshal(pageToken{
+		SortBy:     options.SortBy,
+		Descending: options.Descending,
+		Path:       last.Path,
+		Size:       last.Size,
+		Modified:   last.Modified,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken returns the sort key of the entry to continue after, or nil if listing starts from the beginning.
+func decodePageToken(options ListOptions) (*FileInfo, error) {
+	if options.PageToken == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(options.PageToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed page token", ErrInvalidListOptions)
+	}
+
+	var token pageToken
+
+	if err = json.Unmarshal(data, &token); err != nil || token.Path == "" {
+		return nil, fmt.Errorf("%w: malformed page token", ErrInvalidListOptions)
+	}
+
+	if token.SortBy != options.SortBy || token.Descending != options.Descending {
+		return nil, fmt.Errorf("%w: page token was issued for another sort order", ErrInvalidListOptions)
+	}
+
+	return &FileInfo{Path: token.Path, Size: token.Size, Modified: token.Modified}, nil
+}
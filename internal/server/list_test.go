@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStorage_List_Pages(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	newListTestFiles(t, storage, user)
+
+	tests := []struct {
+		options ListOptions
+		want    []string
+	}{
+		{
+			options: ListOptions{Recursive: true},
+			want:    []string{"a/", "a/b.txt", "a/x/", "a/x/y.txt", "a-c.txt", "a.txt", "e/", "z.txt"},
+		},
+		{
+			options: ListOptions{Recursive: true, Descending: true},
+			want:    []string{"z.txt", "e/", "a.txt", "a-c.txt", "a/x/y.txt", "a/x/", "a/b.txt", "a/"},
+		},
+		{
+			options: ListOptions{Recursive: true, SortBy: SortBySize},
+			want:    []string{"a/", "a/x/", "e/", "a-c.txt", "z.txt", "a/b.txt", "a.txt", "a/x/y.txt"},
+		},
+		{
+			options: ListOptions{Recursive: true, SortBy: SortBySize, Descending: true},
+			want:    []string{"a/x/y.txt", "a.txt", "a/b.txt", "z.txt", "a-c.txt", "e/", "a/x/", "a/"},
+		},
+		{
+			options: ListOptions{SortBy: SortByModified},
+			want:    nil,
+		},
+	}
+
+	for _, tc := range tests {
+		all, err := storage.List(context.Background(), user, "", tc.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if all.NextPageToken != "" {
+			t.Fatalf("%+v: got next page token for a complete listing", tc.options)
+		}
+
+		if tc.want == nil {
+			tc.want = paths(all.Entries)
+		}
+
+		if got := paths(all.Entries); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("%+v: got %q, want %q", tc.options, got, tc.want)
+		}
+
+		for _, pageSize := range []int{1, 3} {
+			options := tc.options
+			options.PageSize = pageSize
+
+			var got []string
+
+			for {
+				page, err := storage.List(context.Background(), user, "", options)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(page.Entries) > pageSize {
+					t.Fatalf("got %d entries, want at most %d", len(page.Entries), pageSize)
+				}
+
+				got = append(got, paths(page.Entries)...)
+
+				if page.NextPageToken == "" {
+					break
+				}
+
+				options.PageToken = page.NextPageToken
+			}
+
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Fatalf("%+v: got %q, want %q", options, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestStorage_List_Pattern(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	newListTestFiles(t, storage, user)
+
+	tests := []struct {
+		pattern   string
+		recursive bool
+		want      []string
+	}{
+		{pattern: "a/", recursive: true, want: []string{"a/b.txt", "a/x/", "a/x/y.txt"}},
+		{pattern: "a", recursive: false, want: []string{"a/", "a-c.txt", "a.txt"}},
+		{pattern: "*.txt", recursive: true, want: []string{"a-c.txt", "a.txt", "z.txt"}},
+		{pattern: "a/*", recursive: true, want: []string{"a/b.txt", "a/x/"}},
+		{pattern: "*/*/*", recursive: true, want: []string{"a/x/y.txt"}},
+	}
+
+	for _, tc := range tests {
+		page, err := storage.List(context.Background(), user, "", ListOptions{Recursive: tc.recursive, Pattern: tc.pattern})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := paths(page.Entries); strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("pattern %q: got %q, want %q", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestStorage_List_InvalidOptions(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	newListTestFiles(t, storage, user)
+
+	page, err := storage.List(context.Background(), user, "", ListOptions{PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []ListOptions{
+		{Pattern: "["},
+		{SortBy: SortByModified + 1},
+		{PageToken: "not a token"},
+		{PageToken: page.NextPageToken, SortBy: SortBySize},
+		{PageToken: page.NextPageToken, Descending: true},
+	}
+
+	for _, options := range tests {
+		if _, err = storage.List(context.Background(), user, "", options); !errors.Is(err, ErrInvalidListOptions) {
+			t.Fatalf("%+v: got %v, want %v", options, err, ErrInvalidListOptions)
+		}
+	}
+}
+
+func TestComparePaths(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "a", b: "a", want: 0},
+		{a: "a", b: "a/b", want: -1},
+		{a: "a/b", b: "a-c", want: -1},
+		{a: "a/z", b: "a.txt", want: -1},
+		{a: "b", b: "a/z", want: 1},
+	}
+
+	for _, tc := range tests {
+		if got := comparePaths(tc.a, tc.b); got != tc.want {
+			t.Fatalf("comparePaths(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+// newListTestUser uploads files whose sizes differ from their order by name.
+func newListTestFiles(t *testing.T, storage *Storage, user User) {
+	t.Helper()
+
+	files := map[string]string{
+		"a/b.txt":   "bbb",
+		"a-c.txt":   "c",
+		"a/x/y.txt": "yyyyy",
+		"z.txt":     "zz",
+		"a.txt":     "aaaa",
+	}
+
+	for name, content := range files {
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Mkdir(context.Background(), user, "e"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+// RotateMasterKey verifies the master key stored in the data dir, replaces it with a new one and returns the new key.
+// The key file is replaced atomically, so a crash leaves either the current or the new key in place.
+func RotateMasterKey(dataDir, masterKey string) (string, error) {
+	if err := verifyMasterKey(dataDir, masterKey); err != nil {
+		return "", err
+	}
+
+	newMasterKey, err := writeNewMasterKey(dataDir)
+	if err != nil {
+		return "", err
+	}
+
+	return string(newMasterKey), nil
+}
+
+func verifyMasterKey(dataDir, masterKey string) error {
+	if len(masterKey) != aes.KeyLength {
+		return ErrInvalidMasterKey
+	}
+
+	ciphertext, err := os.ReadFile(filepath.Join(dataDir, beaverFilename))
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := aes.Decrypt(ciphertext, []byte(masterKey))
+	if err != nil || !bytes.Equal(plaintext, []byte(authMessage)) {
+		return ErrInvalidMasterKey
+	}
+
+	return nil
+}
+
+// writeNewMasterKey generates a master key and stores the message encrypted with it in the data dir.
+func writeNewMasterKey(dataDir string) ([]byte, error) {
+	masterKey, err := rand.Key(aes.KeyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := aes.Encrypt([]byte(authMessage), masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = writeFileAtomic(filepath.Join(dataDir, beaverFilename), ciphertext, 0400); err != nil {
+		return nil, err
+	}
+
+	return masterKey, nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/blob"
+)
+
+// Every uploaded file has a metadata record stored next to it in ".<filename>.meta".
+// The record is encrypted with the data key of the file, so it is re-keyed and shredded along with the file,
+// and a record left over from another file fails to decrypt and is ignored.
+const metadataSuffix = ".meta"
+
+// unknownSize is reported as the plaintext size of files stored without a metadata record.
+const unknownSize = -1
+
+type metadataRecord struct {
+	Version     string    `json:"version,omitempty"`
+	Size        int64     `json:"size"`
+	Digest      []byte    `json:"digest"`
+	ContentType string    `json:"content_type"`
+	Created     time.Time `json:"created"`
+	Modified    time.Time `json:"modified"`
+}
+
+// metadataKey returns the key of the metadata record of the file.
+func metadataKey(key string) string {
+	return path.Join(path.Dir(key), "."+path.Base(key)+metadataSuffix)
+}
+
+func writeMetadata(store blob.Store, key string, record metadataRecord, dataKey []byte) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	ciphertext, err := aes.Encrypt(data, dataKey)
+	if err != nil {
+		return err
+	}
+
+	return putBytes(store, metadataKey(key), ciphertext)
+}
+
+// readMetadata returns false if the file has no valid metadata record.
+func readMetadata(store blob.Store, key string, dataKey []byte) (metadataRecord, bool, error) {
+	ciphertext, err := readBlob(store, metadataKey(key))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return metadataRecord{}, false, nil
+		}
+		return metadataRecord{}, false, err
+	}
+
+	data, err := aes.Decrypt(ciphertext, dataKey)
+	if err != nil {
+		return metadataRecord{}, false, nil
+	}
+
+	var record metadataRecord
+
+	if err = json.Unmarshal(data, &record); err != nil {
+		return metadataRecord{}, false, nil
+	}
+
+	return record, true, nil
+}
+
+// readFileMetadata reads the metadata record of the file with the data key from its header.
+// It returns false if the file has no header or no valid metadata record.
+func readFileMetadata(store blob.Store, key string, file io.ReaderAt, userKey []byte) (metadataRecord, bool, error) {
+	header, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	if err != nil || !ok {
+		return metadataRecord{}, false, err
+	}
+
+	dataKey, err := header.dataKey(userKey)
+	if err != nil {
+		return metadataRecord{}, false, err
+	}
+
+	return readMetadata(store, key, dataKey)
+}
+
+// moveMetadata moves the metadata record along with a renamed file.
+func moveMetadata(store blob.Store, src, dst string) error {
+	err := blob.Move(store, metadataKey(src), metadataKey(dst))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func removeMetadata(store blob.Store, key string) error {
+	return store.Delete(metadataKey(key))
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ErrInvalidPath is returned for paths that are malformed, escape the user data dir,
+// point to internal files or traverse symbolic links.
+var ErrInvalidPath = errors.New("invalid path")
+
+// resolveKey maps a slash-separated path relative to the user data dir onto a blob key.
+// The keys of a user are prefixed with the username, an empty path or "." refers to the data dir itself.
+// Entries starting with a dot are reserved for internal data and can't be addressed.
+func resolveKey(username, name string) (string, error) {
+	if name == "" || name == "." {
+		return username, nil
+	}
+
+	if strings.ContainsAny(name, "\\\x00") || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+
+	for _, element := range strings.Split(name, "/") {
+		if strings.HasPrefix(element, ".") {
+			return "", fmt.Errorf("%w: %q", ErrInvalidPath, name)
+		}
+	}
+
+	return username + "/" + path.Clean(name), nil
+}
@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+// A quota override set by an admin is stored in "<user data dir>/.quota",
+// users without one are limited by the server-wide default.
+const quotaFilename = ".quota"
+
+// ErrQuotaExceeded is returned when a write would take a user over their quota.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the bytes a user stores and the number of their files. Zero limits are unlimited.
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+// Usage reports the storage used by a user along with the quota that applies to them.
+// Bytes is the stored size of the files, their previous versions and the pending uploads,
+// Files counts the current files only.
+type Usage struct {
+	Bytes int64
+	Files int64
+	Quota Quota
+}
+
+// Usage reports the storage used by the user.
+func (s Storage) Usage(ctx context.Context, user User) (Usage, error) {
+	ctx, span := trace.Start(ctx, "storage.Usage")
+	defer span.End()
+
+	return s.traced(ctx).usage(user)
+}
+
+func (s Storage) usage(user User) (Usage, error) {
+	quota, err := s.quota(user)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	bytes, files, err := storedUsage(s.store, user.Username)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return Usage{Bytes: bytes, Files: files, Quota: quota}, nil
+}
+
+// storedUsage returns the stored size of the files of the user, their previous versions and the pending uploads,
+// and the number of current files.
+func storedUsage(store blob.Store, username string) (bytes, files int64, err error) {
+	err = walkUserFiles(store, username, func(info blob.Info) error {
+		bytes += info.Size
+
+		rel := strings.TrimPrefix(info.Key, username+"/")
+
+		if !isVersionFilename(path.Base(rel)) && !isUploadPart(rel) {
+			files++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return bytes, files, nil
+}
+
+// quota returns the quota override of the user or the default quota.
+func (s Storage) quota(user User) (Quota, error) {
+	quota, ok, err := readQuota(user.DataDir)
+	if err != nil {
+		return Quota{}, err
+	}
+
+	if !ok {
+		return s.defaultQuota, nil
+	}
+
+	return quota, nil
+}
+
+// reserveQuota fails with ErrQuotaExceeded if adding the bytes and the files would take the user over their quota,
+// counting the writes of the user in progress. Otherwise it charges them to the returned reservation,
+// which must be released once the write is done.
+func (s Storage) reserveQuota(user User, bytes, files int64) (*quotaReservation, error) {
+	reservation := s.reservations.acquire(user.Username)
+
+	usage, err := s.usage(user)
+	if err != nil {
+		reservation.release()
+		return nil, err
+	}
+
+	if err = reservation.reserve(usage, bytes, files); err != nil {
+		reservation.release()
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// checkQuota fails with ErrQuotaExceeded if adding the bytes and the files would take the user over their quota,
+// without reserving them, for requests that only store data in later writes.
+func (s Storage) checkQuota(user User, bytes, files int64) error {
+	reservation, err := s.reserveQuota(user, bytes, files)
+	if err != nil {
+		return err
+	}
+
+	reservation.release()
+
+	return nil
+}
+
+// quotaReservations tracks the writes of each user in progress,
+// so concurrent writes are checked against each other and not only against the stored files.
+type quotaReservations struct {
+	users map[string]*reservedUsage
+	mu    sync.Mutex
+}
+
+// reservedUsage holds the bytes and the files charged by the writes of a user in progress.
+// The settled bytes and files are those of the writes stored since the entry was created,
+// which a usage listed before they were stored doesn't include.
+type reservedUsage struct {
+	bytes, files               int64
+	settledBytes, settledFiles int64
+	writes                     int
+}
+
+func newQuotaReservations() *quotaReservations {
+	return &quotaReservations{users: make(map[string]*reservedUsage)}
+}
+
+// acquire starts a reservation of the user, before their usage is listed.
+func (r *quotaReservations) acquire(username string) *quotaReservation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	usage, ok := r.users[username]
+	if !ok {
+		usage = &reservedUsage{}
+		r.users[username] = usage
+	}
+
+	usage.writes++
+
+	return &quotaReservation{
+		reservations: r,
+		username:     username,
+		usage:        usage,
+		settledBytes: usage.settledBytes,
+		settledFiles: usage.settledFiles,
+		limit:        -1,
+	}
+}
+
+// quotaReservation is the share of a single write in the usage reserved for its user.
+type quotaReservation struct {
+	reservations *quotaReservations
+	username     string
+	usage        *reservedUsage
+
+	// The settled bytes and files of the user at the time the reservation was acquired.
+	settledBytes, settledFiles int64
+
+	// The bytes the user may store over the listed usage, -1 if bytes aren't limited.
+	limit int64
+
+	bytes, files int64
+	stored       bool
+	released     bool
+}
+
+// reserve charges the bytes and the files to the reservation
+// if they fit the quota along with the listed usage and the other writes in progress.
+func (r *quotaReservation) reserve(listed Usage, bytes, files int64) error {
+	r.reservations.mu.Lock()
+	defer r.reservations.mu.Unlock()
+
+	quota := listed.Quota
+
+	usedFiles := listed.Files + r.usage.files + r.usage.settledFiles - r.settledFiles
+
+	if quota.MaxFiles > 0 && usedFiles+files > quota.MaxFiles {
+		return fmt.Errorf("%w: %d of %d files used", ErrQuotaExceeded, usedFiles, quota.MaxFiles)
+	}
+
+	if quota.MaxBytes > 0 {
+		r.limit = quota.MaxBytes - listed.Bytes
+
+		if used := listed.Bytes + r.pendingBytes(); used+bytes > quota.MaxBytes {
+			return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, used, quota.MaxBytes)
+		}
+	}
+
+	r.charge(bytes, files)
+
+	return nil
+}
+
+// limitWriter returns a writer to dst that charges the writes to the reservation
+// and fails with ErrQuotaExceeded as soon as they would exceed the quota.
+func (r *quotaReservation) limitWriter(dst io.Writer) io.Writer {
+	if r.limit < 0 {
+		return dst
+	}
+
+	return &quotaWriter{w: dst, reservation: r}
+}
+
+// commit marks the write as stored, so its bytes and files are counted by the usage listed from now on.
+func (r *quotaReservation) commit() {
+	r.reservations.mu.Lock()
+	defer r.reservations.mu.Unlock()
+
+	r.stored = true
+}
+
+// release returns the charges of the reservation, settling them if the write was stored.
+// The reservations of a user are dropped once none of their writes is in progress.
+func (r *quotaReservation) release() {
+	r.reservations.mu.Lock()
+	defer r.reservations.mu.Unlock()
+
+	if r.released {
+		return
+	}
+
+	r.released = true
+
+	if r.stored {
+		r.usage.settledBytes += r.bytes
+		r.usage.settledFiles += r.files
+	}
+
+	r.charge(-r.bytes, -r.files)
+
+	if r.usage.writes--; r.usage.writes == 0 {
+		delete(r.reservations.users, r.username)
+	}
+}
+
+// pendingBytes returns the bytes charged by the writes of the user in progress,
+// along with the bytes stored since the reservation was acquired. The mutex must be held.
+func (r *quotaReservation) pendingBytes() int64 {
+	return r.usage.bytes + r.usage.settledBytes - r.settledBytes
+}
+
+// charge adds the bytes and the files to the reservation and the usage of the user. The mutex must be held.
+func (r *quotaReservation) charge(bytes, files int64) {
+	r.bytes += bytes
+	r.files += files
+	r.usage.bytes += bytes
+	r.usage.files += files
+}
+
+// newFiles returns the number of files a write to the key adds.
+func (s Storage) newFiles(key string) int64 {
+	if _, _, err := s.lookup(key); errors.Is(err, fs.ErrNotExist) {
+		return 1
+	}
+
+	return 0
+}
+
+func readQuota(userDataDir string) (Quota, bool, error) {
+	data, err := os.ReadFile(filepath.Join(userDataDir, quotaFilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Quota{}, false, nil
+		}
+		return Quota{}, false, err
+	}
+
+	var quota Quota
+
+	if err = json.Unmarshal(data, &quota); err != nil {
+		return Quota{}, false, err
+	}
+
+	return quota, true, nil
+}
+
+// writeQuota stores the quota override of the user, a nil quota removes it.
+func writeQuota(userDataDir string, quota *Quota) error {
+	path := filepath.Join(userDataDir, quotaFilename)
+
+	if quota == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data, 0600)
+}
+
+// quotaWriter charges the writes to the reservation, failing without writing once a write would exceed the quota.
+type quotaWriter struct {
+	w           io.Writer
+	reservation *quotaReservation
+}
+
+func (w *quotaWriter) Write(p []byte) (int, error) {
+	r := w.reservation
+
+	r.reservations.mu.Lock()
+
+	if r.pendingBytes()+int64(len(p)) > r.limit {
+		r.reservations.mu.Unlock()
+		return 0, ErrQuotaExceeded
+	}
+
+	r.charge(int64(len(p)), 0)
+
+	r.reservations.mu.Unlock()
+
+	n, err := w.w.Write(p)
+
+	if n < len(p) {
+		r.reservations.mu.Lock()
+		r.charge(int64(n-len(p)), 0)
+		r.reservations.mu.Unlock()
+	}
+
+	return n, err
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+func TestStorage_Quota(t *testing.T) {
+	t.Parallel()
+
+	unlimited, user := newTestStorage(t, Quota{})
+
+	if _, err := unlimited.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := unlimited.Usage(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := usage.Files, int64(1); got != want {
+		t.Fatalf("got %d files, want %d", got, want)
+	}
+
+	stored := usage.Bytes
+
+	storage := NewStorage(unlimited.store.store, nil, NewKeyLocks(), testUploadTimeout, Quota{MaxBytes: 3 * stored, MaxFiles: 2}, ShareLinkPolicy{})
+
+	// The upload fits the file quota but crosses the byte quota while streaming.
+	large := strings.Repeat("x", int(3*stored))
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: file2Name}, strings.NewReader(large)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if usage, err = storage.Usage(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Bytes != stored || usage.Files != 1 {
+		t.Fatalf("got usage %+v after an aborted upload, want %d bytes in 1 file", usage, stored)
+	}
+
+	if _, err = os.Stat(filepath.Join(user.DataDir, file2Name)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for the aborted upload, want %v", err, os.ErrNotExist)
+	}
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: file2Name}, strings.NewReader("")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: "a/" + fileName}, strings.NewReader("")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if _, err = storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: "a/" + fileName}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if err = storage.Copy(context.Background(), user, fileName, "a/"+fileName, false); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// An override replaces the default quota.
+	if err = writeQuota(user.DataDir, &Quota{MaxFiles: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: "a/" + fileName}, strings.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+
+	if usage, err = storage.Usage(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := usage.Quota, (Quota{MaxFiles: 3}); got != want {
+		t.Fatalf("got quota %+v, want %+v", got, want)
+	}
+}
+
+func TestStorage_Quota_ResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{MaxBytes: 1024})
+
+	if _, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName, Size: 2048}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v for a declared size over the quota, want %v", err, ErrQuotaExceeded)
+	}
+
+	session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(strings.Repeat("x", 2048))); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if session, err = storage.QueryUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if session.CommittedOffset != 0 {
+		t.Fatalf("got committed offset %d, want 0", session.CommittedOffset)
+	}
+}
+
+func TestStorage_Quota_CompleteUpload(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{MaxFiles: 1})
+
+	// Both sessions are opened and filled while no file is stored.
+	var sessions []UploadSession
+
+	for _, name := range []string{fileName, file2Name} {
+		session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if _, err := storage.CompleteUpload(context.Background(), user, sessions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.CompleteUpload(context.Background(), user, sessions[1].ID); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v for a file over the quota, want %v", err, ErrQuotaExceeded)
+	}
+
+	if _, err := storage.AppendUpload(context.Background(), user, sessions[1].ID, int64(len(fileContent)), strings.NewReader(fileContent)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v appending to a file over the quota, want %v", err, ErrQuotaExceeded)
+	}
+
+	usage, err := storage.Usage(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Files != 1 {
+		t.Fatalf("got %d files, want 1", usage.Files)
+	}
+}
+
+func TestStorage_Quota_CompleteUpload_Bytes(t *testing.T) {
+	t.Parallel()
+
+	unlimited, user := newTestStorage(t, Quota{})
+
+	session, err := unlimited.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = unlimited.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := unlimited.Usage(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The parts take the whole quota, the file replaces them.
+	storage := NewStorage(unlimited.store.store, nil, NewKeyLocks(), testUploadTimeout, Quota{MaxBytes: usage.Bytes}, ShareLinkPolicy{})
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_Quota_RestoreVersion(t *testing.T) {
+	t.Parallel()
+
+	unlimited, user := newTestStorage(t, Quota{})
+
+	if err := unlimited.SetVersioning(context.Background(), user, VersioningPolicy{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{strings.Repeat("x", 4096), ""} {
+		if _, err := unlimited.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := unlimited.ListVersions(context.Background(), user, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := unlimited.Usage(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage := NewStorage(unlimited.store.store, nil, NewKeyLocks(), testUploadTimeout, Quota{MaxBytes: usage.Bytes + 1024}, ShareLinkPolicy{})
+
+	large := versions[len(versions)-1]
+
+	if _, err = storage.RestoreVersion(context.Background(), user, fileName, large.Version); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("got %v restoring a version over the quota, want %v", err, ErrQuotaExceeded)
+	}
+
+	storage = NewStorage(unlimited.store.store, nil, NewKeyLocks(), testUploadTimeout, Quota{MaxBytes: usage.Bytes + 2*large.StoredSize}, ShareLinkPolicy{})
+
+	if _, err = storage.RestoreVersion(context.Background(), user, fileName, large.Version); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_Quota_ConcurrentUploads(t *testing.T) {
+	t.Parallel()
+
+	const (
+		size     = 256 << 10
+		maxBytes = 384 << 10
+	)
+
+	storage, user := newTestStorage(t, Quota{MaxBytes: maxBytes})
+
+	content, err := rand.Bytes(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		writers = make([]*io.PipeWriter, 2)
+		errs    = make(chan error, len(writers))
+	)
+
+	for i := range writers {
+		reader, writer := io.Pipe()
+		writers[i] = writer
+
+		name := []string{fileName, file2Name}[i]
+
+		go func() {
+			_, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, reader)
+			_ = reader.CloseWithError(err)
+			errs <- err
+		}()
+	}
+
+	// Both uploads have passed the quota check once they have read the first half of their content,
+	// so neither of them can see the other in the stored usage.
+	for _, writer := range writers {
+		if _, err = writer.Write(content[:size/2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, writer := range writers {
+		go func(writer *io.PipeWriter) {
+			_, _ = writer.Write(content[size/2:])
+			_ = writer.Close()
+		}(writer)
+	}
+
+	var exceeded int
+
+	for range writers {
+		err := <-errs
+		switch {
+		case errors.Is(err, ErrQuotaExceeded):
+			exceeded++
+		case err != nil:
+			t.Fatal(err)
+		}
+	}
+
+	if exceeded == 0 {
+		t.Fatalf("both uploads succeeded, want at least one to exceed the quota")
+	}
+
+	usage, err := storage.Usage(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Bytes > maxBytes {
+		t.Fatalf("got %d bytes used, want at most %d", usage.Bytes, maxBytes)
+	}
+}
+
+func TestAuthenticator_SetQuota(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{MaxFiles: 1}, ShareLinkPolicy{})
+
+	token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := authenticator.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = authenticator.SetQuota(context.Background(), "0123456789abcdef0123456789abcdef", "user", &Quota{}); !errors.Is(err, ErrInvalidMasterKey) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidMasterKey)
+	}
+
+	if err = authenticator.SetQuota(context.Background(), masterKey, "missing", &Quota{}); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrUserNotFound)
+	}
+
+	if err = authenticator.SetQuota(context.Background(), masterKey, "user", &Quota{MaxFiles: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{fileName, file2Name} {
+		if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = authenticator.SetQuota(context.Background(), masterKey, "user", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := storage.Usage(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := usage.Quota, (Quota{MaxFiles: 1}); got != want {
+		t.Fatalf("got quota %+v after removing the override, want %+v", got, want)
+	}
+}
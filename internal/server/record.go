@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+)
+
+const recordVersion = 3
+
+// userRecord is stored in the user data dir and is used to verify the passphrase.
+// Records of version 1 hold only the verifier and use the legacy key derivation.
+// Records of version 2 have no key pair, it is added at the next sign-in.
+type userRecord struct {
+	Version    int       `json:"version"`
+	KDF        kdfParams `json:"kdf"`
+	Verifier   []byte    `json:"verifier"`
+	PublicKey  []byte    `json:"public_key,omitempty"`
+	PrivateKey []byte    `json:"private_key,omitempty"`
+}
+
+// newUserRecord derives a key from the passphrase with new parameters
+// and returns the record along with the key.
+func newUserRecord(ctx context.Context, passphrase string, params Argon2Params) (userRecord, []byte, error) {
+	kdf, err := newKDFParams(params)
+	if err != nil {
+		return userRecord{}, nil, err
+	}
+
+	key, err := kdf.deriveKey(ctx, passphrase)
+	if err != nil {
+		return userRecord{}, nil, err
+	}
+
+	verifier, err := aes.Encrypt([]byte(authMessage), key)
+	if err != nil {
+		return userRecord{}, nil, err
+	}
+
+	return userRecord{Version: recordVersion, KDF: kdf, Verifier: verifier}, key, nil
+}
+
+func readUserRecord(path, username string) (userRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return userRecord{}, ErrUserNotFound
+		}
+		return userRecord{}, err
+	}
+
+	return parseUserRecord(data, username)
+}
+
+func parseUserRecord(data []byte, username string) (userRecord, error) {
+	if !bytes.HasPrefix(data, []byte("{")) {
+		return userRecord{Version: 1, KDF: legacyKDFParams(username), Verifier: data}, nil
+	}
+
+	var record userRecord
+
+	if err := json.Unmarshal(data, &record); err != nil {
+		return userRecord{}, err
+	}
+
+	return record, nil
+}
+
+// verify returns the key derived from the passphrase if the passphrase is valid.
+func (r userRecord) verify(ctx context.Context, passphrase string) ([]byte, error) {
+	key, err := r.KDF.deriveKey(ctx, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aes.Decrypt(r.Verifier, key)
+	if err != nil || !bytes.Equal(plaintext, []byte(authMessage)) {
+		return nil, ErrInvalidPassphrase
+	}
+
+	return key, nil
+}
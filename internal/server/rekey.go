@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/blob"
+)
+
+// rekeyJournal is written before the files of a user are re-keyed after a passphrase
+// or key derivation change.
+// It holds the new user record and each of the keys wrapped with the other one,
+// so a migration interrupted by a crash can be rolled forward by the next request
+// that proves knowledge of either the old or the new passphrase.
+type rekeyJournal struct {
+	Record []byte `json:"record"`
+	OldKey []byte `json:"old_key"`
+	NewKey []byte `json:"new_key"`
+}
+
+func newRekeyJournal(record, oldKey, newKey []byte) (rekeyJournal, error) {
+	wrappedOldKey, err := aes.Encrypt(oldKey, newKey)
+	if err != nil {
+		return rekeyJournal{}, err
+	}
+
+	wrappedNewKey, err := aes.Encrypt(newKey, oldKey)
+	if err != nil {
+		return rekeyJournal{}, err
+	}
+
+	return rekeyJournal{
+		Record: record,
+		OldKey: wrappedOldKey,
+		NewKey: wrappedNewKey,
+	}, nil
+}
+
+// readRekeyJournal returns false if there is no migration in progress.
+func readRekeyJournal(path string) (rekeyJournal, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return rekeyJournal{}, false, nil
+		}
+		return rekeyJournal{}, false, err
+	}
+
+	var journal rekeyJournal
+
+	if err = json.Unmarshal(data, &journal); err != nil {
+		return rekeyJournal{}, false, err
+	}
+
+	return journal, true, nil
+}
+
+// keys returns the old and the new key derived from either the old or the new passphrase.
+func (j rekeyJournal) keys(ctx context.Context, passphrase string, oldRecord, newRecord userRecord) (oldKey, newKey []byte, err error) {
+	if newKey, err = newRecord.verify(ctx, passphrase); err == nil {
+		if oldKey, err = aes.Decrypt(j.OldKey, newKey); err != nil {
+			return nil, nil, err
+		}
+		return oldKey, newKey, nil
+	}
+
+	if oldKey, err = oldRecord.verify(ctx, passphrase); err == nil {
+		if newKey, err = aes.Decrypt(j.NewKey, oldKey); err != nil {
+			return nil, nil, err
+		}
+		return oldKey, newKey, nil
+	}
+
+	return nil, nil, ErrInvalidPassphrase
+}
+
+// rekey re-encrypts every file of the user under the new key and then commits the new user record.
+// Every step is idempotent, so it is safe to run it again after a crash.
+func rekey(ctx context.Context, store blob.Store, username, userDataDir, recordPath, journalPath string, journal rekeyJournal, oldKey, newKey []byte) error {
+	err := walkUserFiles(store, username, func(info blob.Info) error {
+		return rekeyFile(ctx, store, info.Key, oldKey, newKey)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = writeFileAtomic(recordPath, journal.Record, 0400); err != nil {
+		return err
+	}
+
+	if err = os.Remove(journalPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return syncDir(userDataDir)
+}
+
+// rekeyFile rewraps the data key of the file with the new key.
+// Files without a header are encrypted with the user key directly, so they are re-encrypted as a whole.
+func rekeyFile(ctx context.Context, store blob.Store, key string, oldKey, newKey []byte) error {
+	file, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return reencryptFile(ctx, store, key, file, oldKey, newKey)
+	}
+
+	if _, err = header.dataKey(newKey); err == nil {
+		return nil
+	}
+
+	dataKey, err := header.dataKey(oldKey)
+	if err != nil {
+		return err
+	}
+
+	if err = header.wrap(dataKey, newKey); err != nil {
+		return err
+	}
+
+	data, err := header.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// The file is replaced with a copy rather than having its header overwritten in place,
+	// as a torn write would leave the data key wrapped with neither key.
+	return store.Put(key, io.MultiReader(
+		bytes.NewReader(data),
+		io.NewSectionReader(file, header.size(), file.Size()-header.size()),
+	))
+}
+
+// reencryptFile replaces the file with its content encrypted under the new key.
+// Only files without a header are reencrypted, so the content is never compressed.
+func reencryptFile(ctx context.Context, store blob.Store, key string, src io.Reader, oldKey, newKey []byte) error {
+	return putStream(store, key, func(w io.Writer) error {
+		pr, pw := io.Pipe()
+
+		go func() {
+			pw.CloseWithError(decryptFile(ctx, pw, src, oldKey))
+		}()
+
+		_, err := encryptFile(ctx, w, pr, newKey, codecNone)
+		if err != nil {
+			_ = pr.CloseWithError(err)
+		}
+
+		return err
+	})
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/trace"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// A file is shared by wrapping its data key to the public key of the recipient.
+// The share is stored with the recipient under "<recipient>/.shared/<id>", where id identifies the shared file,
+// so the files shared with a user are listed and removed along with the user.
+// The owner keeps "<owner>/.recipients/<id>/<recipient>" for every share,
+// so the shares of a file are removed when the file or its owner is deleted.
+// A share grants the content the file has when it is shared: once the owner replaces or renames the file,
+// the share is skipped until the file is shared again.
+const (
+	sharedDirname     = ".shared"
+	recipientsDirname = ".recipients"
+)
+
+var (
+	// ErrInvalidRecipient is returned when sharing a file with an unknown user or with its owner.
+	ErrInvalidRecipient = errors.New("invalid recipient")
+	// ErrNotShareable is returned for files stored before per-file keys were introduced
+	// and for recipients that have no key pair yet.
+	ErrNotShareable = errors.New("file can't be shared")
+)
+
+// Keyring provides the key pairs the data keys of shared files are wrapped with.
+type Keyring interface {
+	PublicKey(username string) (*[keySize]byte, error)
+	PrivateKey(user User) (*[keySize]byte, error)
+}
+
+// SharedFile describes a file shared with the user. The path of the file is relative to the owner root.
+type SharedFile struct {
+	FileInfo
+	Owner  string
+	Shared time.Time
+}
+
+type shareRecord struct {
+	Owner   string    `json:"owner"`
+	Path    string    `json:"path"`
+	Key     []byte    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// Share grants the recipient read access to the file. Sharing a file again renews the share.
+func (s Storage) Share(ctx context.Context, user User, name, recipient string) error {
+	ctx, span := trace.Start(ctx, "storage.Share")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	if !validUsername(recipient) || recipient == user.Username {
+		return fmt.Errorf("%w: %q", ErrInvalidRecipient, recipient)
+	}
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := s.dataKey(key, name, user.Key())
+	if err != nil {
+		return err
+	}
+
+	publicKey, err := s.keyring.PublicKey(recipient)
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		return fmt.Errorf("%w: %q", ErrInvalidRecipient, recipient)
+	case errors.Is(err, errNoKeyPair):
+		return fmt.Errorf("%w: %q has no key pair until they sign in", ErrNotShareable, recipient)
+	case err != nil:
+		return err
+	}
+
+	sealedKey, err := box.SealAnonymous(nil, dataKey, publicKey, rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(shareRecord{
+		Owner:   user.Username,
+		Path:    strings.TrimPrefix(key, user.Username+"/"),
+		Key:     sealedKey,
+		Created: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// The recipient is recorded first, so a share is never left without its entry on the owner side.
+	if err = putBytes(s.store, recipientKey(recipient, key), nil); err != nil {
+		return err
+	}
+
+	return putBytes(s.store, shareKey(recipient, key), data)
+}
+
+// Unshare revokes the access of the recipient to the file.
+func (s Storage) Unshare(ctx context.Context, user User, name, recipient string) error {
+	ctx, span := trace.Start(ctx, "storage.Unshare")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	if !validUsername(recipient) {
+		return fmt.Errorf("%w: %q", ErrInvalidRecipient, recipient)
+	}
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.store.Stat(shareKey(recipient, key)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %q is not shared with %q", fs.ErrNotExist, name, recipient)
+		}
+		return err
+	}
+
+	if err = s.store.Delete(shareKey(recipient, key)); err != nil {
+		return err
+	}
+
+	return s.store.Delete(recipientKey(recipient, key))
+}
+
+// SharedWithMe describes the files shared with the user ordered by owner and path.
+func (s Storage) SharedWithMe(ctx context.Context, user User) ([]SharedFile, error) {
+	ctx, span := trace.Start(ctx, "storage.SharedWithMe")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	infos, err := s.store.List(user.Username + "/" + sharedDirname + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(infos) == 0 {
+		return nil, nil
+	}
+
+	privateKey, err := s.keyring.PrivateKey(user)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []SharedFile
+
+	for _, info := range infos {
+		record, dataKey, err := s.readShare(info.Key, privateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		fileInfo, ok, err := s.statShared(record, dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		files = append(files, SharedFile{FileInfo: fileInfo, Owner: record.Owner, Shared: record.Created})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Owner != files[j].Owner {
+			return files[i].Owner < files[j].Owner
+		}
+		return comparePaths(files[i].Path, files[j].Path) < 0
+	})
+
+	return files, nil
+}
+
+// DownloadSharedWithMe writes the plaintext of the byte range of the file the owner shared with the user to dst.
+// The plaintext of a whole file is checked against the digest recorded on upload.
+func (s Storage) DownloadSharedWithMe(ctx context.Context, user User, owner, name string, byteRange ByteRange, dst io.Writer) (DownloadResult, error) {
+	ctx, span := trace.Start(ctx, "storage.DownloadSharedWithMe")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	if err := checkRange(byteRange); err != nil {
+		return DownloadResult{}, err
+	}
+
+	if !validUsername(owner) {
+		return DownloadResult{}, fmt.Errorf("%w: %q is not shared with you", fs.ErrNotExist, name)
+	}
+
+	key, err := resolveKey(owner, name)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	privateKey, err := s.keyring.PrivateKey(user)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	_, dataKey, err := s.readShare(shareKey(user.Username, key), privateKey)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DownloadResult{}, fmt.Errorf("%w: %q is not shared with you", fs.ErrNotExist, name)
+	}
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	return s.downloadWithKey(ctx, key, name, dataKey, byteRange, dst)
+}
+
+// downloadWithKey writes the plaintext of the byte range of the file encrypted with the data key to dst.
+// A file replaced after the data key was handed out is reported as not existing.
+func (s Storage) downloadWithKey(ctx context.Context, key, name string, dataKey []byte, byteRange ByteRange, dst io.Writer) (DownloadResult, error) {
+	file, err := s.openFile(key, name)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	defer file.Close()
+
+	header, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	record, current, err := readMetadata(s.store, key, dataKey)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	if !ok || !current {
+		return DownloadResult{}, fmt.Errorf("%w: %q was replaced after it was shared", fs.ErrNotExist, name)
+	}
+
+	stream := io.NewSectionReader(file, header.size(), file.Size()-header.size())
+
+	result := DownloadResult{Digest: record.Digest}
+
+	if byteRange != (ByteRange{}) {
+		return result, decryptSection(ctx, dst, stream, dataKey, header.codec, byteRange)
+	}
+
+	digest := sha256.New()
+
+	if err = decryptStream(ctx, io.MultiWriter(dst, digest), stream, dataKey, header.codec); err != nil {
+		return DownloadResult{}, err
+	}
+
+	if err = verifyDigest(digest, record.Digest, name); err != nil {
+		return DownloadResult{}, err
+	}
+
+	return result, nil
+}
+
+// dataKey returns the data key of the file unwrapped with the user key.
+func (s Storage) dataKey(key, name string, userKey []byte) ([]byte, error) {
+	file, err := s.openFile(key, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q has to be uploaded again", ErrNotShareable, name)
+	}
+
+	return header.dataKey(userKey)
+}
+
+// readShare returns the share record stored under the key and the data key it wraps.
+func (s Storage) readShare(key string, privateKey *[keySize]byte) (shareRecord, []byte, error) {
+	data, err := readBlob(s.store, key)
+	if err != nil {
+		return shareRecord{}, nil, err
+	}
+
+	var record shareRecord
+
+	if err = json.Unmarshal(data, &record); err != nil {
+		return shareRecord{}, nil, err
+	}
+
+	publicKey, err := publicKeyOf(privateKey)
+	if err != nil {
+		return shareRecord{}, nil, err
+	}
+
+	dataKey, ok := box.OpenAnonymous(nil, record.Key, publicKey, privateKey)
+	if !ok {
+		return shareRecord{}, nil, fmt.Errorf("share %q can't be opened", key)
+	}
+
+	return record, dataKey, nil
+}
+
+// statShared describes the shared file. It returns false if the file no longer exists or was replaced.
+func (s Storage) statShared(record shareRecord, dataKey []byte) (FileInfo, bool, error) {
+	key := record.Owner + "/" + record.Path
+
+	info, err := s.store.Stat(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return FileInfo{}, false, nil
+		}
+		return FileInfo{}, false, err
+	}
+
+	metadata, ok, err := readMetadata(s.store, key, dataKey)
+	if err != nil || !ok {
+		return FileInfo{}, false, err
+	}
+
+	return FileInfo{
+		Path:        record.Path,
+		Version:     metadata.Version,
+		Size:        metadata.Size,
+		StoredSize:  info.Size,
+		Created:     metadata.Created,
+		Modified:    metadata.Modified,
+		Digest:      metadata.Digest,
+		ContentType: metadata.ContentType,
+	}, true, nil
+}
+
+// removeShares removes the shares of the file with all its recipients.
+func removeShares(store blob.Store, fileKey string) error {
+	owner, _, _ := strings.Cut(fileKey, "/")
+
+	return removeRecipients(store, owner+"/"+recipientsDirname+"/"+shareID(fileKey)+"/")
+}
+
+// revokeFile removes the shares and the share links of the file. They name the file by its path,
+// so they are removed when the file is deleted, moved or replaced rather than left to whatever is stored there next.
+func revokeFile(store blob.Store, fileKey string) error {
+	if err := removeShares(store, fileKey); err != nil {
+		return err
+	}
+
+	return removeLinks(store, fileKey)
+}
+
+// removeUserShares removes the shares of all the files of the owner.
+func removeUserShares(store blob.Store, owner string) error {
+	return removeRecipients(store, owner+"/"+recipientsDirname+"/")
+}
+
+// removeRecipients removes the shares recorded under the prefix of the recipients of an owner along with their entries.
+func removeRecipients(store blob.Store, prefix string) error {
+	infos, err := store.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range infos {
+		_, rel, _ := strings.Cut(info.Key, "/"+recipientsDirname+"/")
+
+		id, recipient, ok := strings.Cut(rel, "/")
+		if !ok || !validUsername(recipient) {
+			continue
+		}
+
+		if err = store.Delete(recipient + "/" + sharedDirname + "/" + id); err != nil {
+			return err
+		}
+
+		if err = store.Delete(info.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// shareKey returns the key of the share of the file with the recipient.
+func shareKey(recipient, fileKey string) string {
+	return recipient + "/" + sharedDirname + "/" + shareID(fileKey)
+}
+
+// recipientKey returns the key recording on the owner side that the file is shared with the recipient.
+func recipientKey(recipient, fileKey string) string {
+	owner, _, _ := strings.Cut(fileKey, "/")
+
+	return owner + "/" + recipientsDirname + "/" + shareID(fileKey) + "/" + recipient
+}
+
+func shareID(fileKey string) string {
+	id := sha256.Sum256([]byte(fileKey))
+	return hex.EncodeToString(id[:])
+}
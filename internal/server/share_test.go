@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStorage_Share(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+	recipient := addTestUser(t, authenticator, "recipient", masterKey)
+
+	content := strings.Repeat("0123456789", 20000)
+
+	metadata := FileMetadata{Filename: "a/" + fileName, Compression: CompressionGzip}
+
+	if _, err := storage.Upload(context.Background(), owner, metadata, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"recipient", "owner", "missing", "../recipient", ""} {
+		err := storage.Share(context.Background(), owner, metadata.Filename, name)
+		if name == "recipient" && err != nil {
+			t.Fatalf("Share(%q) error = %v", name, err)
+		}
+		if name != "recipient" && !errors.Is(err, ErrInvalidRecipient) {
+			t.Fatalf("Share(%q) error = %v, want %v", name, err, ErrInvalidRecipient)
+		}
+	}
+
+	if err := storage.Share(context.Background(), owner, "a", "recipient"); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("got %v for a directory, want %v", err, ErrInvalidPath)
+	}
+
+	files, err := storage.SharedWithMe(context.Background(), recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Owner != "owner" || files[0].Path != metadata.Filename || files[0].Size != int64(len(content)) {
+		t.Fatalf("got shared files %+v, want %q of owner", files, metadata.Filename)
+	}
+
+	if files, err = storage.SharedWithMe(context.Background(), owner); err != nil || len(files) != 0 {
+		t.Fatalf("got %+v, %v for the owner, want no shared files", files, err)
+	}
+
+	// The key pair is kept when the passphrase changes.
+	token, err := authenticator.ChangePassphrase(context.Background(), "recipient", "passphrase", "new passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recipient, err = authenticator.ValidateToken(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, byteRange := range []ByteRange{{}, {Offset: 70000, Length: 10}} {
+		dst := &strings.Builder{}
+
+		result, err := storage.DownloadSharedWithMe(context.Background(), recipient, "owner", metadata.Filename, byteRange, dst)
+		if err != nil {
+			t.Fatalf("%+v: %v", byteRange, err)
+		}
+
+		end := int64(len(content))
+		if byteRange.Length > 0 {
+			end = byteRange.Offset + byteRange.Length
+		}
+
+		if got, want := dst.String(), content[byteRange.Offset:end]; got != want {
+			t.Fatalf("%+v: got %d bytes, want %d", byteRange, len(got), len(want))
+		}
+
+		if digest := sha256.Sum256([]byte(content)); !bytes.Equal(result.Digest, digest[:]) {
+			t.Fatalf("%+v: got digest %x, want %x", byteRange, result.Digest, digest)
+		}
+	}
+
+	if _, err = storage.DownloadSharedWithMe(context.Background(), recipient, "owner", fileName, ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for a file that isn't shared, want %v", err, os.ErrNotExist)
+	}
+
+	// A replaced file is no longer shared.
+	if err = storage.Delete(context.Background(), owner, metadata.Filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Upload(context.Background(), owner, metadata, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if files, err = storage.SharedWithMe(context.Background(), recipient); err != nil || len(files) != 0 {
+		t.Fatalf("got %+v, %v after the file was replaced, want no shared files", files, err)
+	}
+
+	if _, err = storage.DownloadSharedWithMe(context.Background(), recipient, "owner", metadata.Filename, ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v after the file was replaced, want %v", err, os.ErrNotExist)
+	}
+
+	if err = storage.Share(context.Background(), owner, metadata.Filename, "recipient"); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err = storage.DownloadSharedWithMe(context.Background(), recipient, "owner", metadata.Filename, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if dst.String() != fileContent {
+		t.Fatalf("got %q after sharing again, want %q", dst.String(), fileContent)
+	}
+
+	if err = storage.Unshare(context.Background(), owner, metadata.Filename, "recipient"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Unshare(context.Background(), owner, metadata.Filename, "recipient"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	if _, err = storage.DownloadSharedWithMe(context.Background(), recipient, "owner", metadata.Filename, ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v after unsharing, want %v", err, os.ErrNotExist)
+	}
+
+	usage, err := storage.Usage(context.Background(), recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if usage.Files != 0 || usage.Bytes != 0 {
+		t.Fatalf("got usage %+v, want shares not to count", usage)
+	}
+}
+
+func TestStorage_Share_Delete(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+	addTestUser(t, authenticator, "recipient", masterKey)
+
+	for _, name := range []string{fileName, file2Name} {
+		if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: name}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := storage.Share(context.Background(), owner, name, "recipient"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := storage.Delete(context.Background(), owner, fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authenticator.store.Stat(shareKey("recipient", "owner/"+fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for the share of a deleted file, want %v", err, os.ErrNotExist)
+	}
+
+	if _, err := authenticator.store.Stat(shareKey("recipient", "owner/"+file2Name)); err != nil {
+		t.Fatalf("got %v for the share of a kept file, want it to exist", err)
+	}
+
+	if err := authenticator.DeleteUser(context.Background(), "owner", "", masterKey); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := authenticator.store.List("recipient/" + sharedDirname + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 0 {
+		t.Fatalf("got shares %+v after the owner was deleted, want none", infos)
+	}
+}
+
+func TestStorage_Share_RenameCopy(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+	addTestUser(t, authenticator, "recipient", masterKey)
+
+	names := []string{fileName, file2Name, "a/" + fileName}
+
+	for _, name := range names {
+		if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: name}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := storage.Share(context.Background(), owner, name, "recipient"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shared := func(name string) bool {
+		t.Helper()
+
+		_, err := authenticator.store.Stat(shareKey("recipient", "owner/"+name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatal(err)
+		}
+
+		return err == nil
+	}
+
+	// The shares of a replaced file go away with it, the copied file keeps its own.
+	if err := storage.Copy(context.Background(), owner, fileName, file2Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if !shared(fileName) || shared(file2Name) {
+		t.Fatal("got the share of a file replaced by a copy kept, want it removed")
+	}
+
+	// Neither the renamed file nor the replaced one is shared anymore.
+	if err := storage.Share(context.Background(), owner, file2Name, "recipient"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Rename(context.Background(), owner, fileName, file2Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if shared(fileName) || shared(file2Name) {
+		t.Fatal("got the shares of a renamed and a replaced file kept, want them removed")
+	}
+
+	if err := storage.Rename(context.Background(), owner, "a", "b", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if shared("a/"+fileName) || shared("b/"+fileName) {
+		t.Fatal("got the share of a file in a renamed directory kept, want it removed")
+	}
+
+	files, err := storage.SharedWithMe(context.Background(), User{Username: "recipient"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 0 {
+		t.Fatalf("got shared files %+v, want none", files)
+	}
+}
+
+func TestStorage_Share_WithoutKeyPair(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	owner := addTestUser(t, authenticator, "owner", masterKey)
+
+	if _, err := storage.Upload(context.Background(), owner, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Create a recipient the way it was done before key pairs were introduced.
+	record, _, err := newUserRecord(context.Background(), "passphrase", testKDFParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record.Version = 2
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordPath := filepath.Join(authenticator.dataDir, "recipient", ".recipient")
+
+	if err = os.Mkdir(filepath.Dir(recordPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(recordPath, data, 0400); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Share(context.Background(), owner, fileName, "recipient"); !errors.Is(err, ErrNotShareable) {
+		t.Fatalf("got %v, want %v", err, ErrNotShareable)
+	}
+
+	token, err := authenticator.Authenticate(context.Background(), "recipient", "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record, err = readUserRecord(recordPath, "recipient"); err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Version != recordVersion || record.PublicKey == nil {
+		t.Fatalf("got record %+v, want a key pair added at sign-in", record)
+	}
+
+	if err = storage.Share(context.Background(), owner, fileName, "recipient"); err != nil {
+		t.Fatal(err)
+	}
+
+	recipient, err := authenticator.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err = storage.DownloadSharedWithMe(context.Background(), recipient, "owner", fileName, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if dst.String() != fileContent {
+		t.Fatalf("got %q, want %q", dst.String(), fileContent)
+	}
+}
+
+func addTestUser(t *testing.T, authenticator *Authenticator, username, masterKey string) User {
+	t.Helper()
+
+	token, err := authenticator.AddUser(context.Background(), username, "passphrase", masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := authenticator.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+// shredChunkSize limits the size of a single overwrite when a file is zeroed in place.
+const shredChunkSize = 1 << 20
+
+// shredFile makes the file content unrecoverable regardless of the key.
+// Files with a header lose their wrapped data key, other files are overwritten with zeros.
+// Stores that can't overwrite in place replace the file, so the previous content may persist in the backend,
+// which is only acceptable for the legacy files without a header.
+func shredFile(store blob.Store, key string) error {
+	file, err := store.Get(key)
+	if err != nil {
+		return err
+	}
+
+	_, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	size := file.Size()
+	_ = file.Close()
+	if err != nil {
+		return err
+	}
+
+	if ok {
+		garbage, err := rand.Bytes(wrappedKeySize)
+		if err != nil {
+			return err
+		}
+
+		return blob.Patch(store, key, wrappedKeyOffset, garbage)
+	}
+
+	patcher, ok := store.(blob.Patcher)
+	if !ok {
+		return store.Put(key, io.LimitReader(zeroReader{}, size))
+	}
+
+	zeros := make([]byte, shredChunkSize)
+
+	for offset := int64(0); offset < size; offset += shredChunkSize {
+		chunk := zeros
+		if size-offset < shredChunkSize {
+			chunk = zeros[:size-offset]
+		}
+
+		if err = patcher.Patch(key, offset, chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+)
+
+// Stats summarizes the data of all users for monitoring, Bytes and Files add up the usage of every user.
+type Stats struct {
+	Users int64
+	Files int64
+	Bytes int64
+}
+
+// CollectStats lists the files of every user, so it is as slow as the blob store listing.
+// Users are the directories of the data dir that hold a user record.
+func CollectStats(store blob.Store, dataDir string) (Stats, error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var stats Stats
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		username := entry.Name()
+
+		if _, err = os.Stat(filepath.Join(dataDir, username, "."+username)); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return Stats{}, err
+		}
+
+		bytes, files, err := storedUsage(store, username)
+		if err != nil {
+			return Stats{}, err
+		}
+
+		stats.Users++
+		stats.Files += files
+		stats.Bytes += bytes
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCollectStats(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	var want Stats
+
+	for _, username := range []string{"alice", "bob"} {
+		user := addTestUser(t, authenticator, username, masterKey)
+
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		usage, err := storage.Usage(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want.Users++
+		want.Files += usage.Files
+		want.Bytes += usage.Bytes
+	}
+
+	got, err := CollectStats(authenticator.store, authenticator.dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != want || got.Files != 2 {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
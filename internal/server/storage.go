@@ -1,60 +1,951 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
 	"io"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/trace"
 )
 
-type Storage struct{}
+var (
+	// ErrSizeMismatch is returned when the uploaded content differs in size from the declared one.
+	ErrSizeMismatch = errors.New("file size does not match the declared size")
+	// ErrDigestMismatch is returned when the uploaded content differs in digest from the expected one.
+	ErrDigestMismatch = errors.New("file digest does not match the expected digest")
+	// ErrNotEmpty is returned when deleting a directory that has entries.
+	ErrNotEmpty = errors.New("directory is not empty")
+	// ErrInvalidRange is returned when a byte range doesn't fit the file.
+	ErrInvalidRange = errors.New("invalid byte range")
+)
+
+type Storage struct {
+	store         pathStore
+	keyring       Keyring
+	linkPolicy    ShareLinkPolicy
+	uploadTimeout time.Duration
+	defaultQuota  Quota
+	locks         *userLocks
+	keyLocks      *KeyLocks
+	reservations  *quotaReservations
+	linkKDF       *kdfLimiter
+}
+
+// FileMetadata describes a file sent by the client before its content.
+// Size is optional, zero means that the size is unknown.
+// Digest is the optional SHA-256 checksum of the plaintext the upload is checked against.
+type FileMetadata struct {
+	Filename    string
+	Size        int64
+	ContentType string
+	Compression Compression
+	Digest      []byte
+}
+
+// FileInfo describes a stored file or directory. Path is slash-separated.
+// Size is the plaintext size and StoredSize is the size in the store, including the header and the encryption overhead.
+// Files uploaded before metadata records were introduced have a Size of -1, no Digest and no Version.
+type FileInfo struct {
+	Path        string
+	Version     string
+	IsDir       bool
+	Size        int64
+	StoredSize  int64
+	Created     time.Time
+	Modified    time.Time
+	Digest      []byte
+	ContentType string
+}
+
+// ByteRange selects Length bytes of a file starting at Offset.
+// A zero Length selects the rest of the file, a Length past the end of the file is truncated.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// UploadResult summarizes an uploaded file.
+// Digest is the SHA-256 checksum of the plaintext.
+type UploadResult struct {
+	BytesWritten int64
+	Digest       []byte
+}
+
+// DownloadResult summarizes a downloaded file.
+// Digest is the SHA-256 checksum of the whole plaintext recorded on upload, regardless of the downloaded range.
+// Files uploaded before metadata records were introduced have no Digest.
+// Owner and Path are only set by DownloadShared, whose caller doesn't know the file, even if the download fails.
+type DownloadResult struct {
+	Digest []byte
+	Owner  string
+	Path   string
+}
+
+// NewStorage returns a storage that keeps the encrypted files in the blob store and shares them with the keys of the keyring.
+// It expires upload sessions not updated within uploadTimeout, limits users without a quota override by defaultQuota
+// and issues share links within linkPolicy. The key locks must be shared with the authenticator changing the user keys.
+func NewStorage(store blob.Store, keyring Keyring, keyLocks *KeyLocks, uploadTimeout time.Duration, defaultQuota Quota, linkPolicy ShareLinkPolicy) *Storage {
+	return &Storage{
+		store:         pathStore{store: store},
+		keyring:       keyring,
+		linkPolicy:    linkPolicy,
+		uploadTimeout: uploadTimeout,
+		defaultQuota:  defaultQuota,
+		locks:         newUserLocks(),
+		keyLocks:      keyLocks,
+		reservations:  newQuotaReservations(),
+		linkKDF:       newKDFLimiter(linkKDFConcurrency, linkKDFInterval, linkKDFBurst),
+	}
+}
+
+// Upload stores the file. If a file exists under the name, it becomes a previous version when versioning is enabled,
+// otherwise the upload fails.
+func (s Storage) Upload(ctx context.Context, user User, metadata FileMetadata, src io.Reader) (UploadResult, error) {
+	ctx, span := trace.Start(ctx, "storage.Upload")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	write, err := s.keyLocks.startWrite(user)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	key, err := s.resolveFile(user, metadata.Filename)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	// Uploads that can't be published are rejected before the content is received.
+	if err = s.checkUploadDestination(user, key, metadata.Filename); err != nil {
+		return UploadResult{}, err
+	}
+
+	// The upload is aborted as soon as it crosses the quota, nothing is stored then.
+	reservation, err := s.reserveQuota(user, 0, s.newFiles(key))
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer reservation.release()
+
+	if err = s.mkdirAll(user, path.Dir(key)); err != nil {
+		return UploadResult{}, err
+	}
+
+	tmp, err := tempKey(key)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer func() {
+		_ = s.store.Delete(tmp)
+	}()
+
+	var (
+		digest  = sha256.New()
+		counter = &byteCounter{}
+		dataKey []byte
+	)
+
+	// The digest and the size cover the plaintext, whether it is compressed or not.
+	codec, src := chooseCodec(metadata.Compression, src)
+
+	err = putStream(s.store, tmp, func(w io.Writer) (err error) {
+		dataKey, err = encryptFile(ctx, reservation.limitWriter(w), io.TeeReader(src, io.MultiWriter(digest, counter)), user.Key(), codec)
+		if err != nil {
+			return err
+		}
+
+		if metadata.Size > 0 && counter.n != metadata.Size {
+			return ErrSizeMismatch
+		}
+
+		return checkDigest(digest.Sum(nil), metadata.Digest)
+	})
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	now := time.Now().UTC()
+
+	record := metadataRecord{
+		Size:        counter.n,
+		Digest:      digest.Sum(nil),
+		ContentType: metadata.ContentType,
+		Created:     now,
+		Modified:    now,
+	}
+
+	// The key is only locked once the content is written, so a slow client doesn't hold off a change of the key.
+	unlock, err := write.lock()
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer unlock()
+
+	if err = s.publish(user, tmp, key, record, dataKey, false); err != nil {
+		return UploadResult{}, err
+	}
+
+	reservation.commit()
+
+	return UploadResult{BytesWritten: record.Size, Digest: record.Digest}, nil
+}
+
+// Download writes the plaintext of the byte range of the file to dst.
+// The plaintext of a whole file is checked against the digest recorded on upload.
+func (s Storage) Download(ctx context.Context, user User, filename string, byteRange ByteRange, dst io.Writer) (DownloadResult, error) {
+	ctx, span := trace.Start(ctx, "storage.Download")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	key, err := s.resolveFile(user, filename)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	return s.download(ctx, user, key, filename, byteRange, dst)
+}
+
+func (s Storage) download(ctx context.Context, user User, key, name string, byteRange ByteRange, dst io.Writer) (DownloadResult, error) {
+	if err := checkRange(byteRange); err != nil {
+		return DownloadResult{}, err
+	}
+
+	file, err := s.openFile(key, name)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+	defer file.Close()
+
+	record, _, err := readFileMetadata(s.store, key, file, user.Key())
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	result := DownloadResult{Digest: record.Digest}
+
+	if byteRange != (ByteRange{}) {
+		return result, decryptRange(ctx, dst, file, file.Size(), user.Key(), byteRange)
+	}
+
+	digest := sha256.New()
+
+	if err = decryptFile(ctx, io.MultiWriter(dst, digest), file, user.Key()); err != nil {
+		return DownloadResult{}, err
+	}
+
+	if err = verifyDigest(digest, record.Digest, name); err != nil {
+		return DownloadResult{}, err
+	}
+
+	return result, nil
+}
+
+// openFile opens the file stored under the key, reporting directories as invalid paths.
+func (s Storage) openFile(key, name string) (blob.Blob, error) {
+	file, err := s.store.Get(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, isDir, lookupErr := s.lookup(key); lookupErr == nil && isDir {
+			return nil, fmt.Errorf("%w: %q is a directory", ErrInvalidPath, name)
+		}
+	}
+
+	return file, err
+}
+
+func checkRange(byteRange ByteRange) error {
+	if byteRange.Offset < 0 || byteRange.Length < 0 {
+		return fmt.Errorf("%w: %d+%d", ErrInvalidRange, byteRange.Offset, byteRange.Length)
+	}
+
+	return nil
+}
+
+// verifyDigest reports a downloaded plaintext that differs from the uploaded one as corrupted.
+func verifyDigest(digest hash.Hash, want []byte, name string) error {
+	if want != nil && !bytes.Equal(digest.Sum(nil), want) {
+		return fmt.Errorf("%w: digest of %q does not match the uploaded one", aes.ErrCorrupted, name)
+	}
+
+	return nil
+}
+
+// checkDigest returns ErrDigestMismatch if an expected digest is given and differs from the digest of the upload.
+func checkDigest(digest, expected []byte) error {
+	if expected != nil && !bytes.Equal(digest, expected) {
+		return fmt.Errorf("%w: got %x, want %x", ErrDigestMismatch, digest, expected)
+	}
+
+	return nil
+}
+
+// Mkdir creates the directory along with any missing parents.
+func (s Storage) Mkdir(ctx context.Context, user User, dir string) error {
+	ctx, span := trace.Start(ctx, "storage.Mkdir")
+	defer span.End()
+
+	s = s.traced(ctx)
 
-func NewStorage() *Storage {
-	return &Storage{}
+	key, err := s.resolveFile(user, dir)
+	if err != nil {
+		return err
+	}
+
+	return s.mkdirAll(user, key)
+}
+
+// Stat describes the file or the directory.
+func (s Storage) Stat(ctx context.Context, user User, name string) (FileInfo, error) {
+	ctx, span := trace.Start(ctx, "storage.Stat")
+	defer span.End()
+
+	return s.traced(ctx).stat(user, name)
 }
 
-func (s Storage) Upload(user User, filename string, src io.Reader) error {
-	path := filepath.Join(user.DataDir, filename)
+func (s Storage) stat(user User, name string) (FileInfo, error) {
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	info, isDir, err := s.lookup(key)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	return statFile(s.store, info, strings.TrimPrefix(key, user.Username+"/"), isDir, user.Key())
+}
+
+// Delete removes the file or the empty directory.
+func (s Storage) Delete(ctx context.Context, user User, name string) error {
+	ctx, span := trace.Start(ctx, "storage.Delete")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return err
+	}
+
+	_, isDir, err := s.lookup(key)
+	if err != nil {
+		return err
+	}
+
+	if !isDir {
+		defer s.locks.lock(key)()
 
-	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err = s.store.Delete(key); err != nil {
+			return err
+		}
+		if err = removeMetadata(s.store, key); err != nil {
+			return err
+		}
+		if err = removeVersions(s.store, key); err != nil {
+			return err
+		}
+		return revokeFile(s.store, key)
+	}
+
+	infos, err := s.store.List(key + "/")
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
 
-	encrypter := aes.NewEncrypter(src, dst)
+	for _, info := range infos {
+		if !strings.HasPrefix(strings.TrimPrefix(info.Key, key+"/"), ".") {
+			return fmt.Errorf("%w: %q", ErrNotEmpty, name)
+		}
+	}
 
-	return encrypter.Encrypt(user.Key())
+	return deletePrefix(s.store, key+"/")
 }
 
-func (s Storage) Download(user User, filename string, dst io.Writer) error {
-	path := filepath.Join(user.DataDir, filename)
+// Rename moves the file or the directory, creating missing parents of the destination.
+// An existing destination file is replaced only if overwrite is set, its versions, shares and links are removed.
+// The shares and the links of the moved files are revoked, as they name the files by their paths.
+// A directory is moved blob by blob, so a failure may leave it split between both paths.
+func (s Storage) Rename(ctx context.Context, user User, src, dst string, overwrite bool) error {
+	ctx, span := trace.Start(ctx, "storage.Rename")
+	defer span.End()
 
-	file, err := os.Open(path)
+	s = s.traced(ctx)
+
+	srcKey, err := s.resolveFile(user, src)
+	if err != nil {
+		return err
+	}
+
+	dstKey, err := s.resolveFile(user, dst)
 	if err != nil {
 		return err
 	}
+
+	_, isDir, err := s.lookup(srcKey)
+	if err != nil {
+		return err
+	}
+
+	if isDir && (dstKey == srcKey || strings.HasPrefix(dstKey, srcKey+"/")) {
+		return fmt.Errorf("%w: %q can't be moved into itself", ErrInvalidPath, src)
+	}
+
+	defer s.locks.lock(dstKey)()
+
+	if err = s.checkDestination(dstKey, dst, overwrite); err != nil {
+		return err
+	}
+
+	if srcKey == dstKey {
+		return nil
+	}
+
+	if err = s.mkdirAll(user, path.Dir(dstKey)); err != nil {
+		return err
+	}
+
+	if isDir {
+		infos, err := s.store.List(srcKey + "/")
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			if err = s.store.Move(info.Key, dstKey+strings.TrimPrefix(info.Key, srcKey)); err != nil {
+				return err
+			}
+
+			if rel := strings.TrimPrefix(info.Key, user.Username+"/"); isUserFile(rel) && !isVersionFilename(path.Base(rel)) {
+				if err = revokeFile(s.store, info.Key); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	// The replaced file goes away along with everything recorded about it, as if it was deleted.
+	if err = removeVersions(s.store, dstKey); err != nil {
+		return err
+	}
+
+	if err = revokeFile(s.store, dstKey); err != nil {
+		return err
+	}
+
+	if err = s.store.Move(srcKey, dstKey); err != nil {
+		return err
+	}
+
+	if err = moveMetadata(s.store, srcKey, dstKey); err != nil {
+		return err
+	}
+
+	if err = moveVersions(s.store, srcKey, dstKey); err != nil {
+		return err
+	}
+
+	return revokeFile(s.store, srcKey)
+}
+
+// Copy re-encrypts the file under a new data key into the destination,
+// so the copy doesn't share key material with the original.
+// An existing destination file is replaced only if overwrite is set, its versions, shares and links are removed.
+func (s Storage) Copy(ctx context.Context, user User, src, dst string, overwrite bool) error {
+	ctx, span := trace.Start(ctx, "storage.Copy")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	write, err := s.keyLocks.startWrite(user)
+	if err != nil {
+		return err
+	}
+
+	srcKey, err := s.resolveFile(user, src)
+	if err != nil {
+		return err
+	}
+
+	dstKey, err := s.resolveFile(user, dst)
+	if err != nil {
+		return err
+	}
+
+	info, isDir, err := s.lookup(srcKey)
+	if err != nil {
+		return err
+	}
+
+	if isDir {
+		return fmt.Errorf("%w: %q is a directory", ErrInvalidPath, src)
+	}
+
+	srcInfo, err := statFile(s.store, info, src, false, user.Key())
+	if err != nil {
+		return err
+	}
+
+	if err = s.checkDestination(dstKey, dst, overwrite); err != nil {
+		return err
+	}
+
+	reservation, err := s.reserveQuota(user, info.Size, s.newFiles(dstKey))
+	if err != nil {
+		return err
+	}
+	defer reservation.release()
+
+	if err = s.mkdirAll(user, path.Dir(dstKey)); err != nil {
+		return err
+	}
+
+	tmp, record, dataKey, err := s.reencrypt(ctx, srcKey, dstKey, user.Key())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = s.store.Delete(tmp)
+	}()
+
+	unlock, err := write.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	defer s.locks.lock(dstKey)()
+
+	// The destination may have been created while the copy was encrypted.
+	if err = s.checkDestination(dstKey, dst, overwrite); err != nil {
+		return err
+	}
+
+	// The replaced file goes away along with everything recorded about it, as if it was deleted.
+	if err = removeVersions(s.store, dstKey); err != nil {
+		return err
+	}
+
+	if err = revokeFile(s.store, dstKey); err != nil {
+		return err
+	}
+
+	if err = s.store.Move(tmp, dstKey); err != nil {
+		return err
+	}
+
+	reservation.commit()
+
+	record.ContentType = srcInfo.ContentType
+	record.Version = newVersionID(record.Created)
+
+	return writeMetadata(s.store, dstKey, record, dataKey)
+}
+
+// reencrypt decrypts the file at src and encrypts it under a new data key into a temporary key next to dst,
+// keeping its compression. It returns the temporary key and the metadata record of the new file.
+func (s Storage) reencrypt(ctx context.Context, src, dst string, userKey []byte) (tmp string, record metadataRecord, dataKey []byte, err error) {
+	file, err := s.store.Get(src)
+	if err != nil {
+		return "", metadataRecord{}, nil, err
+	}
 	defer file.Close()
 
-	decrypter := aes.NewDecrypter(file, dst)
+	header, _, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	if err != nil {
+		return "", metadataRecord{}, nil, err
+	}
+
+	if tmp, err = tempKey(dst); err != nil {
+		return "", metadataRecord{}, nil, err
+	}
+
+	var (
+		digest  = sha256.New()
+		counter = &byteCounter{}
+	)
 
-	return decrypter.Decrypt(user.Key())
+	err = putStream(s.store, tmp, func(w io.Writer) (err error) {
+		pr, pw := io.Pipe()
+
+		go func() {
+			pw.CloseWithError(decryptFile(ctx, pw, file, userKey))
+		}()
+
+		if dataKey, err = encryptFile(ctx, w, io.TeeReader(pr, io.MultiWriter(digest, counter)), userKey, header.codec); err != nil {
+			_ = pr.CloseWithError(err)
+		}
+
+		return err
+	})
+	if err != nil {
+		return "", metadataRecord{}, nil, err
+	}
+
+	now := time.Now().UTC()
+
+	return tmp, metadataRecord{
+		Size:     counter.n,
+		Digest:   digest.Sum(nil),
+		Created:  now,
+		Modified: now,
+	}, dataKey, nil
 }
 
-func (s Storage) List(user User) ([]string, error) {
-	dirEntries, err := os.ReadDir(user.DataDir)
+// lookup describes the file or the directory at the key.
+// A directory is dated by its marker, or by the newest blob under it if it has none.
+func (s Storage) lookup(key string) (info blob.Info, isDir bool, err error) {
+	info, err = s.store.Stat(key)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, false, err
+	}
+
+	infos, listErr := s.store.List(key + "/")
+	if listErr != nil {
+		return blob.Info{}, false, listErr
+	}
+
+	if len(infos) == 0 {
+		return blob.Info{}, false, err
+	}
+
+	var (
+		dir    = blob.Info{Key: key}
+		marked bool
+	)
+
+	for _, entry := range infos {
+		if entry.Key == key+"/"+dirMarker {
+			dir.Modified, marked = entry.Modified, true
+		}
+		if !marked && entry.Modified.After(dir.Modified) {
+			dir.Modified = entry.Modified
+		}
+	}
+
+	return dir, true, nil
+}
+
+// mkdirAll creates the markers of the directory and of its missing parents.
+// It fails with ErrInvalidPath if any of them is a file.
+func (s Storage) mkdirAll(user User, key string) error {
+	if key == user.Username {
+		return nil
+	}
+
+	dir := user.Username
+
+	for _, element := range strings.Split(strings.TrimPrefix(key, user.Username+"/"), "/") {
+		dir += "/" + element
+
+		_, err := s.store.Stat(dir + "/" + dirMarker)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if _, err = s.store.Stat(dir); err == nil {
+			return fmt.Errorf("%w: %q is a file", ErrInvalidPath, strings.TrimPrefix(dir, user.Username+"/"))
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		if err = putBytes(s.store, dir+"/"+dirMarker, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkDestination fails with fs.ErrExist if the destination is a directory
+// or an existing file that may not be overwritten.
+func (s Storage) checkDestination(key, name string, overwrite bool) error {
+	_, isDir, err := s.lookup(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if isDir || !overwrite {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+
+	return nil
+}
+
+// statFile describes the file or the directory using the metadata record of the file if there is one.
+func statFile(store blob.Store, info blob.Info, name string, isDir bool, userKey []byte) (FileInfo, error) {
+	fileInfo := FileInfo{
+		Path:     name,
+		IsDir:    isDir,
+		Created:  info.Modified,
+		Modified: info.Modified,
+	}
+
+	if isDir {
+		return fileInfo, nil
+	}
+
+	fileInfo.Size = unknownSize
+	fileInfo.StoredSize = info.Size
+
+	file, err := store.Get(info.Key)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer file.Close()
+
+	record, ok, err := readFileMetadata(store, info.Key, file, userKey)
 	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if !ok {
+		return fileInfo, nil
+	}
+
+	fileInfo.Version = record.Version
+	fileInfo.Size = record.Size
+	fileInfo.Digest = record.Digest
+	fileInfo.ContentType = record.ContentType
+	fileInfo.Created = record.Created
+	fileInfo.Modified = record.Modified
+
+	return fileInfo, nil
+}
+
+// checkUploadDestination fails with fs.ErrExist if an upload to the key can't be published,
+// because a directory exists there or a file exists and versioning is disabled.
+func (s Storage) checkUploadDestination(user User, key, name string) error {
+	policy, err := s.versioning(user)
+	if err != nil {
+		return err
+	}
+
+	_, isDir, err := s.lookup(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if isDir || !policy.Enabled {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+
+	return nil
+}
+
+// resolve is the single place where paths received from users are mapped onto blob keys.
+func (s Storage) resolve(user User, name string) (string, error) {
+	return resolveKey(user.Username, name)
+}
+
+// resolveFile resolves a path that must not refer to the user data dir itself.
+func (s Storage) resolveFile(user User, name string) (string, error) {
+	key, err := s.resolve(user, name)
+	if err != nil {
+		return "", err
+	}
+
+	if key == user.Username {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+
+	return key, nil
+}
+
+// encryptFile writes the file header followed by src compressed with the codec and encrypted with a new data key.
+// It returns the data key.
+func encryptFile(ctx context.Context, dst io.Writer, src io.Reader, userKey []byte, codec codec) (_ []byte, err error) {
+	_, span := trace.Start(ctx, "aes.encrypt")
+	span.SetAttribute("codec", codec.String())
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	header, dataKey, err := newFileHeader(userKey, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := header.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = dst.Write(data); err != nil {
 		return nil, err
 	}
 
-	filenames := make([]string, 0, len(dirEntries))
+	compressed := compress(src, codec)
+	defer compressed.Close()
+
+	encrypter := aes.NewEncrypter(compressed, dst)
+
+	return dataKey, encrypter.Encrypt(dataKey)
+}
+
+// decryptFile decrypts a file written by encryptFile or a file without a header encrypted with the user key.
+func decryptFile(ctx context.Context, dst io.Writer, src io.Reader, userKey []byte) error {
+	reader := bufio.NewReader(src)
+
+	key, codec, err := fileKey(reader, userKey)
+	if err != nil {
+		return err
+	}
+
+	return decryptStream(ctx, dst, reader, key, codec)
+}
+
+// decryptRange decrypts only the segments of the file covering the byte range.
+// Files in the legacy format and compressed files can only be read sequentially,
+// so they are decrypted up to the end of the range.
+func decryptRange(ctx context.Context, dst io.Writer, file io.ReaderAt, size int64, userKey []byte, byteRange ByteRange) error {
+	header, ok, err := readFileHeader(bufio.NewReader(io.NewSectionReader(file, 0, fileHeaderSize)))
+	if err != nil {
+		return err
+	}
+
+	var (
+		key          = userKey
+		streamOffset int64
+	)
 
-	for _, entry := range dirEntries {
-		if !entry.IsDir() {
-			filenames = append(filenames, entry.Name())
+	if ok {
+		if key, err = header.dataKey(userKey); err != nil {
+			return err
 		}
+		streamOffset = header.size()
+	}
+
+	return decryptSection(ctx, dst, io.NewSectionReader(file, streamOffset, size-streamOffset), key, header.codec, byteRange)
+}
+
+// decryptSection decrypts the byte range of the encrypted content following the file header.
+func decryptSection(ctx context.Context, dst io.Writer, stream *io.SectionReader, key []byte, codec codec, byteRange ByteRange) (err error) {
+	if codec != codecNone {
+		return decryptRangeSequentially(ctx, dst, stream, key, codec, byteRange)
+	}
+
+	reader, err := aes.NewReaderAt(stream, stream.Size(), key)
+	if errors.Is(err, aes.ErrUnknownFormat) {
+		return decryptRangeSequentially(ctx, dst, stream, key, codecNone, byteRange)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, span := trace.Start(ctx, "aes.decrypt")
+	span.SetAttribute("mode", "random_access")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	if byteRange.Offset > reader.Size() {
+		return fmt.Errorf("%w: offset %d is past the end of the file", ErrInvalidRange, byteRange.Offset)
+	}
+
+	length := reader.Size() - byteRange.Offset
+	if byteRange.Length > 0 && byteRange.Length < length {
+		length = byteRange.Length
+	}
+
+	_, err = io.Copy(dst, io.NewSectionReader(reader, byteRange.Offset, length))
+
+	return err
+}
+
+func decryptRangeSequentially(ctx context.Context, dst io.Writer, src io.Reader, key []byte, codec codec, byteRange ByteRange) error {
+	writer := &rangeWriter{dst: dst, skip: byteRange.Offset, remaining: byteRange.Length}
+	if byteRange.Length == 0 {
+		writer.remaining = -1
+	}
+
+	err := decryptStream(ctx, writer, src, key, codec)
+	if err != nil && !errors.Is(err, errRangeWritten) {
+		return err
 	}
 
-	return filenames, nil
+	if writer.skip > 0 {
+		return fmt.Errorf("%w: offset %d is past the end of the file", ErrInvalidRange, byteRange.Offset)
+	}
+
+	return nil
+}
+
+// errRangeWritten stops decryption once the whole range has been written.
+var errRangeWritten = errors.New("range written")
+
+// rangeWriter discards the first skip bytes written to it and passes the following ones to dst.
+// Once remaining bytes have been passed it fails with errRangeWritten, a negative remaining means no limit.
+type rangeWriter struct {
+	dst       io.Writer
+	skip      int64
+	remaining int64
+}
+
+func (w *rangeWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if w.skip >= int64(len(p)) {
+		w.skip -= int64(len(p))
+		return n, nil
+	}
+
+	p = p[w.skip:]
+	w.skip = 0
+
+	if w.remaining < 0 {
+		if _, err := w.dst.Write(p); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
+	if int64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+	}
+
+	if _, err := w.dst.Write(p); err != nil {
+		return 0, err
+	}
+
+	if w.remaining -= int64(len(p)); w.remaining == 0 {
+		return n, errRangeWritten
+	}
+
+	return n, nil
+}
+
+// byteCounter counts the bytes written to it.
+type byteCounter struct {
+	n int64
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
 }
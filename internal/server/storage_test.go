@@ -1,38 +1,78 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	cipheraes "crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/aes"
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/rand"
 )
 
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+const testUploadTimeout = time.Hour
+
 const (
 	fileName    = "test.txt"
 	file2Name   = "test2.txt"
 	fileContent = "test content"
 )
 
-func TestStorage_UploadDownload(t *testing.T) {
-	t.Parallel()
+// newTestStorage returns a storage backed by a local store and a user whose data dir is the directory of their files.
+func newTestStorage(t *testing.T, quota Quota) (*Storage, User) {
+	t.Helper()
 
-	storage := NewStorage()
+	dataDir := t.TempDir()
 
 	user := User{
 		Username: "user",
-		DataDir:  t.TempDir(),
-		key:      deriveKey("key", "salt"),
+		DataDir:  filepath.Join(dataDir, "user"),
+		key:      testKey,
 	}
 
-	if err := storage.Upload(user, fileName, strings.NewReader(fileContent)); err != nil {
+	if err := os.Mkdir(user.DataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewStorage(blob.NewLocalStore(dataDir), nil, NewKeyLocks(), testUploadTimeout, quota, ShareLinkPolicy{}), user
+}
+
+func TestStorage_UploadDownload(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	result, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent))
+	if err != nil {
 		t.Fatal(err)
 	}
 
-	if err := storage.Upload(user, fileName, strings.NewReader(fileContent)); err == nil {
+	if got, want := result.BytesWritten, int64(len(fileContent)); got != want {
+		t.Fatalf("got %d bytes written, want %d", got, want)
+	}
+
+	if got, want := result.Digest, sha256.Sum256([]byte(fileContent)); !bytes.Equal(got, want[:]) {
+		t.Fatalf("got digest %x, want %x", got, want)
+	}
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err == nil {
 		t.Fatalf("got nil, want error on file already exists")
 	}
 
 	dst := &strings.Builder{}
 
-	if err := storage.Download(user, fileName, dst); err != nil {
+	if _, err = storage.Download(context.Background(), user, fileName, ByteRange{}, dst); err != nil {
 		t.Fatal(err)
 	}
 
@@ -41,37 +81,235 @@ func TestStorage_UploadDownload(t *testing.T) {
 	}
 }
 
-func TestStorage_List(t *testing.T) {
+func TestStorage_Upload_SizeMismatch(t *testing.T) {
 	t.Parallel()
 
-	storage := NewStorage()
+	storage, user := newTestStorage(t, Quota{})
 
-	user := User{
-		Username: "user",
-		DataDir:  t.TempDir(),
-		key:      deriveKey("key", "salt"),
+	metadata := FileMetadata{Filename: fileName, Size: int64(len(fileContent)) + 1}
+
+	_, err := storage.Upload(context.Background(), user, metadata, strings.NewReader(fileContent))
+	if !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrSizeMismatch)
+	}
+
+	if _, err = os.Stat(filepath.Join(user.DataDir, fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want partial file to be removed", err)
+	}
+}
+
+func TestStorage_Upload_Digest(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	digest := sha256.Sum256([]byte(fileContent))
+
+	metadata := FileMetadata{Filename: fileName, Digest: sha256.New().Sum(nil)}
+
+	if _, err := storage.Upload(context.Background(), user, metadata, strings.NewReader(fileContent)); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrDigestMismatch)
+	}
+
+	if _, err := os.Stat(filepath.Join(user.DataDir, fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want the rejected file to be removed", err)
+	}
+
+	metadata.Digest = digest[:]
+
+	if _, err := storage.Upload(context.Background(), user, metadata, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStorage_Download_Digest(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := sha256.Sum256([]byte(fileContent))
+
+	for _, byteRange := range []ByteRange{{}, {Offset: 1, Length: 2}} {
+		result, err := storage.Download(context.Background(), user, fileName, byteRange, &strings.Builder{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(result.Digest, want[:]) {
+			t.Fatalf("%+v: got digest %x, want %x", byteRange, result.Digest, want)
+		}
+	}
+
+	// A recorded digest that doesn't match the stored content fails the download of the whole file.
+	key := user.Username + "/" + fileName
+
+	file, err := storage.store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header, _, err := readFileHeader(bufio.NewReader(file))
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey, err := header.dataKey(user.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, _, err := readMetadata(storage.store, key, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record.Digest = sha256.New().Sum(nil)
+
+	if err = writeMetadata(storage.store, key, record, dataKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Download(context.Background(), user, fileName, ByteRange{}, &strings.Builder{}); !errors.Is(err, aes.ErrCorrupted) {
+		t.Fatalf("got %v, want %v", err, aes.ErrCorrupted)
+	}
+}
+
+func TestStorage_Download_Tampered(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(user.DataDir, fileName)
+
+	ciphertext, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 1
+
+	if err = os.WriteFile(path, ciphertext, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Download(context.Background(), user, fileName, ByteRange{}, &strings.Builder{}); !errors.Is(err, aes.ErrCorrupted) {
+		t.Fatalf("got %v, want %v", err, aes.ErrCorrupted)
+	}
+}
+
+func TestStorage_Upload_Envelope(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	var headers []fileHeader
+
+	for _, v := range []string{fileName, file2Name} {
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: v}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.Open(filepath.Join(user.DataDir, v))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		header, ok, err := readFileHeader(bufio.NewReader(file))
+		_ = file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !ok {
+			t.Fatalf("file %q has no header", v)
+		}
+
+		headers = append(headers, header)
 	}
 
-	filenames, err := storage.List(user)
+	keys := make([][]byte, len(headers))
+
+	for i, header := range headers {
+		key, err := header.dataKey(user.Key())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bytes.Equal(key, user.Key()) {
+			t.Fatal("got data key equal to the user key")
+		}
+
+		keys[i] = key
+	}
+
+	if bytes.Equal(keys[0], keys[1]) {
+		t.Fatal("got the same data key for different files")
+	}
+}
+
+func TestStorage_Download_WithoutHeader(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	file, err := os.Create(filepath.Join(user.DataDir, fileName))
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if got, want := len(filenames), 0; got != want {
+	err = aes.NewEncrypter(strings.NewReader(fileContent), file).Encrypt(user.Key())
+	_ = file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err = storage.Download(context.Background(), user, fileName, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := dst.String(), fileContent; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestStorage_List(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	page, err := storage.List(context.Background(), user, "", ListOptions{Recursive: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(page.Entries), 0; got != want {
 		t.Fatalf("got %d, want %d", got, want)
 	}
 
 	for _, v := range []string{fileName, file2Name} {
-		if err = storage.Upload(user, v, strings.NewReader(fileContent)); err != nil {
+		if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: v}, strings.NewReader(fileContent)); err != nil {
 			t.Fatal(err)
 		}
 	}
 
-	filenames, err = storage.List(user)
+	page, err = storage.List(context.Background(), user, "", ListOptions{Recursive: false})
 	if err != nil {
 		t.Fatal(err)
 	}
 
+	filenames := paths(page.Entries)
+
 	if got, want := len(filenames), 2; got != want {
 		t.Fatalf("got %d, want %d", got, want)
 	}
@@ -84,3 +322,454 @@ func TestStorage_List(t *testing.T) {
 		t.Fatalf("got %q, want %q", got, want)
 	}
 }
+
+func TestStorage_Nested(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	if err := storage.Mkdir(context.Background(), user, "empty/dir"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range []string{"a/b/" + fileName, "a/" + file2Name, fileName} {
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: v}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(user.DataDir, ".user"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err := storage.Download(context.Background(), user, "a/b/"+fileName, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := dst.String(), fileContent; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		dir       string
+		recursive bool
+		want      []string
+	}{
+		{dir: "", recursive: false, want: []string{"a/", "empty/", fileName}},
+		{dir: "a", recursive: false, want: []string{"b/", file2Name}},
+		{dir: "", recursive: true, want: []string{"a/", "a/b/", "a/b/" + fileName, "a/" + file2Name, "empty/", "empty/dir/", fileName}},
+		{dir: "a/", recursive: true, want: []string{"b/", "b/" + fileName, file2Name}},
+	}
+
+	for _, tc := range tests {
+		page, err := storage.List(context.Background(), user, tc.dir, ListOptions{Recursive: tc.recursive})
+		if err != nil {
+			t.Fatalf("List(%q, %v) error = %v", tc.dir, tc.recursive, err)
+		}
+
+		got := paths(page.Entries)
+
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Fatalf("List(%q, %v) = %q, want %q", tc.dir, tc.recursive, got, tc.want)
+		}
+	}
+
+	if _, err := storage.List(context.Background(), user, fileName, ListOptions{Recursive: false}); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("List() of a file error = %v, want %v", err, ErrInvalidPath)
+	}
+
+	if _, err := storage.Download(context.Background(), user, "a", ByteRange{}, &strings.Builder{}); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("Download() of a directory error = %v, want %v", err, ErrInvalidPath)
+	}
+}
+
+func TestStorage_InvalidPaths(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+
+	storage := NewStorage(blob.NewLocalStore(root), nil, NewKeyLocks(), testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	user := User{
+		Username: "user",
+		DataDir:  filepath.Join(root, "user"),
+		key:      testKey,
+	}
+
+	for _, dir := range []string{user.DataDir, filepath.Join(root, "other")} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Symlink(filepath.Join(root, "other"), filepath.Join(user.DataDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", "../other/x", "a/../../other/x", "/etc/passwd", ".user", "a/.hidden", "link/x", "link", "a\\b"} {
+		_, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader(fileContent))
+		if !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("Upload(%q) error = %v, want %v", name, err, ErrInvalidPath)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(root, "other"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("got %d files outside of the user data dir", len(entries))
+	}
+
+	if _, err = storage.List(context.Background(), user, "link", ListOptions{Recursive: false}); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("List() of a symbolic link error = %v, want %v", err, ErrInvalidPath)
+	}
+}
+
+func TestStorage_Delete(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: "a/" + fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Delete(context.Background(), user, "a"); !errors.Is(err, ErrNotEmpty) {
+		t.Fatalf("Delete() of a non-empty directory error = %v, want %v", err, ErrNotEmpty)
+	}
+
+	if err := storage.Delete(context.Background(), user, "a/"+fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Delete(context.Background(), user, "a/"+fileName); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Delete() of a missing file error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	if err := storage.Delete(context.Background(), user, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Delete(context.Background(), user, ""); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("Delete() of the root error = %v, want %v", err, ErrInvalidPath)
+	}
+
+	page, err := storage.List(context.Background(), user, "", ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(page.Entries), 0; got != want {
+		t.Fatalf("got %d, want %d", got, want)
+	}
+}
+
+func TestStorage_RenameCopy(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	for _, v := range []string{fileName, file2Name} {
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: v}, strings.NewReader(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		op        func(ctx context.Context, user User, src, dst string, overwrite bool) error
+		src, dst  string
+		overwrite bool
+		wantErr   error
+		want      string
+	}{
+		{name: "copy", op: storage.Copy, src: fileName, dst: "a/copy.txt", want: fileName},
+		{name: "copy existing", op: storage.Copy, src: fileName, dst: file2Name, wantErr: os.ErrExist},
+		{name: "copy overwrite", op: storage.Copy, src: file2Name, dst: "a/copy.txt", overwrite: true, want: file2Name},
+		{name: "copy missing", op: storage.Copy, src: "missing", dst: "b", wantErr: os.ErrNotExist},
+		{name: "copy directory", op: storage.Copy, src: "a", dst: "b", wantErr: ErrInvalidPath},
+		{name: "copy onto directory", op: storage.Copy, src: fileName, dst: "a", overwrite: true, wantErr: os.ErrExist},
+		{name: "rename existing", op: storage.Rename, src: fileName, dst: file2Name, wantErr: os.ErrExist},
+		{name: "rename missing", op: storage.Rename, src: "missing", dst: "b", wantErr: os.ErrNotExist},
+		{name: "rename invalid", op: storage.Rename, src: fileName, dst: "../x", wantErr: ErrInvalidPath},
+		{name: "rename", op: storage.Rename, src: fileName, dst: "b/renamed.txt", want: fileName},
+		{name: "rename overwrite", op: storage.Rename, src: file2Name, dst: "b/renamed.txt", overwrite: true, want: file2Name},
+		{name: "rename directory", op: storage.Rename, src: "b", dst: "c/b", want: ""},
+	}
+
+	for _, tc := range tests {
+		err := tc.op(context.Background(), user, tc.src, tc.dst, tc.overwrite)
+		if tc.wantErr != nil {
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("%s: got %v, want %v", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if tc.want == "" {
+			continue
+		}
+
+		dst := &strings.Builder{}
+
+		if _, err = storage.Download(context.Background(), user, tc.dst, ByteRange{}, dst); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if got := dst.String(); got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	page, err := storage.List(context.Background(), user, "", ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filenames := paths(page.Entries)
+
+	want := []string{"a/", "a/copy.txt", "c/", "c/b/", "c/b/renamed.txt"}
+
+	if strings.Join(filenames, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %q, want %q", filenames, want)
+	}
+}
+
+func TestStorage_MemoryStore(t *testing.T) {
+	t.Parallel()
+
+	storage := NewStorage(blob.NewMemoryStore(), nil, NewKeyLocks(), testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	user := User{
+		Username: "user",
+		DataDir:  t.TempDir(),
+		key:      testKey,
+	}
+
+	if err := storage.Mkdir(context.Background(), user, "empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: "a/" + fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Rename(context.Background(), user, "a", "b/a", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Rename(context.Background(), user, "b", "b/c", false); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("Rename() of a directory into itself error = %v, want %v", err, ErrInvalidPath)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err := storage.Download(context.Background(), user, "b/a/"+fileName, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := dst.String(), fileContent; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if err := storage.Delete(context.Background(), user, "b/a/"+fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := storage.List(context.Background(), user, "", ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories stay after their last file is removed.
+	if got, want := paths(page.Entries), []string{"b/", "b/a/", "empty/"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestStorage_Stat(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	metadata := FileMetadata{Filename: "a/" + fileName, ContentType: "text/plain"}
+
+	result, err := storage.Upload(context.Background(), user, metadata, strings.NewReader(fileContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Copy(context.Background(), user, "a/"+fileName, file2Name, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Rename(context.Background(), user, "a/"+fileName, "b/"+fileName, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"b/" + fileName, file2Name} {
+		info, err := storage.Stat(context.Background(), user, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := os.Stat(filepath.Join(user.DataDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case info.Path != name:
+			t.Fatalf("got path %q, want %q", info.Path, name)
+		case info.IsDir:
+			t.Fatalf("got directory, want file %q", name)
+		case info.Size != int64(len(fileContent)):
+			t.Fatalf("got size %d, want %d", info.Size, len(fileContent))
+		case info.StoredSize != stored.Size():
+			t.Fatalf("got stored size %d, want %d", info.StoredSize, stored.Size())
+		case !bytes.Equal(info.Digest, result.Digest):
+			t.Fatalf("got digest %x, want %x", info.Digest, result.Digest)
+		case info.ContentType != metadata.ContentType:
+			t.Fatalf("got content type %q, want %q", info.ContentType, metadata.ContentType)
+		case info.Created.IsZero() || info.Modified.IsZero():
+			t.Fatalf("got zero timestamps for %q", name)
+		}
+	}
+
+	info, err := storage.Stat(context.Background(), user, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.IsDir {
+		t.Fatal("got file, want directory")
+	}
+
+	if _, err = storage.Stat(context.Background(), user, "a/"+fileName); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	if _, err = storage.store.Stat(metadataKey(user.Username + "/a/" + fileName)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want metadata record to be moved", err)
+	}
+}
+
+func TestStorage_Stat_WithoutMetadata(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	for _, v := range []string{fileName, file2Name} {
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: v}, strings.NewReader(fileContent)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := user.Username + "/" + fileName
+
+	if err := storage.store.Delete(metadataKey(key)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A record left over from another file is encrypted with another data key.
+	if err := moveMetadata(storage.store, user.Username+"/"+file2Name, key); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := storage.Stat(context.Background(), user, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := info.Size, int64(unknownSize); got != want {
+		t.Fatalf("got size %d, want %d", got, want)
+	}
+
+	if info.Digest != nil {
+		t.Fatalf("got digest %x, want none", info.Digest)
+	}
+}
+
+// paths returns the listed paths, paths of directories end with a slash.
+func paths(entries []FileInfo) []string {
+	paths := make([]string, len(entries))
+
+	for i, entry := range entries {
+		paths[i] = entry.Path
+		if entry.IsDir {
+			paths[i] += "/"
+		}
+	}
+
+	return paths
+}
+
+func TestStorage_Download_Range(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	content := strings.Repeat("0123456789", 20000)
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := cipheraes.NewCipher(user.Key())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iv, err := rand.Key(block.BlockSize())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := make([]byte, len(content))
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(legacy, []byte(content))
+
+	if err = os.WriteFile(filepath.Join(user.DataDir, file2Name), append(iv, legacy...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(content))
+
+	tests := []struct {
+		byteRange ByteRange
+		want      string
+	}{
+		{byteRange: ByteRange{Offset: 1}, want: content[1:]},
+		{byteRange: ByteRange{Offset: 70000, Length: 10}, want: content[70000:70010]},
+		{byteRange: ByteRange{Offset: 65530, Length: 100}, want: content[65530:65630]},
+		{byteRange: ByteRange{Offset: size - 5, Length: 100}, want: content[size-5:]},
+		{byteRange: ByteRange{Offset: size}, want: ""},
+	}
+
+	for _, filename := range []string{fileName, file2Name} {
+		for _, tc := range tests {
+			dst := &strings.Builder{}
+
+			if _, err = storage.Download(context.Background(), user, filename, tc.byteRange, dst); err != nil {
+				t.Fatalf("%s %+v: %v", filename, tc.byteRange, err)
+			}
+
+			if got := dst.String(); got != tc.want {
+				t.Fatalf("%s %+v: got %d bytes, want %d", filename, tc.byteRange, len(got), len(tc.want))
+			}
+		}
+
+		for _, byteRange := range []ByteRange{{Offset: size + 1}, {Offset: -1}, {Length: -1}} {
+			if _, err = storage.Download(context.Background(), user, filename, byteRange, &strings.Builder{}); !errors.Is(err, ErrInvalidRange) {
+				t.Fatalf("%s %+v: got %v, want %v", filename, byteRange, err, ErrInvalidRange)
+			}
+		}
+	}
+}
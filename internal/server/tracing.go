@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"io"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+type (
+	// tracedStore traces the calls of the blob store as children of the span in ctx.
+	tracedStore struct {
+		store blob.Store
+		ctx   context.Context
+	}
+
+	// tracedPatcher is a tracedStore of a store that can patch blobs in place,
+	// so callers checking for blob.Patcher see the capability of the underlying store.
+	tracedPatcher struct {
+		tracedStore
+	}
+)
+
+// withTracing returns the store with its calls traced as children of the span in ctx.
+// A store traced already is traced with the new context instead.
+func withTracing(ctx context.Context, store blob.Store) blob.Store {
+	switch traced := store.(type) {
+	case tracedStore:
+		store = traced.store
+	case tracedPatcher:
+		store = traced.store
+	}
+
+	traced := tracedStore{store: store, ctx: ctx}
+
+	if _, ok := store.(blob.Patcher); ok {
+		return tracedPatcher{traced}
+	}
+
+	return traced
+}
+
+// traced returns a copy of the storage whose blob calls are traced as children of the span in ctx.
+func (s Storage) traced(ctx context.Context) Storage {
+	s.store.store = withTracing(ctx, s.store.store)
+	return s
+}
+
+// traced returns a copy of the authenticator whose blob calls are traced as children of the span in ctx.
+func (a Authenticator) traced(ctx context.Context) Authenticator {
+	a.store = withTracing(ctx, a.store)
+	return a
+}
+
+func (s tracedStore) Put(key string, src io.Reader) error {
+	defer s.span("blob.Put", key).End()
+	return s.store.Put(key, src)
+}
+
+func (s tracedStore) Get(key string) (blob.Blob, error) {
+	defer s.span("blob.Get", key).End()
+	return s.store.Get(key)
+}
+
+func (s tracedStore) Delete(key string) error {
+	defer s.span("blob.Delete", key).End()
+	return s.store.Delete(key)
+}
+
+func (s tracedStore) List(prefix string) ([]blob.Info, error) {
+	defer s.span("blob.List", prefix).End()
+	return s.store.List(prefix)
+}
+
+func (s tracedStore) Stat(key string) (blob.Info, error) {
+	defer s.span("blob.Stat", key).End()
+	return s.store.Stat(key)
+}
+
+func (s tracedStore) Move(src, dst string) error {
+	defer s.span("blob.Move", src).End()
+	return blob.Move(s.store, src, dst)
+}
+
+func (s tracedPatcher) Patch(key string, offset int64, data []byte) error {
+	defer s.span("blob.Patch", key).End()
+	return blob.Patch(s.store, key, offset, data)
+}
+
+func (s tracedStore) span(name, key string) *trace.Span {
+	_, span := trace.Start(s.ctx, name)
+	span.SetAttribute("key", key)
+	return span
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/KirillMironov/beaver/internal/log/observer"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (e *recordingExporter) Export(span trace.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.spans = append(e.spans, span)
+
+	return nil
+}
+
+func TestStorage_Tracing(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	exporter := &recordingExporter{}
+
+	ctx, root := trace.NewTracer(exporter, observer.New()).Start(context.Background(), "rpc")
+
+	if _, err := storage.Upload(ctx, user, FileMetadata{Filename: fileName}, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Download(ctx, user, fileName, ByteRange{}, &strings.Builder{}); err != nil {
+		t.Fatal(err)
+	}
+
+	root.End()
+
+	spans := make(map[string]trace.SpanData)
+	for _, span := range exporter.spans {
+		spans[span.SpanID] = span
+	}
+
+	// got holds the spans under the storage operation they descend from.
+	got := make(map[string]bool)
+
+	for _, span := range exporter.spans {
+		if span.TraceID != exporter.spans[len(exporter.spans)-1].TraceID {
+			t.Fatalf("span %q belongs to another trace", span.Name)
+		}
+
+		for parent, ok := spans[span.ParentID]; ok; parent, ok = spans[parent.ParentID] {
+			if strings.HasPrefix(parent.Name, "storage.") {
+				got[parent.Name+" > "+span.Name] = true
+				break
+			}
+		}
+	}
+
+	for _, want := range []string{
+		"storage.Upload > aes.encrypt",
+		"storage.Upload > blob.Put",
+		"storage.Download > aes.decrypt",
+		"storage.Download > blob.Get",
+	} {
+		if !got[want] {
+			t.Fatalf("got spans %v, want %q", got, want)
+		}
+	}
+}
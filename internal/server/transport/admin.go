@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/log"
+	"github.com/KirillMironov/beaver/internal/server"
+	"github.com/KirillMironov/beaver/internal/server/transport/proto"
+)
+
+type AdminService struct {
+	admin  Admin
+	logger log.Logger
+}
+
+type Admin interface {
+	RotateMasterKey(ctx context.Context, masterKey string) (newMasterKey string, err error)
+	SetQuota(ctx context.Context, masterKey, username string, quota *server.Quota) error
+	QueryAudit(ctx context.Context, masterKey string, filter audit.Filter) ([]audit.Entry, error)
+}
+
+func NewAdminService(admin Admin, logger log.Logger) *AdminService {
+	return &AdminService{
+		admin:  admin,
+		logger: logger,
+	}
+}
+
+// RotateMasterKey returns the new master key in the response, the only place it is ever sent.
+// The server may be served without TLS, so the key is only sent over TLS or to a client on the same host.
+func (a AdminService) RotateMasterKey(ctx context.Context, request *proto.RotateMasterKeyRequest) (*proto.RotateMasterKeyResponse, error) {
+	if !privatePeer(ctx) {
+		return nil, status.Error(codes.PermissionDenied, "master key can only be rotated over TLS or from the server host")
+	}
+
+	masterKey, err := a.admin.RotateMasterKey(ctx, request.GetMasterKey())
+	if err != nil {
+		return nil, a.statusError(err, "failed to rotate master key")
+	}
+
+	return &proto.RotateMasterKeyResponse{MasterKey: masterKey}, nil
+}
+
+func (a AdminService) SetQuota(ctx context.Context, request *proto.SetQuotaRequest) (*emptypb.Empty, error) {
+	var quota *server.Quota
+
+	if !request.GetUseDefault() {
+		quota = &server.Quota{MaxBytes: request.GetMaxBytes(), MaxFiles: request.GetMaxFiles()}
+	}
+
+	if err := a.admin.SetQuota(ctx, request.GetMasterKey(), request.GetUsername(), quota); err != nil {
+		return nil, a.statusError(err, "failed to set quota")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (a AdminService) QueryAudit(ctx context.Context, request *proto.QueryAuditRequest) (*proto.QueryAuditResponse, error) {
+	filter := audit.Filter{
+		Username: request.GetUsername(),
+		Action:   request.GetAction(),
+		Limit:    int(request.GetLimit()),
+	}
+
+	if request.GetSince() != nil {
+		filter.Since = request.GetSince().AsTime()
+	}
+
+	if request.GetUntil() != nil {
+		filter.Until = request.GetUntil().AsTime()
+	}
+
+	entries, err := a.admin.QueryAudit(ctx, request.GetMasterKey(), filter)
+	if err != nil {
+		return nil, a.statusError(err, "failed to query audit log")
+	}
+
+	response := &proto.QueryAuditResponse{
+		Entries: make([]*proto.AuditEntry, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		response.Entries = append(response.Entries, &proto.AuditEntry{
+			Time:     timestamppb.New(entry.Time),
+			Action:   entry.Action,
+			Username: entry.Username,
+			Actor:    entry.Actor,
+			Path:     entry.Path,
+			Target:   entry.Target,
+			Peer:     entry.Peer,
+			Result:   entry.Result,
+			Hash:     entry.Hash,
+		})
+	}
+
+	return response, nil
+}
+
+// privatePeer reports whether the connection of the request uses TLS or comes from the loopback interface
+// or a Unix socket, so what is sent over it can't be read by others on the network.
+func privatePeer(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+
+	if _, ok = p.AuthInfo.(credentials.TLSInfo); ok {
+		return true
+	}
+
+	switch addr := p.Addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	default:
+		return false
+	}
+}
+
+// statusError converts an admin error into a gRPC status.
+// Unexpected errors are logged and reported without details.
+func (a AdminService) statusError(err error, message string) error {
+	switch {
+	case errors.Is(err, server.ErrNotEnoughParams), errors.Is(err, server.ErrInvalidUsername):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, server.ErrInvalidMasterKey):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, server.ErrUserNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	default:
+		a.logger.Errorf("%s: %v", message, err)
+		return status.Error(codes.Internal, "")
+	}
+}
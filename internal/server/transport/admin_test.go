@@ -0,0 +1,36 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+func TestPrivatePeer(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		peer *peer.Peer
+		want bool
+	}{
+		{name: "loopback", peer: &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}}, want: true},
+		{name: "ipv6 loopback", peer: &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 1}}, want: true},
+		{name: "unix socket", peer: &peer.Peer{Addr: &net.UnixAddr{Name: "beaver.sock", Net: "unix"}}, want: true},
+		{name: "remote", peer: &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}}, want: false},
+		{name: "remote over tls", peer: &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}, AuthInfo: credentials.TLSInfo{}}, want: true},
+	}
+
+	for _, tc := range tests {
+		if got := privatePeer(peer.NewContext(context.Background(), tc.peer)); got != tc.want {
+			t.Fatalf("%s: got %t, want %t", tc.name, got, tc.want)
+		}
+	}
+
+	if privatePeer(context.Background()) {
+		t.Fatal("got a private peer without a peer")
+	}
+}
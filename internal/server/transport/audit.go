@@ -0,0 +1,298 @@
+package transport
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/log"
+	"github.com/KirillMironov/beaver/internal/server/transport/proto"
+)
+
+// Requests rejected before the client is authenticated are recorded in the audit log
+// up to rejectionBurst at once and one more every rejectionInterval.
+const (
+	rejectionInterval = time.Second
+	rejectionBurst    = 10
+)
+
+// Auditing records the outcome of every RPC in the audit log along with the address of the peer.
+// It runs after Authentication, so the entry names the authenticated user, or the user named by the request
+// of a public method. The file and the target of the action are taken from the request, or from the first
+// message of a client stream. Handlers fill in what is only known once the RPC is served with auditEntry.
+// Failed public RPCs are sampled, as anyone can make them.
+type Auditing struct {
+	auditor auditor
+}
+
+type auditEntryKey struct{}
+
+func NewAuditing(auditLog audit.Logger, logger log.Logger) *Auditing {
+	return &Auditing{
+		auditor: newAuditor(auditLog, logger),
+	}
+}
+
+func (a *Auditing) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		entry := newAuditEntry(info.FullMethod)
+		describeRequest(entry, request)
+
+		user, authenticated := authenticatedUser(ctx)
+		if authenticated {
+			entry.Username = user
+		}
+
+		response, err := handler(context.WithValue(ctx, auditEntryKey{}, entry), request)
+
+		a.record(ctx, *entry, authenticated, err)
+
+		return response, err
+	}
+}
+
+func (a *Auditing) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		entry := newAuditEntry(info.FullMethod)
+
+		user, authenticated := authenticatedUser(stream.Context())
+		if authenticated {
+			entry.Username = user
+		}
+
+		err := handler(server, &auditedStream{
+			ServerStream: stream,
+			ctx:          context.WithValue(stream.Context(), auditEntryKey{}, entry),
+			entry:        entry,
+		})
+
+		a.record(stream.Context(), *entry, authenticated, err)
+
+		return err
+	}
+}
+
+func (a *Auditing) record(ctx context.Context, entry audit.Entry, authenticated bool, err error) {
+	if err != nil && !authenticated {
+		a.auditor.recordRejection(ctx, entry, err)
+		return
+	}
+
+	a.auditor.record(ctx, entry, err)
+}
+
+// newAuditEntry returns the entry of the RPC, the action is the name of the method in snake case.
+func newAuditEntry(method string) *audit.Entry {
+	return &audit.Entry{Action: actionName(method)}
+}
+
+// authenticatedUser returns the name of the user authenticated by the token of the request.
+func authenticatedUser(ctx context.Context) (string, bool) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return "", false
+	}
+
+	return user.Username, true
+}
+
+// auditEntry returns the entry recorded for the RPC served with the context,
+// or an entry that isn't recorded if the RPC isn't audited.
+func auditEntry(ctx context.Context) *audit.Entry {
+	if entry, ok := ctx.Value(auditEntryKey{}).(*audit.Entry); ok {
+		return entry
+	}
+
+	return &audit.Entry{}
+}
+
+func actionName(method string) string {
+	name := method[strings.LastIndexByte(method, '/')+1:]
+
+	var action strings.Builder
+
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				action.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		action.WriteRune(r)
+	}
+
+	return action.String()
+}
+
+// describeRequest fills in the entry from the fields of the request. The path is the file the action applies to,
+// the target is the destination of a rename or copy, the recipient of a share, the owner of a shared file
+// or the id of a version or an upload session. A request carrying the master key is made by an administrator.
+// Credentials are never recorded.
+func describeRequest(entry *audit.Entry, request any) {
+	if r, ok := request.(interface{ GetUsername() string }); ok {
+		entry.Username = r.GetUsername()
+	}
+
+	if r, ok := request.(interface{ GetMasterKey() string }); ok && r.GetMasterKey() != "" {
+		entry.Actor = "master key"
+	}
+
+	switch r := request.(type) {
+	case interface{ GetPath() string }:
+		entry.Path = r.GetPath()
+	case interface{ GetFilename() string }:
+		entry.Path = r.GetFilename()
+	case interface{ GetSource() string }:
+		entry.Path = r.GetSource()
+	case interface{ GetMetadata() *proto.FileMetadata }:
+		entry.Path = r.GetMetadata().GetFilename()
+	}
+
+	switch r := request.(type) {
+	case interface{ GetDestination() string }:
+		entry.Target = r.GetDestination()
+	case interface{ GetRecipient() string }:
+		entry.Target = r.GetRecipient()
+	case interface{ GetOwner() string }:
+		entry.Target = r.GetOwner()
+	case interface{ GetVersionId() string }:
+		entry.Target = r.GetVersionId()
+	case interface{ GetUploadId() string }:
+		entry.Target = r.GetUploadId()
+	case interface {
+		GetPosition() *proto.UploadPosition
+	}:
+		entry.Target = r.GetPosition().GetUploadId()
+	}
+}
+
+// auditedStream passes the context holding the entry to the handler and describes the entry
+// with the first message received from the client. Later messages may be received by other goroutines.
+type auditedStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	entry *audit.Entry
+	once  sync.Once
+}
+
+func (s *auditedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *auditedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.once.Do(func() { describeRequest(s.entry, m) })
+	}
+
+	return err
+}
+
+// auditor records entries in the audit log along with the address of the peer.
+// The user acts on its own behalf unless the entry names another actor.
+type auditor struct {
+	log        audit.Logger
+	logger     log.Logger
+	rejections *rejectionSampler
+}
+
+func newAuditor(auditLog audit.Logger, logger log.Logger) auditor {
+	return auditor{
+		log:        auditLog,
+		logger:     logger,
+		rejections: newRejectionSampler(rejectionInterval, rejectionBurst),
+	}
+}
+
+func (a auditor) record(ctx context.Context, entry audit.Entry, err error) {
+	if entry.Actor == "" {
+		entry.Actor = entry.Username
+	}
+
+	if p, ok := peer.FromContext(ctx); ok {
+		entry.Peer = p.Addr.String()
+	}
+
+	entry.Result = status.Code(err).String()
+
+	if err = a.log.Record(entry); err != nil {
+		a.logger.Errorf("failed to record %s: %v", entry.Action, err)
+	}
+}
+
+// recordRejection records the entry of a request rejected before the client was authenticated,
+// unless too many were recorded recently. The rejections that weren't recorded are reported to the log
+// along with the next recorded one.
+func (a auditor) recordRejection(ctx context.Context, entry audit.Entry, err error) {
+	record, suppressed := a.rejections.sample()
+	if !record {
+		return
+	}
+
+	if suppressed > 0 {
+		a.logger.Infof("%d rejected requests were not recorded in the audit log", suppressed)
+	}
+
+	a.record(ctx, entry, err)
+}
+
+// rejectionSampler limits how many rejected requests are recorded, as every entry is flushed to disk
+// and unauthenticated clients could otherwise make the server write as fast as they can send requests.
+// Up to burst rejections are recorded at once and one more every interval, the others are only counted.
+type rejectionSampler struct {
+	interval   time.Duration
+	burst      int
+	now        func() time.Time
+	mu         sync.Mutex
+	tokens     int
+	last       time.Time
+	suppressed int
+}
+
+func newRejectionSampler(interval time.Duration, burst int) *rejectionSampler {
+	return &rejectionSampler{
+		interval: interval,
+		burst:    burst,
+		now:      time.Now,
+		tokens:   burst,
+	}
+}
+
+// sample reports whether the rejection is recorded, along with the number of rejections
+// that weren't recorded since the last recorded one.
+func (s *rejectionSampler) sample() (record bool, suppressed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	if s.last.IsZero() {
+		s.last = now
+	}
+
+	if refill := int(now.Sub(s.last) / s.interval); refill > 0 {
+		s.tokens += refill
+		if s.tokens > s.burst {
+			s.tokens = s.burst
+		}
+		s.last = s.last.Add(time.Duration(refill) * s.interval)
+	}
+
+	if s.tokens == 0 {
+		s.suppressed++
+		return false, 0
+	}
+
+	s.tokens--
+
+	suppressed, s.suppressed = s.suppressed, 0
+
+	return true, suppressed
+}
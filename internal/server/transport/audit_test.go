@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/server/transport/proto"
+)
+
+func TestDescribeRequest(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		method  string
+		request any
+		want    audit.Entry
+	}{
+		{
+			method:  "/proto.Storage/Upload",
+			request: &proto.UploadRequest{Data: &proto.UploadRequest_Metadata{Metadata: &proto.FileMetadata{Filename: "a/b"}}},
+			want:    audit.Entry{Action: "upload", Path: "a/b"},
+		},
+		{
+			method:  "/proto.Storage/AppendUpload",
+			request: &proto.AppendUploadRequest{Data: &proto.AppendUploadRequest_Position{Position: &proto.UploadPosition{UploadId: "id"}}},
+			want:    audit.Entry{Action: "append_upload", Target: "id"},
+		},
+		{
+			method:  "/proto.Storage/Rename",
+			request: &proto.MoveRequest{Source: "a", Destination: "b"},
+			want:    audit.Entry{Action: "rename", Path: "a", Target: "b"},
+		},
+		{
+			method:  "/proto.Storage/RestoreVersion",
+			request: &proto.VersionRequest{Filename: "a", VersionId: "v"},
+			want:    audit.Entry{Action: "restore_version", Path: "a", Target: "v"},
+		},
+		{
+			method:  "/proto.Storage/DownloadSharedWithMe",
+			request: &proto.SharedFileRequest{Owner: "owner", Path: "a"},
+			want:    audit.Entry{Action: "download_shared_with_me", Path: "a", Target: "owner"},
+		},
+		{
+			method:  "/proto.Storage/DownloadShared",
+			request: &proto.DownloadSharedRequest{Token: "token", Passphrase: "passphrase"},
+			want:    audit.Entry{Action: "download_shared"},
+		},
+		{
+			method:  "/proto.Authenticator/DeleteUser",
+			request: &proto.DeleteUserRequest{Username: "user", Passphrase: "passphrase"},
+			want:    audit.Entry{Action: "delete_user", Username: "user"},
+		},
+		{
+			method:  "/proto.Authenticator/DeleteUser",
+			request: &proto.DeleteUserRequest{Username: "user", MasterKey: "key"},
+			want:    audit.Entry{Action: "delete_user", Username: "user", Actor: "master key"},
+		},
+		{
+			method:  "/proto.Admin/SetQuota",
+			request: &proto.SetQuotaRequest{Username: "user", MasterKey: "key"},
+			want:    audit.Entry{Action: "set_quota", Username: "user", Actor: "master key"},
+		},
+	}
+
+	for _, tc := range tests {
+		entry := newAuditEntry(tc.method)
+		describeRequest(entry, tc.request)
+
+		if *entry != tc.want {
+			t.Fatalf("%s: got %+v, want %+v", tc.method, *entry, tc.want)
+		}
+	}
+}
+
+func TestRejectionSampler(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	sampler := newRejectionSampler(time.Second, 2)
+	sampler.now = func() time.Time { return now }
+
+	var recorded int
+
+	for i := 0; i < 5; i++ {
+		if record, _ := sampler.sample(); record {
+			recorded++
+		}
+	}
+
+	if recorded != 2 {
+		t.Fatalf("got %d rejections recorded at once, want 2", recorded)
+	}
+
+	now = now.Add(time.Second)
+
+	if record, suppressed := sampler.sample(); !record || suppressed != 3 {
+		t.Fatalf("got %t, %d after an interval, want the rejection recorded after 3 suppressed", record, suppressed)
+	}
+
+	if record, _ := sampler.sample(); record {
+		t.Fatal("got a rejection recorded before the next interval")
+	}
+}
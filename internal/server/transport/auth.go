@@ -2,9 +2,11 @@ package transport
 
 import (
 	"context"
+	"errors"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/KirillMironov/beaver/internal/log"
 	"github.com/KirillMironov/beaver/internal/server"
@@ -17,9 +19,11 @@ type AuthenticatorService struct {
 }
 
 type Authenticator interface {
-	AddUser(username, passphrase, masterKey string) (token string, err error)
-	Authenticate(username, passphrase string) (token string, err error)
-	ValidateToken(token string) (server.User, error)
+	AddUser(ctx context.Context, username, passphrase, masterKey string) (token string, err error)
+	Authenticate(ctx context.Context, username, passphrase string) (token string, err error)
+	ChangePassphrase(ctx context.Context, username, oldPassphrase, newPassphrase string) (token string, err error)
+	DeleteUser(ctx context.Context, username, passphrase, masterKey string) error
+	ValidateToken(ctx context.Context, token string) (server.User, error)
 }
 
 func NewAuthenticatorService(authenticator Authenticator, logger log.Logger) *AuthenticatorService {
@@ -29,22 +33,61 @@ func NewAuthenticatorService(authenticator Authenticator, logger log.Logger) *Au
 	}
 }
 
-func (a AuthenticatorService) AddUser(_ context.Context, request *proto.AddUserRequest) (*proto.Token, error) {
-	token, err := a.authenticator.AddUser(request.GetUsername(), request.GetPassphrase(), request.GetMasterKey())
+func (a AuthenticatorService) AddUser(ctx context.Context, request *proto.AddUserRequest) (*proto.Token, error) {
+	token, err := a.authenticator.AddUser(ctx, request.GetUsername(), request.GetPassphrase(), request.GetMasterKey())
 	if err != nil {
-		a.logger.Errorf("failed to add user: %v", err)
-		return nil, status.Error(codes.Internal, "")
+		return nil, a.statusError(err, "failed to add user")
 	}
 
 	return &proto.Token{Token: token}, nil
 }
 
-func (a AuthenticatorService) Authenticate(_ context.Context, request *proto.AuthenticateRequest) (*proto.Token, error) {
-	token, err := a.authenticator.Authenticate(request.GetUsername(), request.GetPassphrase())
+func (a AuthenticatorService) Authenticate(ctx context.Context, request *proto.AuthenticateRequest) (*proto.Token, error) {
+	token, err := a.authenticator.Authenticate(ctx, request.GetUsername(), request.GetPassphrase())
 	if err != nil {
-		a.logger.Errorf("failed to authenticate user: %v", err)
-		return nil, status.Error(codes.Internal, "")
+		return nil, a.statusError(err, "failed to authenticate user")
 	}
 
 	return &proto.Token{Token: token}, nil
 }
+
+func (a AuthenticatorService) ChangePassphrase(ctx context.Context, request *proto.ChangePassphraseRequest) (*proto.Token, error) {
+	token, err := a.authenticator.ChangePassphrase(ctx, request.GetUsername(), request.GetOldPassphrase(), request.GetNewPassphrase())
+	if err != nil {
+		return nil, a.statusError(err, "failed to change passphrase")
+	}
+
+	return &proto.Token{Token: token}, nil
+}
+
+func (a AuthenticatorService) DeleteUser(ctx context.Context, request *proto.DeleteUserRequest) (*emptypb.Empty, error) {
+	err := a.authenticator.DeleteUser(ctx, request.GetUsername(), request.GetPassphrase(), request.GetMasterKey())
+	if err != nil {
+		// Only administrators learn which users exist.
+		if request.GetMasterKey() != "" && errors.Is(err, server.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, a.statusError(err, "failed to delete user")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// statusError converts an authenticator error into a gRPC status.
+// An unknown user is reported like a wrong passphrase, so the response doesn't reveal which users exist.
+// Unexpected errors are logged and reported without details.
+func (a AuthenticatorService) statusError(err error, message string) error {
+	switch {
+	case errors.Is(err, server.ErrNotEnoughParams), errors.Is(err, server.ErrInvalidUsername):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, server.ErrInvalidPassphrase), errors.Is(err, server.ErrUserNotFound):
+		return status.Error(codes.Unauthenticated, "invalid username or passphrase")
+	case errors.Is(err, server.ErrInvalidMasterKey):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, server.ErrUserAlreadyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	default:
+		a.logger.Errorf("%s: %v", message, err)
+		return status.Error(codes.Internal, "")
+	}
+}
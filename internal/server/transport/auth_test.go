@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KirillMironov/beaver/internal/log/observer"
+	"github.com/KirillMironov/beaver/internal/server"
+)
+
+func TestAuthenticatorService_StatusError(t *testing.T) {
+	t.Parallel()
+
+	service := NewAuthenticatorService(nil, observer.New())
+
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{err: server.ErrInvalidPassphrase, want: codes.Unauthenticated},
+		{err: fmt.Errorf("wrapped: %w", server.ErrInvalidPassphrase), want: codes.Unauthenticated},
+		{err: server.ErrInvalidMasterKey, want: codes.Unauthenticated},
+		{err: server.ErrInvalidUsername, want: codes.InvalidArgument},
+		{err: server.ErrNotEnoughParams, want: codes.InvalidArgument},
+		{err: server.ErrUserAlreadyExists, want: codes.AlreadyExists},
+		{err: errors.New("disk failure"), want: codes.Internal},
+	}
+
+	for _, tc := range tests {
+		if got := status.Code(service.statusError(tc.err, "failed")); got != tc.want {
+			t.Fatalf("%v: got %v, want %v", tc.err, got, tc.want)
+		}
+	}
+
+	// An unknown user can't be told apart from a wrong passphrase.
+	unknown := status.Convert(service.statusError(server.ErrUserNotFound, "failed"))
+	wrong := status.Convert(service.statusError(server.ErrInvalidPassphrase, "failed"))
+
+	if unknown.Code() != wrong.Code() || unknown.Message() != wrong.Message() {
+		t.Fatalf("got %v for an unknown user, want %v", unknown, wrong)
+	}
+}
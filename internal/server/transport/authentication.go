@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/grpcutil"
+	"github.com/KirillMironov/beaver/internal/log"
+	"github.com/KirillMironov/beaver/internal/server"
+)
+
+// publicMethods are the RPCs that don't take a token. Users authenticate with their passphrase,
+// administrators with the master key and recipients of share links with the token of the link.
+var publicMethods = map[string]bool{
+	"/proto.Authenticator/AddUser":          true,
+	"/proto.Authenticator/Authenticate":     true,
+	"/proto.Authenticator/ChangePassphrase": true,
+	"/proto.Authenticator/DeleteUser":       true,
+	"/proto.Admin/RotateMasterKey":          true,
+	"/proto.Admin/SetQuota":                 true,
+	"/proto.Admin/QueryAudit":               true,
+	"/proto.Storage/DownloadShared":         true,
+}
+
+// Authentication validates the token in the "authorization" header of every RPC but the public ones
+// and passes the user to the handler in the context. Rejected tokens are sampled in the audit log.
+type Authentication struct {
+	authenticator Authenticator
+	auditor       auditor
+}
+
+type userKey struct{}
+
+func NewAuthentication(authenticator Authenticator, auditLog audit.Logger, logger log.Logger) *Authentication {
+	return &Authentication{
+		authenticator: authenticator,
+		auditor:       newAuditor(auditLog, logger),
+	}
+}
+
+func (a *Authentication) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if publicMethods[info.FullMethod] {
+			return handler(ctx, request)
+		}
+
+		ctx, err := a.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, request)
+	}
+}
+
+func (a *Authentication) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if publicMethods[info.FullMethod] {
+			return handler(server, stream)
+		}
+
+		ctx, err := a.authenticate(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+
+		return handler(server, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// authenticate returns the context holding the user the token of the request belongs to.
+func (a *Authentication) authenticate(ctx context.Context, method string) (context.Context, error) {
+	user, err := a.validateToken(ctx)
+	if err != nil {
+		a.auditor.recordRejection(ctx, audit.Entry{Action: "validate_token", Target: method}, err)
+		return nil, err
+	}
+
+	return context.WithValue(ctx, userKey{}, user), nil
+}
+
+func (a *Authentication) validateToken(ctx context.Context) (server.User, error) {
+	token := grpcutil.HeaderFromContext(ctx, authorizationHeader)
+	if token == "" {
+		return server.User{}, status.Error(codes.Unauthenticated, `provide token in "authorization" header`)
+	}
+
+	user, err := a.authenticator.ValidateToken(ctx, token)
+	if err != nil {
+		return server.User{}, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	return user, nil
+}
+
+// userFromContext returns the user authenticated by the interceptors.
+func userFromContext(ctx context.Context) (server.User, error) {
+	user, ok := ctx.Value(userKey{}).(server.User)
+	if !ok {
+		return server.User{}, status.Error(codes.Unauthenticated, "request is not authenticated")
+	}
+
+	return user, nil
+}
+
+// authenticatedStream passes the context holding the user to the handler.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,282 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/KirillMironov/beaver/internal/audit"
+	"github.com/KirillMironov/beaver/internal/log/observer"
+	"github.com/KirillMironov/beaver/internal/server"
+	"github.com/KirillMironov/beaver/internal/server/transport/proto"
+)
+
+const testToken = "token"
+
+func TestAuthentication(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		token string
+		call  func(ctx context.Context, conn *grpc.ClientConn) error
+		want  codes.Code
+	}{
+		{
+			name: "without token",
+			call: stat,
+			want: codes.Unauthenticated,
+		},
+		{
+			name:  "invalid token",
+			token: "invalid",
+			call:  stat,
+			want:  codes.Unauthenticated,
+		},
+		{
+			name: "stream without token",
+			call: listStream,
+			want: codes.Unauthenticated,
+		},
+		{
+			name:  "stream with invalid token",
+			token: "invalid",
+			call:  listStream,
+			want:  codes.Unauthenticated,
+		},
+		{
+			name:  "valid token",
+			token: testToken,
+			call:  stat,
+			want:  codes.OK,
+		},
+		{
+			name:  "stream with valid token",
+			token: testToken,
+			call:  listStream,
+			want:  codes.OK,
+		},
+		{
+			name: "share link",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				stream, err := proto.NewStorageClient(conn).DownloadShared(ctx, &proto.DownloadSharedRequest{Token: "link"})
+				if err != nil {
+					return err
+				}
+				return drain(stream.Recv)
+			},
+			want: codes.OK,
+		},
+		{
+			name: "authenticator",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := proto.NewAuthenticatorClient(conn).Authenticate(ctx, &proto.AuthenticateRequest{Username: "user", Passphrase: "passphrase"})
+				return err
+			},
+			want: codes.OK,
+		},
+		{
+			name: "master key rotation over an insecure connection",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := proto.NewAdminClient(conn).RotateMasterKey(ctx, &proto.RotateMasterKeyRequest{MasterKey: "key"})
+				return err
+			},
+			want: codes.PermissionDenied,
+		},
+		{
+			name: "admin",
+			call: func(ctx context.Context, conn *grpc.ClientConn) error {
+				_, err := proto.NewAdminClient(conn).QueryAudit(ctx, &proto.QueryAuditRequest{MasterKey: "key"})
+				return err
+			},
+			want: codes.OK,
+		},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, _ := newTestServer(t)
+
+			ctx := context.Background()
+			if tc.token != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, authorizationHeader, tc.token)
+			}
+
+			if got := status.Code(tc.call(ctx, conn)); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAuthentication_User(t *testing.T) {
+	t.Parallel()
+
+	conn, auditLog := newTestServer(t)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), authorizationHeader, testToken)
+
+	entry, err := proto.NewStorageClient(conn).Stat(ctx, &proto.StatRequest{Path: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake storage names the file after the user it was called for.
+	if got, want := entry.GetPath(), "user/a"; got != want {
+		t.Fatalf("got path %q, want %q", got, want)
+	}
+
+	want := audit.Entry{Action: "stat", Username: "user", Actor: "user", Path: "a", Result: codes.OK.String()}
+
+	if entries := auditLog.entries(); len(entries) != 1 || entries[0] != want {
+		t.Fatalf("got audit entries %+v, want %+v", entries, want)
+	}
+}
+
+func stat(ctx context.Context, conn *grpc.ClientConn) error {
+	_, err := proto.NewStorageClient(conn).Stat(ctx, &proto.StatRequest{Path: "a"})
+	return err
+}
+
+func listStream(ctx context.Context, conn *grpc.ClientConn) error {
+	stream, err := proto.NewStorageClient(conn).ListStream(ctx, &proto.ListRequest{})
+	if err != nil {
+		return err
+	}
+	return drain(stream.Recv)
+}
+
+// drain receives the messages of the stream until it ends.
+func drain[M any](recv func() (M, error)) error {
+	for {
+		if _, err := recv(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// newTestServer serves the services over an in-memory connection behind the interceptors of the server.
+func newTestServer(t *testing.T) (*grpc.ClientConn, *memoryAuditLog) {
+	t.Helper()
+
+	var (
+		logger         = observer.New()
+		auditLog       = &memoryAuditLog{}
+		authenticator  = fakeAuthenticator{}
+		authentication = NewAuthentication(authenticator, auditLog, logger)
+		auditing       = NewAuditing(auditLog, logger)
+		listener       = bufconn.Listen(1 << 20)
+	)
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authentication.UnaryInterceptor(), auditing.UnaryInterceptor()),
+		grpc.ChainStreamInterceptor(authentication.StreamInterceptor(), auditing.StreamInterceptor()),
+	)
+
+	proto.RegisterStorageServer(grpcServer, NewStorageService(fakeStorage{}, logger))
+	proto.RegisterAuthenticatorServer(grpcServer, NewAuthenticatorService(authenticator, logger))
+	proto.RegisterAdminServer(grpcServer, NewAdminService(fakeAdmin{}, logger))
+
+	go func() {
+		_ = grpcServer.Serve(listener)
+	}()
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn, auditLog
+}
+
+type fakeAuthenticator struct {
+	Authenticator
+}
+
+func (fakeAuthenticator) Authenticate(context.Context, string, string) (string, error) {
+	return testToken, nil
+}
+
+func (fakeAuthenticator) ValidateToken(_ context.Context, token string) (server.User, error) {
+	if token != testToken {
+		return server.User{}, errors.New("invalid token")
+	}
+
+	return server.User{Username: "user"}, nil
+}
+
+// fakeStorage serves the methods the tests call, the others panic.
+type fakeStorage struct {
+	Storage
+}
+
+func (fakeStorage) Stat(_ context.Context, user server.User, name string) (server.FileInfo, error) {
+	return server.FileInfo{Path: user.Username + "/" + name}, nil
+}
+
+func (fakeStorage) Walk(_ context.Context, user server.User, dir string, _ server.ListOptions, fn func(server.FileInfo) error) error {
+	return fn(server.FileInfo{Path: user.Username + "/" + dir})
+}
+
+func (fakeStorage) DownloadShared(context.Context, string, string, server.ByteRange, io.Writer) (server.DownloadResult, error) {
+	return server.DownloadResult{}, nil
+}
+
+type fakeAdmin struct {
+	Admin
+}
+
+func (fakeAdmin) QueryAudit(context.Context, string, audit.Filter) ([]audit.Entry, error) {
+	return nil, nil
+}
+
+// memoryAuditLog keeps the recorded entries without their peer.
+type memoryAuditLog struct {
+	recorded []audit.Entry
+	mu       sync.Mutex
+}
+
+func (l *memoryAuditLog) Record(entry audit.Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Peer = ""
+	l.recorded = append(l.recorded, entry)
+
+	return nil
+}
+
+func (l *memoryAuditLog) Query(audit.Filter) ([]audit.Entry, error) {
+	return l.entries(), nil
+}
+
+func (l *memoryAuditLog) entries() []audit.Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]audit.Entry(nil), l.recorded...)
+}
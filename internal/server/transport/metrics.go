@@ -0,0 +1,110 @@
+package transport
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"github.com/KirillMironov/beaver/internal/metrics"
+)
+
+// Metrics counts RPCs by method and code, measures their latency and the bytes of the messages they carry,
+// and tracks the streams in flight.
+type Metrics struct {
+	requests      *metrics.CounterVec
+	latency       *metrics.HistogramVec
+	receivedBytes *metrics.CounterVec
+	sentBytes     *metrics.CounterVec
+	streams       *metrics.Gauge
+}
+
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		requests: registry.NewCounterVec("beaver_grpc_requests_total",
+			"RPCs completed by method and gRPC code.", "method", "code"),
+		latency: registry.NewHistogramVec("beaver_grpc_request_duration_seconds",
+			"Time taken to complete RPCs.", metrics.DefaultBuckets, "method"),
+		receivedBytes: registry.NewCounterVec("beaver_grpc_received_bytes_total",
+			"Bytes of the messages received from clients.", "method"),
+		sentBytes: registry.NewCounterVec("beaver_grpc_sent_bytes_total",
+			"Bytes of the messages sent to clients.", "method"),
+		streams: registry.NewGauge("beaver_grpc_active_streams",
+			"Streaming RPCs in flight."),
+	}
+}
+
+func (m *Metrics) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+
+		m.receivedBytes.Add(messageSize(request), info.FullMethod)
+
+		response, err := handler(ctx, request)
+
+		if err == nil {
+			m.sentBytes.Add(messageSize(response), info.FullMethod)
+		}
+
+		m.observe(info.FullMethod, start, err)
+
+		return response, err
+	}
+}
+
+func (m *Metrics) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+
+		m.streams.Inc()
+		defer m.streams.Dec()
+
+		err := handler(server, &countingStream{ServerStream: stream, metrics: m, method: info.FullMethod})
+
+		m.observe(info.FullMethod, start, err)
+
+		return err
+	}
+}
+
+func (m *Metrics) observe(method string, start time.Time, err error) {
+	m.requests.Inc(method, status.Code(err).String())
+	m.latency.Observe(time.Since(start).Seconds(), method)
+}
+
+// countingStream counts the bytes of the messages of a stream.
+type countingStream struct {
+	grpc.ServerStream
+	metrics *Metrics
+	method  string
+}
+
+func (s *countingStream) SendMsg(message any) error {
+	if err := s.ServerStream.SendMsg(message); err != nil {
+		return err
+	}
+
+	s.metrics.sentBytes.Add(messageSize(message), s.method)
+
+	return nil
+}
+
+func (s *countingStream) RecvMsg(message any) error {
+	if err := s.ServerStream.RecvMsg(message); err != nil {
+		return err
+	}
+
+	s.metrics.receivedBytes.Add(messageSize(message), s.method)
+
+	return nil
+}
+
+func messageSize(message any) float64 {
+	if message, ok := message.(protobuf.Message); ok {
+		return float64(protobuf.Size(message))
+	}
+
+	return 0
+}
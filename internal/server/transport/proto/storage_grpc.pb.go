@@ -23,9 +23,33 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type StorageClient interface {
-	Upload(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (Storage_UploadClient, error)
+	Upload(ctx context.Context, opts ...grpc.CallOption) (Storage_UploadClient, error)
+	InitiateUpload(ctx context.Context, in *FileMetadata, opts ...grpc.CallOption) (*UploadSession, error)
+	AppendUpload(ctx context.Context, opts ...grpc.CallOption) (Storage_AppendUploadClient, error)
+	QueryUpload(ctx context.Context, in *UploadSessionRequest, opts ...grpc.CallOption) (*UploadSession, error)
+	CompleteUpload(ctx context.Context, in *UploadSessionRequest, opts ...grpc.CallOption) (*UploadResponse, error)
+	AbortUpload(ctx context.Context, in *UploadSessionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	Download(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (Storage_DownloadClient, error)
-	List(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListResponse, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	ListStream(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (Storage_ListStreamClient, error)
+	Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*Entry, error)
+	Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Rename(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Copy(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListVersions(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	DownloadVersion(ctx context.Context, in *VersionRequest, opts ...grpc.CallOption) (Storage_DownloadVersionClient, error)
+	RestoreVersion(ctx context.Context, in *VersionRequest, opts ...grpc.CallOption) (*Entry, error)
+	GetVersioning(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*VersioningPolicy, error)
+	SetVersioning(ctx context.Context, in *VersioningPolicy, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Usage(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*UsageResponse, error)
+	Share(ctx context.Context, in *ShareRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	Unshare(ctx context.Context, in *ShareRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ListSharedWithMe(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListSharedWithMeResponse, error)
+	DownloadSharedWithMe(ctx context.Context, in *SharedFileRequest, opts ...grpc.CallOption) (Storage_DownloadSharedWithMeClient, error)
+	CreateShareLink(ctx context.Context, in *CreateShareLinkRequest, opts ...grpc.CallOption) (*ShareLink, error)
+	// DownloadShared doesn't require the authorization metadata, the token of the link grants access.
+	DownloadShared(ctx context.Context, in *DownloadSharedRequest, opts ...grpc.CallOption) (Storage_DownloadSharedClient, error)
 }
 
 type storageClient struct {
@@ -36,40 +60,112 @@ func NewStorageClient(cc grpc.ClientConnInterface) StorageClient {
 	return &storageClient{cc}
 }
 
-func (c *storageClient) Upload(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (Storage_UploadClient, error) {
+func (c *storageClient) Upload(ctx context.Context, opts ...grpc.CallOption) (Storage_UploadClient, error) {
 	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[0], "/proto.Storage/Upload", opts...)
 	if err != nil {
 		return nil, err
 	}
 	x := &storageUploadClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
+	return x, nil
+}
+
+type Storage_UploadClient interface {
+	Send(*UploadRequest) error
+	CloseAndRecv() (*UploadResponse, error)
+	grpc.ClientStream
+}
+
+type storageUploadClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageUploadClient) Send(m *UploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *storageUploadClient) CloseAndRecv() (*UploadResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
 		return nil, err
 	}
-	if err := x.ClientStream.CloseSend(); err != nil {
+	m := new(UploadResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageClient) InitiateUpload(ctx context.Context, in *FileMetadata, opts ...grpc.CallOption) (*UploadSession, error) {
+	out := new(UploadSession)
+	err := c.cc.Invoke(ctx, "/proto.Storage/InitiateUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) AppendUpload(ctx context.Context, opts ...grpc.CallOption) (Storage_AppendUploadClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[1], "/proto.Storage/AppendUpload", opts...)
+	if err != nil {
 		return nil, err
 	}
+	x := &storageAppendUploadClient{stream}
 	return x, nil
 }
 
-type Storage_UploadClient interface {
-	Recv() (*File, error)
+type Storage_AppendUploadClient interface {
+	Send(*AppendUploadRequest) error
+	CloseAndRecv() (*UploadSession, error)
 	grpc.ClientStream
 }
 
-type storageUploadClient struct {
+type storageAppendUploadClient struct {
 	grpc.ClientStream
 }
 
-func (x *storageUploadClient) Recv() (*File, error) {
-	m := new(File)
+func (x *storageAppendUploadClient) Send(m *AppendUploadRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *storageAppendUploadClient) CloseAndRecv() (*UploadSession, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadSession)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
 	return m, nil
 }
 
+func (c *storageClient) QueryUpload(ctx context.Context, in *UploadSessionRequest, opts ...grpc.CallOption) (*UploadSession, error) {
+	out := new(UploadSession)
+	err := c.cc.Invoke(ctx, "/proto.Storage/QueryUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) CompleteUpload(ctx context.Context, in *UploadSessionRequest, opts ...grpc.CallOption) (*UploadResponse, error) {
+	out := new(UploadResponse)
+	err := c.cc.Invoke(ctx, "/proto.Storage/CompleteUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) AbortUpload(ctx context.Context, in *UploadSessionRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/AbortUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *storageClient) Download(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (Storage_DownloadClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[1], "/proto.Storage/Download", opts...)
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[2], "/proto.Storage/Download", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -100,7 +196,7 @@ func (x *storageDownloadClient) Recv() (*File, error) {
 	return m, nil
 }
 
-func (c *storageClient) List(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListResponse, error) {
+func (c *storageClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
 	out := new(ListResponse)
 	err := c.cc.Invoke(ctx, "/proto.Storage/List", in, out, opts...)
 	if err != nil {
@@ -109,121 +205,1004 @@ func (c *storageClient) List(ctx context.Context, in *emptypb.Empty, opts ...grp
 	return out, nil
 }
 
-// StorageServer is the server API for Storage service.
-// All implementations should embed UnimplementedStorageServer
-// for forward compatibility
-type StorageServer interface {
-	Upload(*FileRequest, Storage_UploadServer) error
-	Download(*FileRequest, Storage_DownloadServer) error
-	List(context.Context, *emptypb.Empty) (*ListResponse, error)
+func (c *storageClient) ListStream(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (Storage_ListStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[3], "/proto.Storage/ListStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageListStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-// UnimplementedStorageServer should be embedded to have forward compatible implementations.
-type UnimplementedStorageServer struct {
+type Storage_ListStreamClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
 }
 
-func (UnimplementedStorageServer) Upload(*FileRequest, Storage_UploadServer) error {
-	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+type storageListStreamClient struct {
+	grpc.ClientStream
 }
-func (UnimplementedStorageServer) Download(*FileRequest, Storage_DownloadServer) error {
-	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+
+func (x *storageListStreamClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
 }
-func (UnimplementedStorageServer) List(context.Context, *emptypb.Empty) (*ListResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+
+func (c *storageClient) Stat(ctx context.Context, in *StatRequest, opts ...grpc.CallOption) (*Entry, error) {
+	out := new(Entry)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Stat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-// UnsafeStorageServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to StorageServer will
-// result in compilation errors.
-type UnsafeStorageServer interface {
-	mustEmbedUnimplementedStorageServer()
+func (c *storageClient) Mkdir(ctx context.Context, in *MkdirRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Mkdir", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func RegisterStorageServer(s grpc.ServiceRegistrar, srv StorageServer) {
-	s.RegisterService(&Storage_ServiceDesc, srv)
+func (c *storageClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func _Storage_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(FileRequest)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
+func (c *storageClient) Rename(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Rename", in, out, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return srv.(StorageServer).Upload(m, &storageUploadServer{stream})
+	return out, nil
 }
 
-type Storage_UploadServer interface {
-	Send(*File) error
-	grpc.ServerStream
+func (c *storageClient) Copy(ctx context.Context, in *MoveRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Copy", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-type storageUploadServer struct {
-	grpc.ServerStream
+func (c *storageClient) ListVersions(ctx context.Context, in *FileRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, "/proto.Storage/ListVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (x *storageUploadServer) Send(m *File) error {
-	return x.ServerStream.SendMsg(m)
+func (c *storageClient) DownloadVersion(ctx context.Context, in *VersionRequest, opts ...grpc.CallOption) (Storage_DownloadVersionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[4], "/proto.Storage/DownloadVersion", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageDownloadVersionClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-func _Storage_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(FileRequest)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
+type Storage_DownloadVersionClient interface {
+	Recv() (*File, error)
+	grpc.ClientStream
+}
+
+type storageDownloadVersionClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageDownloadVersionClient) Recv() (*File, error) {
+	m := new(File)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
 	}
-	return srv.(StorageServer).Download(m, &storageDownloadServer{stream})
+	return m, nil
 }
 
-type Storage_DownloadServer interface {
-	Send(*File) error
-	grpc.ServerStream
+func (c *storageClient) RestoreVersion(ctx context.Context, in *VersionRequest, opts ...grpc.CallOption) (*Entry, error) {
+	out := new(Entry)
+	err := c.cc.Invoke(ctx, "/proto.Storage/RestoreVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-type storageDownloadServer struct {
-	grpc.ServerStream
+func (c *storageClient) GetVersioning(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*VersioningPolicy, error) {
+	out := new(VersioningPolicy)
+	err := c.cc.Invoke(ctx, "/proto.Storage/GetVersioning", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (x *storageDownloadServer) Send(m *File) error {
-	return x.ServerStream.SendMsg(m)
+func (c *storageClient) SetVersioning(ctx context.Context, in *VersioningPolicy, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/SetVersioning", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func _Storage_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(emptypb.Empty)
-	if err := dec(in); err != nil {
+func (c *storageClient) Usage(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*UsageResponse, error) {
+	out := new(UsageResponse)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Usage", in, out, opts...)
+	if err != nil {
 		return nil, err
 	}
-	if interceptor == nil {
-		return srv.(StorageServer).List(ctx, in)
+	return out, nil
+}
+
+func (c *storageClient) Share(ctx context.Context, in *ShareRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Share", in, out, opts...)
+	if err != nil {
+		return nil, err
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/proto.Storage/List",
+	return out, nil
+}
+
+func (c *storageClient) Unshare(ctx context.Context, in *ShareRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/proto.Storage/Unshare", in, out, opts...)
+	if err != nil {
+		return nil, err
 	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(StorageServer).List(ctx, req.(*emptypb.Empty))
+	return out, nil
+}
+
+func (c *storageClient) ListSharedWithMe(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*ListSharedWithMeResponse, error) {
+	out := new(ListSharedWithMeResponse)
+	err := c.cc.Invoke(ctx, "/proto.Storage/ListSharedWithMe", in, out, opts...)
+	if err != nil {
+		return nil, err
 	}
-	return interceptor(ctx, in, info, handler)
+	return out, nil
 }
 
-// Storage_ServiceDesc is the grpc.ServiceDesc for Storage service.
-// It's only intended for direct use with grpc.RegisterService,
-// and not to be introspected or modified (even as a copy)
-var Storage_ServiceDesc = grpc.ServiceDesc{
-	ServiceName: "proto.Storage",
-	HandlerType: (*StorageServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "List",
-			Handler:    _Storage_List_Handler,
-		},
-	},
-	Streams: []grpc.StreamDesc{
-		{
-			StreamName:    "Upload",
-			Handler:       _Storage_Upload_Handler,
-			ServerStreams: true,
-		},
-		{
-			StreamName:    "Download",
-			Handler:       _Storage_Download_Handler,
+func (c *storageClient) DownloadSharedWithMe(ctx context.Context, in *SharedFileRequest, opts ...grpc.CallOption) (Storage_DownloadSharedWithMeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[5], "/proto.Storage/DownloadSharedWithMe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageDownloadSharedWithMeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Storage_DownloadSharedWithMeClient interface {
+	Recv() (*File, error)
+	grpc.ClientStream
+}
+
+type storageDownloadSharedWithMeClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageDownloadSharedWithMeClient) Recv() (*File, error) {
+	m := new(File)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storageClient) CreateShareLink(ctx context.Context, in *CreateShareLinkRequest, opts ...grpc.CallOption) (*ShareLink, error) {
+	out := new(ShareLink)
+	err := c.cc.Invoke(ctx, "/proto.Storage/CreateShareLink", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storageClient) DownloadShared(ctx context.Context, in *DownloadSharedRequest, opts ...grpc.CallOption) (Storage_DownloadSharedClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Storage_ServiceDesc.Streams[6], "/proto.Storage/DownloadShared", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storageDownloadSharedClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Storage_DownloadSharedClient interface {
+	Recv() (*File, error)
+	grpc.ClientStream
+}
+
+type storageDownloadSharedClient struct {
+	grpc.ClientStream
+}
+
+func (x *storageDownloadSharedClient) Recv() (*File, error) {
+	m := new(File)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StorageServer is the server API for Storage service.
+// All implementations should embed UnimplementedStorageServer
+// for forward compatibility
+type StorageServer interface {
+	Upload(Storage_UploadServer) error
+	InitiateUpload(context.Context, *FileMetadata) (*UploadSession, error)
+	AppendUpload(Storage_AppendUploadServer) error
+	QueryUpload(context.Context, *UploadSessionRequest) (*UploadSession, error)
+	CompleteUpload(context.Context, *UploadSessionRequest) (*UploadResponse, error)
+	AbortUpload(context.Context, *UploadSessionRequest) (*emptypb.Empty, error)
+	Download(*FileRequest, Storage_DownloadServer) error
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	ListStream(*ListRequest, Storage_ListStreamServer) error
+	Stat(context.Context, *StatRequest) (*Entry, error)
+	Mkdir(context.Context, *MkdirRequest) (*emptypb.Empty, error)
+	Delete(context.Context, *DeleteRequest) (*emptypb.Empty, error)
+	Rename(context.Context, *MoveRequest) (*emptypb.Empty, error)
+	Copy(context.Context, *MoveRequest) (*emptypb.Empty, error)
+	ListVersions(context.Context, *FileRequest) (*ListVersionsResponse, error)
+	DownloadVersion(*VersionRequest, Storage_DownloadVersionServer) error
+	RestoreVersion(context.Context, *VersionRequest) (*Entry, error)
+	GetVersioning(context.Context, *emptypb.Empty) (*VersioningPolicy, error)
+	SetVersioning(context.Context, *VersioningPolicy) (*emptypb.Empty, error)
+	Usage(context.Context, *emptypb.Empty) (*UsageResponse, error)
+	Share(context.Context, *ShareRequest) (*emptypb.Empty, error)
+	Unshare(context.Context, *ShareRequest) (*emptypb.Empty, error)
+	ListSharedWithMe(context.Context, *emptypb.Empty) (*ListSharedWithMeResponse, error)
+	DownloadSharedWithMe(*SharedFileRequest, Storage_DownloadSharedWithMeServer) error
+	CreateShareLink(context.Context, *CreateShareLinkRequest) (*ShareLink, error)
+	// DownloadShared doesn't require the authorization metadata, the token of the link grants access.
+	DownloadShared(*DownloadSharedRequest, Storage_DownloadSharedServer) error
+}
+
+// UnimplementedStorageServer should be embedded to have forward compatible implementations.
+type UnimplementedStorageServer struct {
+}
+
+func (UnimplementedStorageServer) Upload(Storage_UploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedStorageServer) InitiateUpload(context.Context, *FileMetadata) (*UploadSession, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InitiateUpload not implemented")
+}
+func (UnimplementedStorageServer) AppendUpload(Storage_AppendUploadServer) error {
+	return status.Errorf(codes.Unimplemented, "method AppendUpload not implemented")
+}
+func (UnimplementedStorageServer) QueryUpload(context.Context, *UploadSessionRequest) (*UploadSession, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryUpload not implemented")
+}
+func (UnimplementedStorageServer) CompleteUpload(context.Context, *UploadSessionRequest) (*UploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompleteUpload not implemented")
+}
+func (UnimplementedStorageServer) AbortUpload(context.Context, *UploadSessionRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortUpload not implemented")
+}
+func (UnimplementedStorageServer) Download(*FileRequest, Storage_DownloadServer) error {
+	return status.Errorf(codes.Unimplemented, "method Download not implemented")
+}
+func (UnimplementedStorageServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedStorageServer) ListStream(*ListRequest, Storage_ListStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ListStream not implemented")
+}
+func (UnimplementedStorageServer) Stat(context.Context, *StatRequest) (*Entry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stat not implemented")
+}
+func (UnimplementedStorageServer) Mkdir(context.Context, *MkdirRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mkdir not implemented")
+}
+func (UnimplementedStorageServer) Delete(context.Context, *DeleteRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedStorageServer) Rename(context.Context, *MoveRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
+}
+func (UnimplementedStorageServer) Copy(context.Context, *MoveRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Copy not implemented")
+}
+func (UnimplementedStorageServer) ListVersions(context.Context, *FileRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedStorageServer) DownloadVersion(*VersionRequest, Storage_DownloadVersionServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadVersion not implemented")
+}
+func (UnimplementedStorageServer) RestoreVersion(context.Context, *VersionRequest) (*Entry, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedStorageServer) GetVersioning(context.Context, *emptypb.Empty) (*VersioningPolicy, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVersioning not implemented")
+}
+func (UnimplementedStorageServer) SetVersioning(context.Context, *VersioningPolicy) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetVersioning not implemented")
+}
+func (UnimplementedStorageServer) Usage(context.Context, *emptypb.Empty) (*UsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Usage not implemented")
+}
+func (UnimplementedStorageServer) Share(context.Context, *ShareRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Share not implemented")
+}
+func (UnimplementedStorageServer) Unshare(context.Context, *ShareRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unshare not implemented")
+}
+func (UnimplementedStorageServer) ListSharedWithMe(context.Context, *emptypb.Empty) (*ListSharedWithMeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListSharedWithMe not implemented")
+}
+func (UnimplementedStorageServer) DownloadSharedWithMe(*SharedFileRequest, Storage_DownloadSharedWithMeServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadSharedWithMe not implemented")
+}
+func (UnimplementedStorageServer) CreateShareLink(context.Context, *CreateShareLinkRequest) (*ShareLink, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateShareLink not implemented")
+}
+func (UnimplementedStorageServer) DownloadShared(*DownloadSharedRequest, Storage_DownloadSharedServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadShared not implemented")
+}
+
+// UnsafeStorageServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StorageServer will
+// result in compilation errors.
+type UnsafeStorageServer interface {
+	mustEmbedUnimplementedStorageServer()
+}
+
+func RegisterStorageServer(s grpc.ServiceRegistrar, srv StorageServer) {
+	s.RegisterService(&Storage_ServiceDesc, srv)
+}
+
+func _Storage_Upload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageServer).Upload(&storageUploadServer{stream})
+}
+
+type Storage_UploadServer interface {
+	SendAndClose(*UploadResponse) error
+	Recv() (*UploadRequest, error)
+	grpc.ServerStream
+}
+
+type storageUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageUploadServer) SendAndClose(m *UploadResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *storageUploadServer) Recv() (*UploadRequest, error) {
+	m := new(UploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Storage_InitiateUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileMetadata)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).InitiateUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/InitiateUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).InitiateUpload(ctx, req.(*FileMetadata))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_AppendUpload_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(StorageServer).AppendUpload(&storageAppendUploadServer{stream})
+}
+
+type Storage_AppendUploadServer interface {
+	SendAndClose(*UploadSession) error
+	Recv() (*AppendUploadRequest, error)
+	grpc.ServerStream
+}
+
+type storageAppendUploadServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageAppendUploadServer) SendAndClose(m *UploadSession) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *storageAppendUploadServer) Recv() (*AppendUploadRequest, error) {
+	m := new(AppendUploadRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Storage_QueryUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).QueryUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/QueryUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).QueryUpload(ctx, req.(*UploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_CompleteUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).CompleteUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/CompleteUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).CompleteUpload(ctx, req.(*UploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_AbortUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).AbortUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/AbortUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).AbortUpload(ctx, req.(*UploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Download_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(FileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).Download(m, &storageDownloadServer{stream})
+}
+
+type Storage_DownloadServer interface {
+	Send(*File) error
+	grpc.ServerStream
+}
+
+type storageDownloadServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageDownloadServer) Send(m *File) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Storage_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/List",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_ListStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).ListStream(m, &storageListStreamServer{stream})
+}
+
+type Storage_ListStreamServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type storageListStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageListStreamServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Storage_Stat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Stat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Stat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Stat(ctx, req.(*StatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Mkdir_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MkdirRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Mkdir(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Mkdir",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Mkdir(ctx, req.(*MkdirRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Rename(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Rename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Rename(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Copy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Copy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Copy",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Copy(ctx, req.(*MoveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/ListVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).ListVersions(ctx, req.(*FileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_DownloadVersion_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(VersionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).DownloadVersion(m, &storageDownloadVersionServer{stream})
+}
+
+type Storage_DownloadVersionServer interface {
+	Send(*File) error
+	grpc.ServerStream
+}
+
+type storageDownloadVersionServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageDownloadVersionServer) Send(m *File) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Storage_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/RestoreVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).RestoreVersion(ctx, req.(*VersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_GetVersioning_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).GetVersioning(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/GetVersioning",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).GetVersioning(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_SetVersioning_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VersioningPolicy)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).SetVersioning(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/SetVersioning",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).SetVersioning(ctx, req.(*VersioningPolicy))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Usage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Usage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Usage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Usage(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Share_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Share(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Share",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Share(ctx, req.(*ShareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_Unshare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).Unshare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/Unshare",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).Unshare(ctx, req.(*ShareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_ListSharedWithMe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).ListSharedWithMe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/ListSharedWithMe",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).ListSharedWithMe(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_DownloadSharedWithMe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SharedFileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).DownloadSharedWithMe(m, &storageDownloadSharedWithMeServer{stream})
+}
+
+type Storage_DownloadSharedWithMeServer interface {
+	Send(*File) error
+	grpc.ServerStream
+}
+
+type storageDownloadSharedWithMeServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageDownloadSharedWithMeServer) Send(m *File) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Storage_CreateShareLink_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateShareLinkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StorageServer).CreateShareLink(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.Storage/CreateShareLink",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StorageServer).CreateShareLink(ctx, req.(*CreateShareLinkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Storage_DownloadShared_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadSharedRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StorageServer).DownloadShared(m, &storageDownloadSharedServer{stream})
+}
+
+type Storage_DownloadSharedServer interface {
+	Send(*File) error
+	grpc.ServerStream
+}
+
+type storageDownloadSharedServer struct {
+	grpc.ServerStream
+}
+
+func (x *storageDownloadSharedServer) Send(m *File) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Storage_ServiceDesc is the grpc.ServiceDesc for Storage service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Storage_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.Storage",
+	HandlerType: (*StorageServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "InitiateUpload",
+			Handler:    _Storage_InitiateUpload_Handler,
+		},
+		{
+			MethodName: "QueryUpload",
+			Handler:    _Storage_QueryUpload_Handler,
+		},
+		{
+			MethodName: "CompleteUpload",
+			Handler:    _Storage_CompleteUpload_Handler,
+		},
+		{
+			MethodName: "AbortUpload",
+			Handler:    _Storage_AbortUpload_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Storage_List_Handler,
+		},
+		{
+			MethodName: "Stat",
+			Handler:    _Storage_Stat_Handler,
+		},
+		{
+			MethodName: "Mkdir",
+			Handler:    _Storage_Mkdir_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _Storage_Delete_Handler,
+		},
+		{
+			MethodName: "Rename",
+			Handler:    _Storage_Rename_Handler,
+		},
+		{
+			MethodName: "Copy",
+			Handler:    _Storage_Copy_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _Storage_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _Storage_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "GetVersioning",
+			Handler:    _Storage_GetVersioning_Handler,
+		},
+		{
+			MethodName: "SetVersioning",
+			Handler:    _Storage_SetVersioning_Handler,
+		},
+		{
+			MethodName: "Usage",
+			Handler:    _Storage_Usage_Handler,
+		},
+		{
+			MethodName: "Share",
+			Handler:    _Storage_Share_Handler,
+		},
+		{
+			MethodName: "Unshare",
+			Handler:    _Storage_Unshare_Handler,
+		},
+		{
+			MethodName: "ListSharedWithMe",
+			Handler:    _Storage_ListSharedWithMe_Handler,
+		},
+		{
+			MethodName: "CreateShareLink",
+			Handler:    _Storage_CreateShareLink_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Upload",
+			Handler:       _Storage_Upload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "AppendUpload",
+			Handler:       _Storage_AppendUpload_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Download",
+			Handler:       _Storage_Download_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ListStream",
+			Handler:       _Storage_ListStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DownloadVersion",
+			Handler:       _Storage_DownloadVersion_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DownloadSharedWithMe",
+			Handler:       _Storage_DownloadSharedWithMe_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "DownloadShared",
+			Handler:       _Storage_DownloadShared_Handler,
 			ServerStreams: true,
 		},
 	},
@@ -2,97 +2,674 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"io"
+	"io/fs"
 
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
+	"github.com/KirillMironov/beaver/internal/aes"
 	"github.com/KirillMironov/beaver/internal/grpcutil"
 	"github.com/KirillMironov/beaver/internal/log"
 	"github.com/KirillMironov/beaver/internal/server"
 	"github.com/KirillMironov/beaver/internal/server/transport/proto"
 )
 
-const authorizationHeader = "authorization"
+const (
+	authorizationHeader = "authorization"
+	digestTrailer       = "digest-bin"
+)
 
 type StorageService struct {
-	authenticator Authenticator
-	storage       Storage
-	logger        log.Logger
+	storage Storage
+	logger  log.Logger
 }
 
 type Storage interface {
-	Upload(user server.User, filename string, src io.Reader) error
-	Download(user server.User, filename string, dst io.Writer) error
-	List(user server.User) ([]string, error)
+	Upload(ctx context.Context, user server.User, metadata server.FileMetadata, src io.Reader) (server.UploadResult, error)
+	InitiateUpload(ctx context.Context, user server.User, metadata server.FileMetadata) (server.UploadSession, error)
+	AppendUpload(ctx context.Context, user server.User, id string, offset int64, src io.Reader) (server.UploadSession, error)
+	QueryUpload(ctx context.Context, user server.User, id string) (server.UploadSession, error)
+	CompleteUpload(ctx context.Context, user server.User, id string) (server.UploadResult, error)
+	AbortUpload(ctx context.Context, user server.User, id string) error
+	Download(ctx context.Context, user server.User, filename string, byteRange server.ByteRange, dst io.Writer) (server.DownloadResult, error)
+	List(ctx context.Context, user server.User, dir string, options server.ListOptions) (server.ListPage, error)
+	Walk(ctx context.Context, user server.User, dir string, options server.ListOptions, fn func(server.FileInfo) error) error
+	Stat(ctx context.Context, user server.User, name string) (server.FileInfo, error)
+	Mkdir(ctx context.Context, user server.User, dir string) error
+	Delete(ctx context.Context, user server.User, name string) error
+	Rename(ctx context.Context, user server.User, src, dst string, overwrite bool) error
+	Copy(ctx context.Context, user server.User, src, dst string, overwrite bool) error
+	ListVersions(ctx context.Context, user server.User, name string) ([]server.FileInfo, error)
+	DownloadVersion(ctx context.Context, user server.User, name, id string, byteRange server.ByteRange, dst io.Writer) (server.DownloadResult, error)
+	RestoreVersion(ctx context.Context, user server.User, name, id string) (server.FileInfo, error)
+	Versioning(ctx context.Context, user server.User) (server.VersioningPolicy, error)
+	SetVersioning(ctx context.Context, user server.User, policy server.VersioningPolicy) error
+	Usage(ctx context.Context, user server.User) (server.Usage, error)
+	Share(ctx context.Context, user server.User, name, recipient string) error
+	Unshare(ctx context.Context, user server.User, name, recipient string) error
+	SharedWithMe(ctx context.Context, user server.User) ([]server.SharedFile, error)
+	DownloadSharedWithMe(ctx context.Context, user server.User, owner, name string, byteRange server.ByteRange, dst io.Writer) (server.DownloadResult, error)
+	CreateShareLink(ctx context.Context, user server.User, name string, options server.ShareLinkOptions) (server.ShareLink, error)
+	DownloadShared(ctx context.Context, token, passphrase string, byteRange server.ByteRange, dst io.Writer) (server.DownloadResult, error)
 }
 
-func NewStorageService(authenticator Authenticator, storage Storage, logger log.Logger) *StorageService {
+func NewStorageService(storage Storage, logger log.Logger) *StorageService {
 	return &StorageService{
-		authenticator: authenticator,
-		storage:       storage,
-		logger:        logger,
+		storage: storage,
+		logger:  logger,
 	}
 }
 
-func (s StorageService) Upload(request *proto.FileRequest, stream proto.Storage_UploadServer) error {
-	user, err := s.authenticate(stream.Context())
+func (s StorageService) Upload(stream proto.Storage_UploadServer) error {
+	user, err := userFromContext(stream.Context())
 	if err != nil {
 		return err
 	}
 
-	reader := grpcutil.StreamToReader[*proto.File](stream.Context(), stream)
+	request, err := stream.Recv()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "first message must carry file metadata")
+	}
 
-	if err = s.storage.Upload(user, request.GetFilename(), reader); err != nil {
-		s.logger.Errorf("failed to upload file: %v", err)
-		return status.Error(codes.Internal, "")
+	metadata := request.GetMetadata()
+	if metadata == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry file metadata")
 	}
 
-	return nil
+	fileMetadata, err := newFileMetadata(metadata)
+	if err != nil {
+		return err
+	}
+
+	reader := grpcutil.StreamToReader(stream.Context(), stream, (*proto.UploadRequest).GetChunk)
+
+	result, err := s.storage.Upload(stream.Context(), user, fileMetadata, reader)
+	if err != nil {
+		return s.statusError(err, "failed to upload file")
+	}
+
+	return stream.SendAndClose(&proto.UploadResponse{
+		BytesWritten: result.BytesWritten,
+		Digest:       result.Digest,
+	})
+}
+
+func (s StorageService) InitiateUpload(ctx context.Context, request *proto.FileMetadata) (*proto.UploadSession, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := newFileMetadata(request)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.storage.InitiateUpload(ctx, user, metadata)
+	if err != nil {
+		return nil, s.statusError(err, "failed to initiate upload")
+	}
+
+	auditEntry(ctx).Target = session.ID
+
+	return uploadSession(session), nil
+}
+
+func (s StorageService) AppendUpload(stream proto.Storage_AppendUploadServer) error {
+	user, err := userFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	request, err := stream.Recv()
+	if err != nil {
+		return status.Error(codes.InvalidArgument, "first message must carry upload position")
+	}
+
+	position := request.GetPosition()
+	if position == nil {
+		return status.Error(codes.InvalidArgument, "first message must carry upload position")
+	}
+
+	reader := grpcutil.StreamToReader(stream.Context(), stream, (*proto.AppendUploadRequest).GetChunk)
+
+	session, err := s.storage.AppendUpload(stream.Context(), user, position.GetUploadId(), position.GetOffset(), reader)
+	if err != nil {
+		return s.statusError(err, "failed to append upload")
+	}
+
+	return stream.SendAndClose(uploadSession(session))
+}
+
+func (s StorageService) QueryUpload(ctx context.Context, request *proto.UploadSessionRequest) (*proto.UploadSession, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.storage.QueryUpload(ctx, user, request.GetUploadId())
+	if err != nil {
+		return nil, s.statusError(err, "failed to query upload")
+	}
+
+	return uploadSession(session), nil
+}
+
+func (s StorageService) CompleteUpload(ctx context.Context, request *proto.UploadSessionRequest) (*proto.UploadResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.storage.CompleteUpload(ctx, user, request.GetUploadId())
+	if err != nil {
+		return nil, s.statusError(err, "failed to complete upload")
+	}
+
+	return &proto.UploadResponse{
+		BytesWritten: result.BytesWritten,
+		Digest:       result.Digest,
+	}, nil
+}
+
+func (s StorageService) AbortUpload(ctx context.Context, request *proto.UploadSessionRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.storage.AbortUpload(ctx, user, request.GetUploadId()); err != nil {
+		return nil, s.statusError(err, "failed to abort upload")
+	}
+
+	return &emptypb.Empty{}, nil
 }
 
 func (s StorageService) Download(request *proto.FileRequest, stream proto.Storage_DownloadServer) error {
-	user, err := s.authenticate(stream.Context())
+	user, err := userFromContext(stream.Context())
 	if err != nil {
 		return err
 	}
 
-	writer := grpcutil.StreamToWriter[*proto.File](stream.Context(), stream)
+	writer := grpcutil.StreamToWriter(stream.Context(), stream, func(chunk []byte) *proto.File {
+		return &proto.File{Chunk: chunk}
+	})
 
-	if err = s.storage.Download(user, request.GetFilename(), writer); err != nil {
-		s.logger.Errorf("failed to download file: %v", err)
-		return status.Error(codes.Internal, "")
+	byteRange := server.ByteRange{Offset: request.GetOffset(), Length: request.GetLength()}
+
+	result, err := s.storage.Download(stream.Context(), user, request.GetFilename(), byteRange, writer)
+	if err != nil {
+		return s.statusError(err, "failed to download file")
+	}
+
+	setDigestTrailer(stream, result)
+
+	return nil
+}
+
+func (s StorageService) List(ctx context.Context, request *proto.ListRequest) (*proto.ListResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := listOptions(request)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.storage.List(ctx, user, request.GetPath(), options)
+	if err != nil {
+		return nil, s.statusError(err, "failed to list files")
+	}
+
+	response := &proto.ListResponse{
+		Filenames:     make([]string, 0, len(page.Entries)),
+		Entries:       make([]*proto.Entry, 0, len(page.Entries)),
+		NextPageToken: page.NextPageToken,
+	}
+
+	for _, info := range page.Entries {
+		filename := info.Path
+		if info.IsDir {
+			filename += "/"
+		}
+
+		response.Filenames = append(response.Filenames, filename)
+		response.Entries = append(response.Entries, entryFromFileInfo(info))
+	}
+
+	return response, nil
+}
+
+func (s StorageService) ListStream(request *proto.ListRequest, stream proto.Storage_ListStreamServer) error {
+	user, err := userFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	options, err := listOptions(request)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.Walk(stream.Context(), user, request.GetPath(), options, func(info server.FileInfo) error {
+		return stream.Send(entryFromFileInfo(info))
+	})
+	if err != nil {
+		return s.statusError(err, "failed to list files")
+	}
+
+	return nil
+}
+
+func (s StorageService) Stat(ctx context.Context, request *proto.StatRequest) (*proto.Entry, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.storage.Stat(ctx, user, request.GetPath())
+	if err != nil {
+		return nil, s.statusError(err, "failed to stat file")
+	}
+
+	return entryFromFileInfo(info), nil
+}
+
+func (s StorageService) Mkdir(ctx context.Context, request *proto.MkdirRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.storage.Mkdir(ctx, user, request.GetPath()); err != nil {
+		return nil, s.statusError(err, "failed to create directory")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) Delete(ctx context.Context, request *proto.DeleteRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.storage.Delete(ctx, user, request.GetPath()); err != nil {
+		return nil, s.statusError(err, "failed to delete file")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) Rename(ctx context.Context, request *proto.MoveRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storage.Rename(ctx, user, request.GetSource(), request.GetDestination(), request.GetOverwrite())
+	if err != nil {
+		return nil, s.statusError(err, "failed to rename file")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) Copy(ctx context.Context, request *proto.MoveRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.storage.Copy(ctx, user, request.GetSource(), request.GetDestination(), request.GetOverwrite())
+	if err != nil {
+		return nil, s.statusError(err, "failed to copy file")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) ListVersions(ctx context.Context, request *proto.FileRequest) (*proto.ListVersionsResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := s.storage.ListVersions(ctx, user, request.GetFilename())
+	if err != nil {
+		return nil, s.statusError(err, "failed to list versions")
+	}
+
+	response := &proto.ListVersionsResponse{
+		Versions: make([]*proto.Entry, 0, len(versions)),
 	}
 
+	for _, info := range versions {
+		response.Versions = append(response.Versions, entryFromFileInfo(info))
+	}
+
+	return response, nil
+}
+
+func (s StorageService) DownloadVersion(request *proto.VersionRequest, stream proto.Storage_DownloadVersionServer) error {
+	user, err := userFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	writer := grpcutil.StreamToWriter(stream.Context(), stream, func(chunk []byte) *proto.File {
+		return &proto.File{Chunk: chunk}
+	})
+
+	byteRange := server.ByteRange{Offset: request.GetOffset(), Length: request.GetLength()}
+
+	result, err := s.storage.DownloadVersion(stream.Context(), user, request.GetFilename(), request.GetVersionId(), byteRange, writer)
+	if err != nil {
+		return s.statusError(err, "failed to download version")
+	}
+
+	setDigestTrailer(stream, result)
+
 	return nil
 }
 
-func (s StorageService) List(ctx context.Context, _ *emptypb.Empty) (*proto.ListResponse, error) {
-	user, err := s.authenticate(ctx)
+// setDigestTrailer sends the digest of the downloaded file to the client after the last chunk.
+func setDigestTrailer(stream grpc.ServerStream, result server.DownloadResult) {
+	if result.Digest != nil {
+		grpcutil.SetTrailer(stream, digestTrailer, result.Digest)
+	}
+}
+
+func (s StorageService) RestoreVersion(ctx context.Context, request *proto.VersionRequest) (*proto.Entry, error) {
+	user, err := userFromContext(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	filenames, err := s.storage.List(user)
+	info, err := s.storage.RestoreVersion(ctx, user, request.GetFilename(), request.GetVersionId())
 	if err != nil {
-		s.logger.Errorf("failed to list files: %v", err)
-		return nil, status.Error(codes.Internal, "")
+		return nil, s.statusError(err, "failed to restore version")
 	}
 
-	return &proto.ListResponse{Filenames: filenames}, nil
+	return entryFromFileInfo(info), nil
 }
 
-func (s StorageService) authenticate(ctx context.Context) (server.User, error) {
-	token := grpcutil.HeaderFromContext(ctx, authorizationHeader)
-	if token == "" {
-		return server.User{}, status.Error(codes.Unauthenticated, `provide token in "authorization" header`)
+func (s StorageService) GetVersioning(ctx context.Context, _ *emptypb.Empty) (*proto.VersioningPolicy, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := s.storage.Versioning(ctx, user)
+	if err != nil {
+		return nil, s.statusError(err, "failed to get versioning policy")
+	}
+
+	return &proto.VersioningPolicy{
+		Enabled:      policy.Enabled,
+		KeepVersions: int32(policy.KeepVersions),
+		KeepDays:     int32(policy.KeepDays),
+	}, nil
+}
+
+func (s StorageService) SetVersioning(ctx context.Context, request *proto.VersioningPolicy) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	user, err := s.authenticator.ValidateToken(token)
+	err = s.storage.SetVersioning(ctx, user, server.VersioningPolicy{
+		Enabled:      request.GetEnabled(),
+		KeepVersions: int(request.GetKeepVersions()),
+		KeepDays:     int(request.GetKeepDays()),
+	})
 	if err != nil {
-		return server.User{}, status.Error(codes.Unauthenticated, "invalid token")
+		return nil, s.statusError(err, "failed to set versioning policy")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) Usage(ctx context.Context, _ *emptypb.Empty) (*proto.UsageResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := s.storage.Usage(ctx, user)
+	if err != nil {
+		return nil, s.statusError(err, "failed to get usage")
+	}
+
+	return &proto.UsageResponse{
+		Bytes:    usage.Bytes,
+		Files:    usage.Files,
+		MaxBytes: usage.Quota.MaxBytes,
+		MaxFiles: usage.Quota.MaxFiles,
+	}, nil
+}
+
+func (s StorageService) Share(ctx context.Context, request *proto.ShareRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.storage.Share(ctx, user, request.GetPath(), request.GetRecipient()); err != nil {
+		return nil, s.statusError(err, "failed to share file")
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) Unshare(ctx context.Context, request *proto.ShareRequest) (*emptypb.Empty, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.storage.Unshare(ctx, user, request.GetPath(), request.GetRecipient()); err != nil {
+		return nil, s.statusError(err, "failed to unshare file")
 	}
 
-	return user, nil
+	return &emptypb.Empty{}, nil
+}
+
+func (s StorageService) ListSharedWithMe(ctx context.Context, _ *emptypb.Empty) (*proto.ListSharedWithMeResponse, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.storage.SharedWithMe(ctx, user)
+	if err != nil {
+		return nil, s.statusError(err, "failed to list shared files")
+	}
+
+	response := &proto.ListSharedWithMeResponse{}
+
+	for _, file := range files {
+		response.Files = append(response.Files, &proto.SharedFile{
+			Owner:    file.Owner,
+			Entry:    entryFromFileInfo(file.FileInfo),
+			SharedAt: timestamppb.New(file.Shared),
+		})
+	}
+
+	return response, nil
+}
+
+func (s StorageService) DownloadSharedWithMe(request *proto.SharedFileRequest, stream proto.Storage_DownloadSharedWithMeServer) error {
+	user, err := userFromContext(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	writer := grpcutil.StreamToWriter(stream.Context(), stream, func(chunk []byte) *proto.File {
+		return &proto.File{Chunk: chunk}
+	})
+
+	byteRange := server.ByteRange{Offset: request.GetOffset(), Length: request.GetLength()}
+
+	result, err := s.storage.DownloadSharedWithMe(stream.Context(), user, request.GetOwner(), request.GetPath(), byteRange, writer)
+	if err != nil {
+		return s.statusError(err, "failed to download shared file")
+	}
+
+	setDigestTrailer(stream, result)
+
+	return nil
+}
+
+func (s StorageService) CreateShareLink(ctx context.Context, request *proto.CreateShareLinkRequest) (*proto.ShareLink, error) {
+	user, err := userFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.storage.CreateShareLink(ctx, user, request.GetPath(), server.ShareLinkOptions{
+		TTL:          request.GetTtl().AsDuration(),
+		Passphrase:   request.GetPassphrase(),
+		MaxDownloads: request.GetMaxDownloads(),
+	})
+	if err != nil {
+		return nil, s.statusError(err, "failed to create share link")
+	}
+
+	return &proto.ShareLink{
+		Token:     link.Token,
+		ExpiresAt: timestamppb.New(link.Expires),
+	}, nil
+}
+
+// DownloadShared is the only public storage method, the token of the link grants access instead of a user token.
+// The token is never recorded in the audit log, as it is a credential.
+func (s StorageService) DownloadShared(request *proto.DownloadSharedRequest, stream proto.Storage_DownloadSharedServer) error {
+	entry := auditEntry(stream.Context())
+	entry.Actor = "share link"
+
+	writer := grpcutil.StreamToWriter(stream.Context(), stream, func(chunk []byte) *proto.File {
+		return &proto.File{Chunk: chunk}
+	})
+
+	byteRange := server.ByteRange{Offset: request.GetOffset(), Length: request.GetLength()}
+
+	result, err := s.storage.DownloadShared(stream.Context(), request.GetToken(), request.GetPassphrase(), byteRange, writer)
+
+	entry.Username, entry.Path = result.Owner, result.Path
+
+	if err != nil {
+		return s.statusError(err, "failed to download shared file")
+	}
+
+	setDigestTrailer(stream, result)
+
+	return nil
+}
+
+func uploadSession(session server.UploadSession) *proto.UploadSession {
+	return &proto.UploadSession{
+		UploadId:        session.ID,
+		CommittedOffset: session.CommittedOffset,
+		ExpiresAt:       timestamppb.New(session.ExpiresAt),
+	}
+}
+
+var compressions = map[proto.Compression]server.Compression{
+	proto.Compression_COMPRESSION_NONE: server.CompressionNone,
+	proto.Compression_COMPRESSION_GZIP: server.CompressionGzip,
+	proto.Compression_COMPRESSION_AUTO: server.CompressionAuto,
+}
+
+func newFileMetadata(metadata *proto.FileMetadata) (server.FileMetadata, error) {
+	compression, ok := compressions[metadata.GetCompression()]
+	if !ok {
+		return server.FileMetadata{}, status.Error(codes.InvalidArgument, "unknown compression")
+	}
+
+	return server.FileMetadata{
+		Filename:    metadata.GetFilename(),
+		Size:        metadata.GetSize(),
+		ContentType: metadata.GetContentType(),
+		Compression: compression,
+		Digest:      metadata.GetDigest(),
+	}, nil
+}
+
+var sortFields = map[proto.SortField]server.SortField{
+	proto.SortField_SORT_FIELD_NAME:     server.SortByName,
+	proto.SortField_SORT_FIELD_SIZE:     server.SortBySize,
+	proto.SortField_SORT_FIELD_MODIFIED: server.SortByModified,
+}
+
+func listOptions(request *proto.ListRequest) (server.ListOptions, error) {
+	sortBy, ok := sortFields[request.GetSortBy()]
+	if !ok {
+		return server.ListOptions{}, status.Error(codes.InvalidArgument, "unknown sort field")
+	}
+
+	return server.ListOptions{
+		Recursive:  request.GetRecursive(),
+		Pattern:    request.GetPattern(),
+		SortBy:     sortBy,
+		Descending: request.GetDescending(),
+		PageSize:   int(request.GetPageSize()),
+		PageToken:  request.GetPageToken(),
+	}, nil
+}
+
+func entryFromFileInfo(info server.FileInfo) *proto.Entry {
+	kind := proto.EntryKind_ENTRY_KIND_FILE
+	if info.IsDir {
+		kind = proto.EntryKind_ENTRY_KIND_DIRECTORY
+	}
+
+	return &proto.Entry{
+		Path:        info.Path,
+		Kind:        kind,
+		Size:        info.Size,
+		StoredSize:  info.StoredSize,
+		CreatedAt:   timestamppb.New(info.Created),
+		ModifiedAt:  timestamppb.New(info.Modified),
+		Digest:      info.Digest,
+		ContentType: info.ContentType,
+		VersionId:   info.Version,
+	}
+}
+
+// statusError converts a storage error into a gRPC status.
+// Unexpected errors are logged and reported without details, as they may reveal server paths.
+func (s StorageService) statusError(err error, message string) error {
+	switch {
+	case errors.Is(err, server.ErrInvalidPath), errors.Is(err, server.ErrSizeMismatch), errors.Is(err, server.ErrDigestMismatch), errors.Is(err, server.ErrInvalidListOptions),
+		errors.Is(err, server.ErrInvalidVersioningPolicy), errors.Is(err, server.ErrInvalidRecipient), errors.Is(err, server.ErrInvalidShareLink):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, server.ErrInvalidLinkPassphrase):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, fs.ErrNotExist):
+		return status.Error(codes.NotFound, "file not found")
+	case errors.Is(err, fs.ErrExist):
+		return status.Error(codes.AlreadyExists, "file already exists")
+	case errors.Is(err, server.ErrKeyChanged):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, server.ErrQuotaExceeded), errors.Is(err, server.ErrTooManyAttempts):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, server.ErrInvalidRange):
+		return status.Error(codes.OutOfRange, err.Error())
+	case errors.Is(err, server.ErrNotEmpty), errors.Is(err, server.ErrOffsetMismatch), errors.Is(err, server.ErrNotShareable):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, aes.ErrCorrupted):
+		s.logger.Errorf("%s: %v", message, err)
+		return status.Error(codes.DataLoss, "file is corrupted")
+	default:
+		s.logger.Errorf("%s: %v", message, err)
+		return status.Error(codes.Internal, "")
+	}
 }
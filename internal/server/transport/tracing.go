@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+const traceparentHeader = "traceparent"
+
+// Tracing starts a root span for every RPC, continuing the trace of the client if it sent a traceparent header.
+// The spans of the server layer are children of it.
+type Tracing struct {
+	tracer *trace.Tracer
+}
+
+func NewTracing(tracer *trace.Tracer) *Tracing {
+	return &Tracing{tracer: tracer}
+}
+
+func (t *Tracing) UnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, span := t.start(ctx, info.FullMethod)
+		defer span.End()
+
+		response, err := handler(ctx, request)
+
+		finish(span, err)
+
+		return response, err
+	}
+}
+
+func (t *Tracing) StreamInterceptor() grpc.StreamServerInterceptor {
+	return func(server any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.start(stream.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(server, &tracedStream{ServerStream: stream, ctx: ctx})
+
+		finish(span, err)
+
+		return err
+	}
+}
+
+func (t *Tracing) start(ctx context.Context, method string) (context.Context, *trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(traceparentHeader); len(values) > 0 {
+			if parent, ok := trace.ParseTraceparent(values[0]); ok {
+				ctx = trace.WithRemoteParent(ctx, parent)
+			}
+		}
+	}
+
+	return t.tracer.Start(ctx, method)
+}
+
+func finish(span *trace.Span, err error) {
+	span.SetAttribute("code", status.Code(err).String())
+	span.SetError(err)
+}
+
+// tracedStream passes the context holding the span of the RPC to the handler.
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,536 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/rand"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+// Upload sessions live in the blob store under "<username>/.uploads/<upload id>/".
+// Every append is stored as a separate part encrypted like a regular file, so parts are re-keyed
+// and shredded along with the other files of the user. The ".session" record lists the plaintext
+// sizes of the committed parts. A part is committed by moving it into place before the record is
+// updated, so a crash never leaves a committed offset that isn't backed by a part.
+const (
+	uploadsDirname        = ".uploads"
+	uploadSessionFilename = ".session"
+	uploadIDSize          = 16
+)
+
+// ErrOffsetMismatch is returned when data is appended to an upload session at an offset other than the committed one.
+var ErrOffsetMismatch = errors.New("offset does not match the committed offset")
+
+// UploadSession describes the progress of a resumable upload.
+type UploadSession struct {
+	ID              string
+	CommittedOffset int64
+	ExpiresAt       time.Time
+}
+
+type uploadRecord struct {
+	Filename    string      `json:"filename"`
+	Size        int64       `json:"size"`
+	ContentType string      `json:"content_type"`
+	Compression Compression `json:"compression"`
+	Digest      []byte      `json:"digest,omitempty"`
+	Parts       []int64     `json:"parts"`
+	Updated     time.Time   `json:"updated"`
+}
+
+func (r uploadRecord) committedOffset() int64 {
+	var offset int64
+
+	for _, size := range r.Parts {
+		offset += size
+	}
+
+	return offset
+}
+
+// InitiateUpload starts a resumable upload of the file described by metadata.
+func (s Storage) InitiateUpload(ctx context.Context, user User, metadata FileMetadata) (UploadSession, error) {
+	ctx, span := trace.Start(ctx, "storage.InitiateUpload")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	key, err := s.resolveFile(user, metadata.Filename)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if err = s.checkUploadDestination(user, key, metadata.Filename); err != nil {
+		return UploadSession{}, err
+	}
+
+	// Nothing is stored until the content is appended, so the quota is only checked here.
+	if err = s.checkQuota(user, metadata.Size, s.newFiles(key)); err != nil {
+		return UploadSession{}, err
+	}
+
+	rawID, err := rand.Bytes(uploadIDSize)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	id := hex.EncodeToString(rawID)
+
+	record := uploadRecord{
+		Filename:    metadata.Filename,
+		Size:        metadata.Size,
+		ContentType: metadata.ContentType,
+		Compression: metadata.Compression,
+		Digest:      metadata.Digest,
+		Updated:     time.Now().UTC(),
+	}
+
+	if err = writeUploadRecord(s.store, uploadKey(user.Username, id), record); err != nil {
+		return UploadSession{}, err
+	}
+
+	return s.uploadSession(id, record), nil
+}
+
+// AppendUpload appends src to the upload session at the offset, which must be the committed offset.
+// If src fails, the data received before the failure is committed, so the upload can be resumed from it.
+func (s Storage) AppendUpload(ctx context.Context, user User, id string, offset int64, src io.Reader) (UploadSession, error) {
+	ctx, span := trace.Start(ctx, "storage.AppendUpload")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	write, err := s.keyLocks.startWrite(user)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	defer s.locks.lock(uploadKey(user.Username, id))()
+
+	sessionKey, record, err := s.readUploadSession(user, id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if committed := record.committedOffset(); offset != committed {
+		return UploadSession{}, fmt.Errorf("%w: got %d, committed %d", ErrOffsetMismatch, offset, committed)
+	}
+
+	// The session is touched first, so it isn't collected while a long append is in progress.
+	record.Updated = time.Now().UTC()
+
+	if err = writeUploadRecord(s.store, sessionKey, record); err != nil {
+		return UploadSession{}, err
+	}
+
+	key, err := s.resolveFile(user, record.Filename)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	// The file is only counted once the upload is completed, there is no point in appending to it if it can't be.
+	if err = s.checkQuota(user, 0, s.newFiles(key)); err != nil {
+		return UploadSession{}, err
+	}
+
+	reservation, err := s.reserveQuota(user, 0, 0)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer reservation.release()
+
+	partKey := sessionKey + "/" + partFilename(len(record.Parts))
+
+	tmp, err := tempKey(partKey)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer func() {
+		_ = s.store.Delete(tmp)
+	}()
+
+	var (
+		reader  = &errorStopReader{r: src}
+		counter = &byteCounter{}
+	)
+
+	err = putStream(s.store, tmp, func(w io.Writer) error {
+		if _, err := encryptFile(ctx, reservation.limitWriter(w), io.TeeReader(reader, counter), user.Key(), codecNone); err != nil {
+			return err
+		}
+
+		if record.Size > 0 && offset+counter.n > record.Size {
+			return ErrSizeMismatch
+		}
+
+		return nil
+	})
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if counter.n == 0 {
+		return s.uploadSession(id, record), reader.err
+	}
+
+	// The key is only locked once the content is written, so a slow client doesn't hold off a change of the key.
+	unlock, err := write.lock()
+	if err != nil {
+		return UploadSession{}, err
+	}
+	defer unlock()
+
+	if err = s.store.Move(tmp, partKey); err != nil {
+		return UploadSession{}, err
+	}
+
+	reservation.commit()
+
+	record.Parts = append(record.Parts, counter.n)
+	record.Updated = time.Now().UTC()
+
+	if err = writeUploadRecord(s.store, sessionKey, record); err != nil {
+		return UploadSession{}, err
+	}
+
+	return s.uploadSession(id, record), reader.err
+}
+
+// QueryUpload reports the committed offset of the upload session.
+func (s Storage) QueryUpload(ctx context.Context, user User, id string) (UploadSession, error) {
+	ctx, span := trace.Start(ctx, "storage.QueryUpload")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	_, record, err := s.readUploadSession(user, id)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	return s.uploadSession(id, record), nil
+}
+
+// CompleteUpload publishes the uploaded file and removes the upload session.
+// The file appears at its path atomically, an existing file is replaced only if versioning is enabled.
+func (s Storage) CompleteUpload(ctx context.Context, user User, id string) (UploadResult, error) {
+	ctx, span := trace.Start(ctx, "storage.CompleteUpload")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	write, err := s.keyLocks.startWrite(user)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	defer s.locks.lock(uploadKey(user.Username, id))()
+
+	sessionKey, record, err := s.readUploadSession(user, id)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if record.Size > 0 && record.committedOffset() != record.Size {
+		return UploadResult{}, ErrSizeMismatch
+	}
+
+	key, err := s.resolveFile(user, record.Filename)
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	if err = s.checkUploadDestination(user, key, record.Filename); err != nil {
+		return UploadResult{}, err
+	}
+
+	partsSize, err := storedPartsSize(s.store, sessionKey, len(record.Parts))
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	// The parts are removed once the file is published, so the file only has to fit in the room they leave.
+	reservation, err := s.reserveQuota(user, -partsSize, s.newFiles(key))
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer reservation.release()
+
+	if err = s.mkdirAll(user, path.Dir(key)); err != nil {
+		return UploadResult{}, err
+	}
+
+	tmp, err := tempKey(key)
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer func() {
+		_ = s.store.Delete(tmp)
+	}()
+
+	var (
+		digest  = sha256.New()
+		counter = &byteCounter{}
+		dataKey []byte
+	)
+
+	err = putStream(s.store, tmp, func(w io.Writer) (err error) {
+		pr, pw := io.Pipe()
+
+		go func() {
+			pw.CloseWithError(decryptParts(ctx, pw, s.store, sessionKey, len(record.Parts), user.Key()))
+		}()
+
+		// The parts are stored uncompressed, the codec is chosen for the assembled plaintext.
+		codec, plaintext := chooseCodec(record.Compression, pr)
+
+		if dataKey, err = encryptFile(ctx, reservation.limitWriter(w), io.TeeReader(plaintext, io.MultiWriter(digest, counter)), user.Key(), codec); err != nil {
+			_ = pr.CloseWithError(err)
+			return err
+		}
+
+		return checkDigest(digest.Sum(nil), record.Digest)
+	})
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	now := time.Now().UTC()
+
+	metadata := metadataRecord{
+		Size:        counter.n,
+		Digest:      digest.Sum(nil),
+		ContentType: record.ContentType,
+		Created:     now,
+		Modified:    now,
+	}
+
+	unlock, err := write.lock()
+	if err != nil {
+		return UploadResult{}, err
+	}
+	defer unlock()
+
+	if err = s.publish(user, tmp, key, metadata, dataKey, false); err != nil {
+		return UploadResult{}, err
+	}
+
+	if err = deletePrefix(s.store, sessionKey+"/"); err != nil {
+		return UploadResult{}, err
+	}
+
+	reservation.commit()
+
+	return UploadResult{BytesWritten: metadata.Size, Digest: metadata.Digest}, nil
+}
+
+// AbortUpload removes the upload session along with the uploaded data.
+func (s Storage) AbortUpload(ctx context.Context, user User, id string) error {
+	ctx, span := trace.Start(ctx, "storage.AbortUpload")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	defer s.locks.lock(uploadKey(user.Username, id))()
+
+	sessionKey, _, err := s.readUploadSession(user, id)
+	if err != nil {
+		return err
+	}
+
+	return deletePrefix(s.store, sessionKey+"/")
+}
+
+// readUploadSession returns the key prefix and the record of the upload session.
+// Expired sessions are removed and reported as missing.
+func (s Storage) readUploadSession(user User, id string) (string, uploadRecord, error) {
+	if rawID, err := hex.DecodeString(id); err != nil || len(rawID) != uploadIDSize {
+		return "", uploadRecord{}, fmt.Errorf("upload %q: %w", id, fs.ErrNotExist)
+	}
+
+	sessionKey := uploadKey(user.Username, id)
+
+	record, err := readUploadRecord(s.store, sessionKey)
+	if err != nil {
+		return "", uploadRecord{}, err
+	}
+
+	if time.Since(record.Updated) > s.uploadTimeout {
+		_ = deletePrefix(s.store, sessionKey+"/")
+		return "", uploadRecord{}, fmt.Errorf("upload %q: %w", id, fs.ErrNotExist)
+	}
+
+	return sessionKey, record, nil
+}
+
+func (s Storage) uploadSession(id string, record uploadRecord) UploadSession {
+	return UploadSession{
+		ID:              id,
+		CommittedOffset: record.committedOffset(),
+		ExpiresAt:       record.Updated.Add(s.uploadTimeout),
+	}
+}
+
+// CollectUploads removes the upload sessions of all users that haven't been updated within the timeout.
+// Users are the directories of the data dir, their sessions are kept in the blob store.
+func CollectUploads(store blob.Store, dataDir string, timeout time.Duration) (removed int, err error) {
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		sessions, err := uploadSessions(store, entry.Name())
+		if err != nil {
+			return removed, err
+		}
+
+		for sessionKey, updated := range sessions {
+			if time.Since(updated) <= timeout {
+				continue
+			}
+
+			if err = deletePrefix(store, sessionKey+"/"); err != nil {
+				return removed, err
+			}
+
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+// uploadSessions returns the last update times of the upload sessions of the user by their key prefixes.
+// Sessions without a readable record are dated by their newest blob.
+func uploadSessions(store blob.Store, username string) (map[string]time.Time, error) {
+	prefix := username + "/" + uploadsDirname + "/"
+
+	infos, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make(map[string]time.Time)
+
+	for _, info := range infos {
+		id, _, _ := strings.Cut(strings.TrimPrefix(info.Key, prefix), "/")
+
+		if updated := sessions[prefix+id]; info.Modified.After(updated) {
+			sessions[prefix+id] = info.Modified
+		}
+	}
+
+	for sessionKey := range sessions {
+		if record, err := readUploadRecord(store, sessionKey); err == nil {
+			sessions[sessionKey] = record.Updated
+		}
+	}
+
+	return sessions, nil
+}
+
+func uploadKey(username, id string) string {
+	return username + "/" + uploadsDirname + "/" + id
+}
+
+func writeUploadRecord(store blob.Store, sessionKey string, record uploadRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return putBytes(store, sessionKey+"/"+uploadSessionFilename, data)
+}
+
+func readUploadRecord(store blob.Store, sessionKey string) (uploadRecord, error) {
+	data, err := readBlob(store, sessionKey+"/"+uploadSessionFilename)
+	if err != nil {
+		return uploadRecord{}, err
+	}
+
+	var record uploadRecord
+
+	if err = json.Unmarshal(data, &record); err != nil {
+		return uploadRecord{}, err
+	}
+
+	return record, nil
+}
+
+// storedPartsSize returns the stored size of the committed parts of the upload session.
+func storedPartsSize(store blob.Store, sessionKey string, parts int) (int64, error) {
+	var size int64
+
+	for i := 0; i < parts; i++ {
+		info, err := store.Stat(sessionKey + "/" + partFilename(i))
+		if err != nil {
+			return 0, err
+		}
+
+		size += info.Size
+	}
+
+	return size, nil
+}
+
+// decryptParts writes the plaintext of the committed parts to dst in order.
+func decryptParts(ctx context.Context, dst io.Writer, store blob.Store, sessionKey string, parts int, userKey []byte) error {
+	for i := 0; i < parts; i++ {
+		err := func() error {
+			part, err := store.Get(sessionKey + "/" + partFilename(i))
+			if err != nil {
+				return err
+			}
+			defer part.Close()
+
+			return decryptFile(ctx, dst, part, userKey)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func partFilename(i int) string {
+	return fmt.Sprintf("part-%06d", i)
+}
+
+// errorStopReader ends the stream at the first error of the underlying reader and keeps the error,
+// so the data read before the failure can still be committed.
+type errorStopReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorStopReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, io.EOF
+	}
+
+	n, err := r.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		r.err = err
+		return n, io.EOF
+	}
+
+	return n, err
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+)
+
+func TestStorage_ResumableUpload(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	metadata := FileMetadata{Filename: "a/" + fileName, Size: int64(len(fileContent)), ContentType: "text/plain"}
+
+	session, err := storage.InitiateUpload(context.Background(), user, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	half := len(fileContent) / 2
+
+	// The stream breaks after the first half, which is still committed.
+	broken := io.MultiReader(strings.NewReader(fileContent[:half]), &errorReader{err: io.ErrClosedPipe})
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, broken); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("got %v, want %v", err, io.ErrClosedPipe)
+	}
+
+	if session, err = storage.QueryUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := session.CommittedOffset, int64(half); got != want {
+		t.Fatalf("got committed offset %d, want %d", got, want)
+	}
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrSizeMismatch)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(fileContent)); !errors.Is(err, ErrOffsetMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrOffsetMismatch)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, int64(half), strings.NewReader(fileContent[half:]+"x")); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrSizeMismatch)
+	}
+
+	if session, err = storage.AppendUpload(context.Background(), user, session.ID, int64(half), strings.NewReader(fileContent[half:])); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := session.CommittedOffset, int64(len(fileContent)); got != want {
+		t.Fatalf("got committed offset %d, want %d", got, want)
+	}
+
+	result, err := storage.CompleteUpload(context.Background(), user, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := result.Digest, sha256.Sum256([]byte(fileContent)); !bytes.Equal(got, want[:]) {
+		t.Fatalf("got digest %x, want %x", got, want)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err = storage.Download(context.Background(), user, metadata.Filename, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := dst.String(), fileContent; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	info, err := storage.Stat(context.Background(), user, metadata.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := info.ContentType, metadata.ContentType; got != want {
+		t.Fatalf("got content type %q, want %q", got, want)
+	}
+
+	if _, err = storage.QueryUpload(context.Background(), user, session.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestStorage_CompleteUpload_Exists(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(file2Name)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrExist)
+	}
+
+	if _, err = storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName}); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrExist)
+	}
+
+	if err = storage.AbortUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.QueryUpload(context.Background(), user, session.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+
+	for _, id := range []string{"", "..", "../" + session.ID, "0123"} {
+		if _, err = storage.QueryUpload(context.Background(), user, id); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("QueryUpload(%q) error = %v, want %v", id, err, os.ErrNotExist)
+		}
+	}
+}
+
+func TestStorage_CompleteUpload_DigestMismatch(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	digest := sha256.Sum256([]byte(file2Name))
+
+	session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName, Digest: digest[:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrDigestMismatch)
+	}
+
+	if _, err = storage.Stat(context.Background(), user, fileName); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want the rejected file not to be published", err)
+	}
+
+	if _, err = storage.QueryUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatalf("got %v, want the session to remain", err)
+	}
+}
+
+func TestCollectUploads(t *testing.T) {
+	t.Parallel()
+
+	dataDir := t.TempDir()
+
+	var (
+		store   = blob.NewLocalStore(dataDir)
+		storage = NewStorage(store, nil, NewKeyLocks(), testUploadTimeout, Quota{}, ShareLinkPolicy{})
+		expired = NewStorage(store, nil, NewKeyLocks(), 0, Quota{}, ShareLinkPolicy{})
+		ids     []string
+	)
+
+	for _, username := range []string{"user", "user2"} {
+		user := User{
+			Username: username,
+			DataDir:  filepath.Join(dataDir, username),
+			key:      testKey,
+		}
+
+		session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = expired.QueryUpload(context.Background(), user, session.ID); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("got %v for an expired session, want %v", err, os.ErrNotExist)
+		}
+
+		if session, err = storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName}); err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, session.ID)
+	}
+
+	removed, err := CollectUploads(store, dataDir, testUploadTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 0 {
+		t.Fatalf("got %d removed sessions, want 0", removed)
+	}
+
+	if removed, err = CollectUploads(store, dataDir, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := removed, len(ids); got != want {
+		t.Fatalf("got %d removed sessions, want %d", got, want)
+	}
+}
+
+func TestAuthenticator_ChangePassphrase_UploadSession(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := authenticator.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: fileName})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader(fileContent)); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, err = authenticator.ChangePassphrase(context.Background(), "user", "passphrase", "new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err = authenticator.ValidateToken(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &strings.Builder{}
+
+	if _, err = storage.Download(context.Background(), user, fileName, ByteRange{}, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := dst.String(), fileContent; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/blob"
+	"github.com/KirillMironov/beaver/internal/trace"
+)
+
+// When versioning is enabled, uploading to an existing name archives the current file
+// as ".<filename>.v<version id>" next to it, along with its metadata record.
+// Archived versions are encrypted like any other file, and they move with their directory.
+// Version IDs are the upload times in nanoseconds, padded to a fixed width so they sort chronologically.
+const (
+	versioningFilename = ".versioning"
+	versionIDLength    = 20
+	versionMarker      = ".v"
+)
+
+// ErrInvalidVersioningPolicy is returned when a versioning policy has negative limits.
+var ErrInvalidVersioningPolicy = errors.New("invalid versioning policy")
+
+// VersioningPolicy controls whether uploads to existing names create new versions
+// and which of the previous versions are retained.
+// If KeepVersions is positive, only that many previous versions are kept,
+// if KeepDays is positive, previous versions uploaded earlier than that many days ago are removed.
+type VersioningPolicy struct {
+	Enabled      bool `json:"enabled"`
+	KeepVersions int  `json:"keep_versions"`
+	KeepDays     int  `json:"keep_days"`
+}
+
+// Versioning returns the versioning policy of the user. Versioning is disabled by default.
+func (s Storage) Versioning(ctx context.Context, user User) (VersioningPolicy, error) {
+	ctx, span := trace.Start(ctx, "storage.Versioning")
+	defer span.End()
+
+	return s.traced(ctx).versioning(user)
+}
+
+func (s Storage) versioning(user User) (VersioningPolicy, error) {
+	data, err := os.ReadFile(filepath.Join(user.DataDir, versioningFilename))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return VersioningPolicy{}, nil
+		}
+		return VersioningPolicy{}, err
+	}
+
+	var policy VersioningPolicy
+
+	if err = json.Unmarshal(data, &policy); err != nil {
+		return VersioningPolicy{}, err
+	}
+
+	return policy, nil
+}
+
+// SetVersioning replaces the versioning policy of the user.
+// The retention limits are applied to a file the next time a version of it is created.
+func (s Storage) SetVersioning(ctx context.Context, user User, policy VersioningPolicy) error {
+	ctx, span := trace.Start(ctx, "storage.SetVersioning")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	if policy.KeepVersions < 0 || policy.KeepDays < 0 {
+		return fmt.Errorf("%w: negative retention limit", ErrInvalidVersioningPolicy)
+	}
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(user.DataDir, 0700); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(user.DataDir, versioningFilename), data, 0600)
+}
+
+// ListVersions describes the current file followed by its previous versions, newest first.
+// If publishing a new version was interrupted after the current file had been archived,
+// only the previous versions are listed.
+func (s Storage) ListVersions(ctx context.Context, user User, name string) ([]FileInfo, error) {
+	ctx, span := trace.Start(ctx, "storage.ListVersions")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := versionIDs(s.store, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []FileInfo
+
+	current, err := s.statVersion(user, key, name)
+	switch {
+	case err == nil:
+		versions = append(versions, current)
+	case !errors.Is(err, fs.ErrNotExist) || len(ids) == 0:
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if id == current.Version {
+			continue
+		}
+
+		version, err := s.statVersion(user, versionKey(key, id), name)
+		if err != nil {
+			return nil, err
+		}
+
+		version.Version = id
+
+		versions = append(versions, version)
+	}
+
+	return versions, nil
+}
+
+// DownloadVersion writes the plaintext of the byte range of the version of the file to dst.
+func (s Storage) DownloadVersion(ctx context.Context, user User, name, id string, byteRange ByteRange, dst io.Writer) (DownloadResult, error) {
+	ctx, span := trace.Start(ctx, "storage.DownloadVersion")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	key, err = s.findVersion(user, key, name, id)
+	if err != nil {
+		return DownloadResult{}, err
+	}
+
+	return s.download(ctx, user, key, name, byteRange, dst)
+}
+
+// RestoreVersion makes the version the current file, archiving the current file
+// regardless of the versioning policy. It describes the new current file.
+func (s Storage) RestoreVersion(ctx context.Context, user User, name, id string) (FileInfo, error) {
+	ctx, span := trace.Start(ctx, "storage.RestoreVersion")
+	defer span.End()
+
+	s = s.traced(ctx)
+
+	write, err := s.keyLocks.startWrite(user)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	key, err := s.resolveFile(user, name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	versionKey, err := s.findVersion(user, key, name, id)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	version, err := s.statVersion(user, versionKey, name)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	// The version is kept, so the restored file takes as much room again.
+	reservation, err := s.reserveQuota(user, version.StoredSize, s.newFiles(key))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer reservation.release()
+
+	tmp, record, dataKey, err := s.reencrypt(ctx, versionKey, key, user.Key())
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer func() {
+		_ = s.store.Delete(tmp)
+	}()
+
+	record.ContentType = version.ContentType
+
+	unlock, err := write.lock()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer unlock()
+
+	if err = s.publish(user, tmp, key, record, dataKey, true); err != nil {
+		return FileInfo{}, err
+	}
+
+	reservation.commit()
+
+	return s.stat(user, name)
+}
+
+// publish moves the temporary blob to the key and writes its metadata record.
+// If a file exists at the key, it is archived as a previous version when versioning is enabled or archive is set,
+// otherwise publishing fails with fs.ErrExist.
+func (s Storage) publish(user User, tmp, key string, record metadataRecord, dataKey []byte, archive bool) error {
+	defer s.locks.lock(key)()
+
+	policy, err := s.versioning(user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	record.Version = newVersionID(now)
+
+	name := strings.TrimPrefix(key, user.Username+"/")
+
+	current, err := s.statVersion(user, key, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err = s.store.Move(tmp, key); err != nil {
+			return err
+		}
+		return writeMetadata(s.store, key, record, dataKey)
+	}
+	if err != nil {
+		return err
+	}
+
+	if current.IsDir || !(policy.Enabled || archive) {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrExist}
+	}
+
+	// The metadata record is archived first, so a crash leaves at most a record without its file,
+	// which is ignored, or an archived version without a current file, which ListVersions still lists.
+	archived := versionKey(key, current.Version)
+
+	if err = moveMetadata(s.store, key, archived); err != nil {
+		return err
+	}
+
+	if err = s.store.Move(key, archived); err != nil {
+		return err
+	}
+
+	record.Created = current.Created
+
+	if err = s.store.Move(tmp, key); err != nil {
+		return err
+	}
+
+	if err = writeMetadata(s.store, key, record, dataKey); err != nil {
+		return err
+	}
+
+	return pruneVersions(s.store, key, policy, now)
+}
+
+// statVersion describes the file at the key, the version of a file stored without one is derived from its modification time.
+func (s Storage) statVersion(user User, key, name string) (FileInfo, error) {
+	info, isDir, err := s.lookup(key)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	fileInfo, err := statFile(s.store, info, name, isDir, user.Key())
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	if fileInfo.Version == "" && !fileInfo.IsDir {
+		fileInfo.Version = newVersionID(info.Modified)
+	}
+
+	return fileInfo, nil
+}
+
+// findVersion returns the key of the version of the file, which is the file itself for the current version.
+func (s Storage) findVersion(user User, key, name, id string) (string, error) {
+	current, err := s.statVersion(user, key, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if current.IsDir {
+		return "", fmt.Errorf("%w: %q is a directory", ErrInvalidPath, name)
+	}
+
+	if err == nil && id == current.Version {
+		return key, nil
+	}
+
+	if !isVersionID(id) {
+		return "", fmt.Errorf("version %q: %w", id, fs.ErrNotExist)
+	}
+
+	key = versionKey(key, id)
+
+	if _, err = s.store.Stat(key); err != nil {
+		return "", err
+	}
+
+	return key, nil
+}
+
+// pruneVersions removes the previous versions of the file that the policy doesn't retain.
+func pruneVersions(store blob.Store, key string, policy VersioningPolicy, now time.Time) error {
+	ids, err := versionIDs(store, key)
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.AddDate(0, 0, -policy.KeepDays)
+
+	for i, id := range ids {
+		keep := policy.KeepVersions <= 0 || i < policy.KeepVersions
+
+		if policy.KeepDays > 0 && versionTime(id).Before(cutoff) {
+			keep = false
+		}
+
+		if keep {
+			continue
+		}
+
+		if err = removeVersion(store, key, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versionIDs returns the IDs of the previous versions of the file, newest first.
+func versionIDs(store blob.Store, key string) ([]string, error) {
+	prefix := versionKey(key, "")
+
+	infos, err := store.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+
+	for _, info := range infos {
+		if id := strings.TrimPrefix(info.Key, prefix); isVersionID(id) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	return ids, nil
+}
+
+// moveVersions moves the previous versions of a renamed file along with it.
+func moveVersions(store blob.Store, src, dst string) error {
+	ids, err := versionIDs(store, src)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = blob.Move(store, versionKey(src, id), versionKey(dst, id)); err != nil {
+			return err
+		}
+
+		if err = moveMetadata(store, versionKey(src, id), versionKey(dst, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeVersions removes all the previous versions of the file.
+func removeVersions(store blob.Store, key string) error {
+	ids, err := versionIDs(store, key)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err = removeVersion(store, key, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeVersion(store blob.Store, key, id string) error {
+	if err := store.Delete(versionKey(key, id)); err != nil {
+		return err
+	}
+
+	return removeMetadata(store, versionKey(key, id))
+}
+
+func versionKey(key, id string) string {
+	return path.Join(path.Dir(key), "."+path.Base(key)+versionMarker+id)
+}
+
+// isVersionFilename reports whether the entry holds a previous version of a file.
+func isVersionFilename(name string) bool {
+	i := len(name) - versionIDLength - len(versionMarker)
+
+	return strings.HasPrefix(name, ".") && i > 1 && name[i:i+len(versionMarker)] == versionMarker && isVersionID(name[i+len(versionMarker):])
+}
+
+func newVersionID(t time.Time) string {
+	return fmt.Sprintf("%0*d", versionIDLength, t.UnixNano())
+}
+
+func isVersionID(id string) bool {
+	if len(id) != versionIDLength {
+		return false
+	}
+
+	_, err := strconv.ParseUint(id, 10, 64)
+
+	return err == nil
+}
+
+func versionTime(id string) time.Time {
+	nanos, _ := strconv.ParseInt(id, 10, 64)
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorage_Versions(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	name := "a/" + fileName
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader("v2")); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("got %v with versioning disabled, want %v", err, os.ErrExist)
+	}
+
+	if err := storage.SetVersioning(context.Background(), user, VersioningPolicy{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := storage.InitiateUpload(context.Background(), user, FileMetadata{Filename: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.AppendUpload(context.Background(), user, session.ID, 0, strings.NewReader("v3")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.CompleteUpload(context.Background(), user, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListVersions(context.Background(), user, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, storage, user, name, versions), []string{"v3", "v2", "v1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got versions %q, want %q", got, want)
+	}
+
+	if got, want := versions[2].Created, versions[0].Created; !got.Equal(want) {
+		t.Fatalf("got created %v of the first version, want %v", got, want)
+	}
+
+	if _, err = storage.DownloadVersion(context.Background(), user, name, "01234567890123456789", ByteRange{}, &strings.Builder{}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v for a missing version, want %v", err, os.ErrNotExist)
+	}
+
+	restored, err := storage.RestoreVersion(context.Background(), user, name, versions[2].Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored.Version == versions[0].Version {
+		t.Fatalf("got version %s of the restored file, want a new one", restored.Version)
+	}
+
+	if versions, err = storage.ListVersions(context.Background(), user, name); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, storage, user, name, versions), []string{"v1", "v3", "v2", "v1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got versions %q after restore, want %q", got, want)
+	}
+
+	if err = storage.Rename(context.Background(), user, "a", "b", false); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := "b/" + fileName
+
+	if versions, err = storage.ListVersions(context.Background(), user, renamed); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(versions), 4; got != want {
+		t.Fatalf("got %d versions after rename, want %d", got, want)
+	}
+
+	page, err := storage.List(context.Background(), user, "", ListOptions{Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := paths(page.Entries), []string{"b/", renamed}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if err = storage.Delete(context.Background(), user, renamed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.Delete(context.Background(), user, "b"); err != nil {
+		t.Fatalf("got %v deleting the directory, want no error", err)
+	}
+}
+
+func TestStorage_Versions_Overwrite(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	if err := storage.SetVersioning(context.Background(), user, VersioningPolicy{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(name, content string) {
+		t.Helper()
+
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: name}, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versionContents := func(name string) string {
+		t.Helper()
+
+		versions, err := storage.ListVersions(context.Background(), user, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return strings.Join(contents(t, storage, user, name, versions), ",")
+	}
+
+	upload(file2Name, "d1")
+	upload(file2Name, "d2")
+	upload(fileName, "s1")
+
+	// The versions of a replaced file go away with it.
+	if err := storage.Copy(context.Background(), user, fileName, file2Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := versionContents(file2Name), "s1"; got != want {
+		t.Fatalf("got versions %q after a copy, want %q", got, want)
+	}
+
+	upload(file2Name, "d3")
+	upload(fileName, "s2")
+
+	if err := storage.Rename(context.Background(), user, fileName, file2Name, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := versionContents(file2Name), "s2,s1"; got != want {
+		t.Fatalf("got versions %q after a rename, want %q", got, want)
+	}
+}
+
+func TestStorage_Versions_Retention(t *testing.T) {
+	t.Parallel()
+
+	storage, user := newTestStorage(t, Quota{})
+
+	if err := storage.SetVersioning(context.Background(), user, VersioningPolicy{KeepVersions: -1}); !errors.Is(err, ErrInvalidVersioningPolicy) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidVersioningPolicy)
+	}
+
+	if err := storage.SetVersioning(context.Background(), user, VersioningPolicy{Enabled: true, KeepVersions: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"v1", "v2", "v3", "v4"} {
+		if _, err := storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, err := storage.ListVersions(context.Background(), user, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, storage, user, fileName, versions), []string{"v4", "v3", "v2"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got versions %q, want %q", got, want)
+	}
+
+	// A version uploaded long ago is removed by the age limit on the next upload.
+	key := user.Username + "/" + fileName
+
+	oldVersion := versionKey(key, newVersionID(time.Now().AddDate(0, 0, -10)))
+
+	if err = storage.store.Move(versionKey(key, versions[2].Version), oldVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = moveMetadata(storage.store, versionKey(key, versions[2].Version), oldVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.SetVersioning(context.Background(), user, VersioningPolicy{Enabled: true, KeepDays: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader("v5")); err != nil {
+		t.Fatal(err)
+	}
+
+	if versions, err = storage.ListVersions(context.Background(), user, fileName); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, storage, user, fileName, versions), []string{"v5", "v4", "v3"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got versions %q, want %q", got, want)
+	}
+}
+
+func TestAuthenticator_ChangePassphrase_Versions(t *testing.T) {
+	t.Parallel()
+
+	authenticator, masterKey := newAuthenticator(t)
+
+	storage := NewStorage(authenticator.store, authenticator, authenticator.keyLocks, testUploadTimeout, Quota{}, ShareLinkPolicy{})
+
+	token, err := authenticator.AddUser(context.Background(), "user", "passphrase", masterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := authenticator.ValidateToken(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = storage.SetVersioning(context.Background(), user, VersioningPolicy{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"v1", "v2"} {
+		if _, err = storage.Upload(context.Background(), user, FileMetadata{Filename: fileName}, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if token, err = authenticator.ChangePassphrase(context.Background(), "user", "passphrase", "new passphrase"); err != nil {
+		t.Fatal(err)
+	}
+
+	if user, err = authenticator.ValidateToken(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	versions, err := storage.ListVersions(context.Background(), user, fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := contents(t, storage, user, fileName, versions), []string{"v2", "v1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got versions %q, want %q", got, want)
+	}
+}
+
+// contents downloads the versions of the file.
+func contents(t *testing.T, storage *Storage, user User, name string, versions []FileInfo) []string {
+	t.Helper()
+
+	result := make([]string, 0, len(versions))
+
+	for _, version := range versions {
+		dst := &strings.Builder{}
+
+		if _, err := storage.DownloadVersion(context.Background(), user, name, version.Version, ByteRange{}, dst); err != nil {
+			t.Fatal(err)
+		}
+
+		result = append(result, dst.String())
+	}
+
+	return result
+}
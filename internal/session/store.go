@@ -0,0 +1,163 @@
+package session
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+const idLength = 32
+
+var ErrNotFound = errors.New("session not found")
+
+type (
+	Session struct {
+		Username  string
+		Key       []byte
+		ExpiresAt time.Time
+	}
+
+	Store interface {
+		Create(username string, key []byte) (id string, err error)
+		Get(id string) (Session, error)
+		Revoke(id string)
+		RevokeUser(username string)
+	}
+
+	// MemoryStore keeps sessions in memory, so they are lost on restart.
+	// When the limit is reached, the oldest session is evicted.
+	MemoryStore struct {
+		ttl      time.Duration
+		limit    int
+		sessions map[string]*list.Element
+		order    *list.List
+		now      func() time.Time
+		mu       sync.Mutex
+	}
+
+	entry struct {
+		id      string
+		session Session
+	}
+)
+
+func NewMemoryStore(ttl time.Duration, limit int) *MemoryStore {
+	return &MemoryStore{
+		ttl:      ttl,
+		limit:    limit,
+		sessions: make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Create stores a copy of the key under a new random session ID.
+func (s *MemoryStore) Create(username string, key []byte) (string, error) {
+	rawID, err := rand.Key(idLength)
+	if err != nil {
+		return "", err
+	}
+
+	id := string(rawID)
+
+	session := Session{
+		Username:  username,
+		Key:       append([]byte(nil), key...),
+		ExpiresAt: s.now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.removeExpired()
+
+	for s.limit > 0 && s.order.Len() >= s.limit {
+		s.remove(s.order.Front())
+	}
+
+	s.sessions[id] = s.order.PushBack(&entry{id: id, session: session})
+
+	return id, nil
+}
+
+// Get returns the session with the given ID if it exists and has not expired.
+// The returned key is a copy.
+func (s *MemoryStore) Get(id string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	element, ok := s.sessions[id]
+	if !ok {
+		return Session{}, ErrNotFound
+	}
+
+	session := element.Value.(*entry).session
+
+	if !s.now().Before(session.ExpiresAt) {
+		s.remove(element)
+		return Session{}, ErrNotFound
+	}
+
+	session.Key = append([]byte(nil), session.Key...)
+
+	return session, nil
+}
+
+// Revoke removes the session with the given ID.
+func (s *MemoryStore) Revoke(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.sessions[id]; ok {
+		s.remove(element)
+	}
+}
+
+// RevokeUser removes all sessions of the given user.
+func (s *MemoryStore) RevokeUser(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for element := s.order.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*entry).session.Username == username {
+			s.remove(element)
+		}
+		element = next
+	}
+}
+
+// Len returns the number of stored sessions, including expired ones not yet evicted.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
+
+// removeExpired removes expired sessions.
+// Sessions share the same TTL, so the list is ordered by expiration time.
+func (s *MemoryStore) removeExpired() {
+	now := s.now()
+
+	for element := s.order.Front(); element != nil; element = s.order.Front() {
+		if now.Before(element.Value.(*entry).session.ExpiresAt) {
+			return
+		}
+		s.remove(element)
+	}
+}
+
+// remove deletes the element and wipes the key it holds.
+func (s *MemoryStore) remove(element *list.Element) {
+	entry := s.order.Remove(element).(*entry)
+
+	for i := range entry.session.Key {
+		entry.session.Key[i] = 0
+	}
+
+	delete(s.sessions, entry.id)
+}
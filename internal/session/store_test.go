@@ -0,0 +1,112 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("super secret key")
+
+func TestMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore(time.Hour, 10)
+
+	id, err := store.Create("user", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := store.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Username != "user" {
+		t.Fatalf("got username %q, want %q", session.Username, "user")
+	}
+
+	if !bytes.Equal(session.Key, testKey) {
+		t.Fatalf("got key %q, want %q", session.Key, testKey)
+	}
+
+	store.Revoke(id)
+
+	if _, err = store.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestMemoryStore_Expired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	store := NewMemoryStore(time.Minute, 10)
+	store.now = func() time.Time { return now }
+
+	id, err := store.Create("user", testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(time.Minute)
+
+	if _, err = store.Get(id); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrNotFound)
+	}
+
+	if got := store.Len(); got != 0 {
+		t.Fatalf("got %d sessions, want 0", got)
+	}
+}
+
+func TestMemoryStore_Limit(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore(time.Hour, 2)
+
+	ids := make([]string, 3)
+
+	for i := range ids {
+		id, err := store.Create("user", testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+
+	if got := store.Len(); got != 2 {
+		t.Fatalf("got %d sessions, want 2", got)
+	}
+
+	if _, err := store.Get(ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want oldest session to be evicted", err)
+	}
+
+	for _, id := range ids[1:] {
+		if _, err := store.Get(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryStore_RevokeUser(t *testing.T) {
+	t.Parallel()
+
+	store := NewMemoryStore(time.Hour, 10)
+
+	for _, username := range []string{"user", "user", "user-2"} {
+		if _, err := store.Create(username, testKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.RevokeUser("user")
+
+	if got := store.Len(); got != 1 {
+		t.Fatalf("got %d sessions, want 1", got)
+	}
+}
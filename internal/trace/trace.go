@@ -0,0 +1,189 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KirillMironov/beaver/internal/log"
+	"github.com/KirillMironov/beaver/internal/rand"
+)
+
+const (
+	traceIDSize = 16
+	spanIDSize  = 8
+)
+
+type (
+	// SpanData describes an ended span. ParentID is empty for the root span of a trace.
+	SpanData struct {
+		TraceID    string            `json:"trace_id"`
+		SpanID     string            `json:"span_id"`
+		ParentID   string            `json:"parent_id,omitempty"`
+		Name       string            `json:"name"`
+		Start      time.Time         `json:"start"`
+		End        time.Time         `json:"end"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Error      string            `json:"error,omitempty"`
+	}
+
+	// Exporter sends ended spans out of the process.
+	Exporter interface {
+		Export(span SpanData) error
+	}
+
+	// Tracer starts the root spans of traces. A nil Tracer or a Tracer without an exporter records nothing.
+	Tracer struct {
+		exporter Exporter
+		logger   log.Logger
+	}
+
+	// Span measures an operation. Methods of a nil Span do nothing, so untraced calls need no checks.
+	Span struct {
+		tracer *Tracer
+		mu     sync.Mutex
+		data   SpanData
+	}
+
+	// SpanContext identifies a span of another process a trace continues from.
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+func NewTracer(exporter Exporter, logger log.Logger) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+		logger:   logger,
+	}
+}
+
+// Start starts a span as a child of the span in ctx, of the remote span in ctx or as the root of a new trace.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil || t.exporter == nil {
+		return ctx, nil
+	}
+
+	if parent := spanFromContext(ctx); parent != nil {
+		return parent.tracer.start(ctx, name, parent.data.TraceID, parent.data.SpanID)
+	}
+
+	if remote, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+		return t.start(ctx, name, remote.TraceID, remote.SpanID)
+	}
+
+	return t.start(ctx, name, randomID(traceIDSize), "")
+}
+
+// Start starts a span as a child of the span in ctx. Without a span in ctx nothing is traced.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := spanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+
+	return parent.tracer.start(ctx, name, parent.data.TraceID, parent.data.SpanID)
+}
+
+func (t *Tracer) start(ctx context.Context, name, traceID, parentID string) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		data: SpanData{
+			TraceID:  traceID,
+			SpanID:   randomID(spanIDSize),
+			ParentID: parentID,
+			Name:     name,
+			Start:    time.Now().UTC(),
+		},
+	}
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// WithRemoteParent returns a context the next root span started with continues the remote trace from.
+func WithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+// ParseTraceparent parses a W3C traceparent header, "00-<trace id>-<parent id>-<flags>".
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || parts[0] != "00" || !validID(parts[1], traceIDSize) || !validID(parts[2], spanIDSize) {
+		return SpanContext{}, false
+	}
+
+	return SpanContext{TraceID: parts[1], SpanID: parts[2]}, true
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+
+	s.data.Attributes[key] = value
+}
+
+// SetError marks the span as failed. A nil error is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data.Error = err.Error()
+}
+
+// End ends the span and exports it. Export failures are logged, they never fail the traced operation.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.data.End = time.Now().UTC()
+	data := s.data
+	s.mu.Unlock()
+
+	if err := s.tracer.exporter.Export(data); err != nil {
+		s.tracer.logger.Errorf("failed to export span %q: %v", data.Name, err)
+	}
+}
+
+func spanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+
+	span, _ := ctx.Value(spanKey{}).(*Span)
+
+	return span
+}
+
+func randomID(size int) string {
+	id, err := rand.Bytes(size)
+	if err != nil {
+		return strings.Repeat("0", 2*size)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+func validID(id string, size int) bool {
+	decoded, err := hex.DecodeString(id)
+	return err == nil && len(decoded) == size && strings.Trim(id, "0") != ""
+}
@@ -0,0 +1,123 @@
+package trace
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/KirillMironov/beaver/internal/log/observer"
+)
+
+func TestTracer_Start(t *testing.T) {
+	t.Parallel()
+
+	output := &strings.Builder{}
+
+	tracer := NewTracer(NewWriterExporter(output), observer.New())
+
+	ctx, root := tracer.Start(context.Background(), "root")
+
+	childCtx, child := Start(ctx, "child")
+	child.SetAttribute("key", "value")
+	child.SetError(errors.New("failed"))
+
+	_, grandchild := Start(childCtx, "grandchild")
+	grandchild.End()
+	child.End()
+	root.End()
+
+	spans := readSpans(t, output.String())
+
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	grandchildData, childData, rootData := spans[0], spans[1], spans[2]
+
+	if rootData.ParentID != "" || childData.ParentID != rootData.SpanID || grandchildData.ParentID != childData.SpanID {
+		t.Fatalf("got spans %+v, want a chain of parents", spans)
+	}
+
+	for _, span := range spans {
+		if span.TraceID != rootData.TraceID {
+			t.Fatalf("got trace id %q, want %q", span.TraceID, rootData.TraceID)
+		}
+		if span.End.Before(span.Start) {
+			t.Fatalf("span %q ends before it starts", span.Name)
+		}
+	}
+
+	if childData.Attributes["key"] != "value" || childData.Error != "failed" {
+		t.Fatalf("got child %+v, want the attribute and the error", childData)
+	}
+}
+
+func TestTracer_Start_RemoteParent(t *testing.T) {
+	t.Parallel()
+
+	output := &strings.Builder{}
+
+	tracer := NewTracer(NewWriterExporter(output), observer.New())
+
+	parent, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("valid traceparent was rejected")
+	}
+
+	_, span := tracer.Start(WithRemoteParent(context.Background(), parent), "root")
+	span.End()
+
+	spans := readSpans(t, output.String())
+
+	if len(spans) != 1 || spans[0].TraceID != parent.TraceID || spans[0].ParentID != parent.SpanID {
+		t.Fatalf("got spans %+v, want a continuation of %+v", spans, parent)
+	}
+
+	for _, header := range []string{"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4bf92f3577b34da6-00f067aa0ba902b7-01"} {
+		if _, ok = ParseTraceparent(header); ok {
+			t.Fatalf("invalid traceparent %q was accepted", header)
+		}
+	}
+}
+
+func TestStart_Untraced(t *testing.T) {
+	t.Parallel()
+
+	ctx, span := Start(context.Background(), "span")
+
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failed"))
+	span.End()
+
+	if span != nil || ctx != context.Background() {
+		t.Fatal("got a span without a parent")
+	}
+
+	var tracer *Tracer
+
+	if _, span = tracer.Start(context.Background(), "span"); span != nil {
+		t.Fatal("got a span from a nil tracer")
+	}
+}
+
+func readSpans(t *testing.T, output string) []SpanData {
+	t.Helper()
+
+	var spans []SpanData
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+
+	for scanner.Scan() {
+		var span SpanData
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatal(err)
+		}
+		spans = append(spans, span)
+	}
+
+	return spans
+}